@@ -1,26 +1,211 @@
 package main
 
 import (
+	"flag"
 	"net"
+	"net/netip"
+	"os"
 	"phreaking/internal/core"
+	"phreaking/internal/eir"
+	"phreaking/internal/geofence"
+	"phreaking/internal/lmf"
+	"phreaking/internal/sepp"
+	"phreaking/internal/smf"
+	"phreaking/internal/smsf"
+	"phreaking/internal/udm"
+	"phreaking/internal/upf"
+	"phreaking/pkg/lpp"
+	"phreaking/pkg/nas"
+	"strings"
+	"time"
+	// NITZ time zone without tzdata in the image
+	_ "time/tzdata"
 
 	"go.uber.org/zap"
 )
 
 func main() {
+	n2Addr := flag.String("n2", ":3399", "address gNBs connect to")
+	n3Addr := flag.String("n3", ":2152", "GTP-U address of the UPF")
+	plmnId := flag.String("plmn", "00101", "home PLMN of the subscribers, MCC and MNC")
+	seppAddr := flag.String("sepp", ":3400", "address the SEPP answers visited networks on")
+	dnHost := flag.String("dn", "127.0.0.1", "host of the local data network")
+	flag.Parse()
+
 	logger := zap.Must(zap.NewDevelopment())
 	defer logger.Sync()
 	log := logger.Sugar()
 
-	l, err := net.Listen("tcp4", ":3399")
+	home, err := nas.ParsePlmnId(*plmnId)
+	if err != nil {
+		log.Fatalf("invalid home PLMN %q: %v", *plmnId, err)
+		return
+	}
+
+	l, err := net.Listen("tcp4", *n2Addr)
 	if err != nil {
 		log.Fatalf("tcp server failed to listen: %v", err)
 		return
 	}
 	defer l.Close()
 
+	// First DNN is the default
+	dnns := []smf.Dnn{
+		{Name: "internet", Ipv4Pool: netip.MustParsePrefix("10.45.0.0/16"), Ipv6Pool: netip.MustParsePrefix("2001:db8:45::/48"),
+			FiveQi: 9, ArpPriority: 8, Ambr: nas.AmbrType{Uplink: 100_000_000, Downlink: 200_000_000}},
+		{Name: "ims", Ipv4Pool: netip.MustParsePrefix("10.46.0.0/16"), Ipv6Pool: netip.MustParsePrefix("2001:db8:46::/48"),
+			FiveQi: 5, ArpPriority: 1, Ambr: nas.AmbrType{Uplink: 1_000_000, Downlink: 1_000_000}},
+		// Emergency sessions only, not part of any subscription
+		{Name: "sos", Ipv4Pool: netip.MustParsePrefix("10.47.0.0/16"), Ipv6Pool: netip.MustParsePrefix("2001:db8:47::/48"),
+			FiveQi: 5, ArpPriority: 1, Ambr: nas.AmbrType{Uplink: 1_000_000, Downlink: 1_000_000}},
+	}
+	sm, err := smf.New(*n3Addr, dnns)
+	if err != nil {
+		log.Fatalf("cannot configure SMF: %v", err)
+		return
+	}
+
+	// Hosts of the data network, served by the local data network (cmd/dn).
+	// Every other destination is refused.
+	up := &upf.Upf{Logger: logger, Hosts: map[string]string{
+		"gopher.website.org:70": net.JoinHostPort(*dnHost, "70"),
+		"example.org:80":        net.JoinHostPort(*dnHost, "80"),
+	}, Timeout: 3 * time.Second, MaxResponseSize: 16 << 10}
+
+	embb := nas.SNssaiType{Sst: nas.SstEmbb}
+	urllc := nas.SNssaiType{Sst: nas.SstUrllc, Sd: 1}
+
+	// Ethernet sessions and the URLLC slice only for the test subscriber, which
+	// may locate another UE
+	ipTypes := []nas.PduSesType{nas.PduSesIpv4, nas.PduSesIpv6, nas.PduSesIpv4v6, nas.PduSesUnstructured}
+	embbSlice := udm.SubscribedSNssai{SNssai: embb, Default: true, Dnns: []string{"internet", "ims"}}
+	um := &udm.Udm{
+		Default: udm.Subscription{AllowedPduSesTypes: ipTypes, Nssai: []udm.SubscribedSNssai{embbSlice}},
+		Subscribers: map[string]udm.Subscription{
+			"imsi-001010000000001": {AllowedPduSesTypes: append(ipTypes, nas.PduSesEthernet),
+				Nssai:      []udm.SubscribedSNssai{embbSlice, {SNssai: urllc, Dnns: []string{"internet"}}},
+				LcsTargets: []string{"imsi-001010000000002"}},
+		},
+	}
+	// Subscribers of the UEs in docker-compose, MSIN 0 to 9
+	for msin := uint(0); msin < 10; msin++ {
+		supi := nas.MobileIdType{Mcc: home.Mcc, Mnc: home.Mnc, Msin: msin}.Supi()
+		if _, ok := um.Subscribers[supi]; !ok {
+			um.Subscribers[supi] = um.Default
+		}
+	}
+	// LCS client keys are provisioned per subscriber, each UE only holds its own
+	for _, entry := range strings.Split(os.Getenv("PHREAKING_LCS_CLIENT_KEYS"), ",") {
+		supi, key, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || key == "" {
+			continue
+		}
+		sub, ok := um.Subscribers[supi]
+		if !ok {
+			log.Warnf("LCS client key for unknown subscriber %s ignored", supi)
+			continue
+		}
+		sub.LcsClientKey = []byte(key)
+		um.Subscribers[supi] = sub
+	}
+
+	// RAN sharing partner with subscribers of its own, eMBB internet only
+	partnerUm := &udm.Udm{Default: udm.Subscription{AllowedPduSesTypes: ipTypes,
+		Nssai: []udm.SubscribedSNssai{{SNssai: embb, Default: true, Dnns: []string{"internet"}}}}}
+
 	// 0x00ff10 = MCC 001, MNC 01
-	amf := core.Amf{Logger: logger, AmfName: "CORE", GuamPlmn: 0x00ff10, AmfRegionId: 1, AmfSetId: 1, AmfPtr: 0, AmfCap: 255}
+	plmn := uint32(0x00ff10)
+	plmns := []*core.Plmn{
+		{Plmn: plmn, Id: home, Udm: um},
+		// 0x00f170 = MCC 001, MNC 07, no null ciphering and integrity protection
+		{Plmn: 0x00f170, Id: nas.PlmnIdType{Mcc: 1, Mnc: 7}, Udm: partnerUm, EaAlgs: []uint8{2, 1}, IaAlgs: []uint8{2, 1}},
+	}
+	areas := [][]nas.TaiType{
+		{{Plmn: plmn, Tac: 0}, {Plmn: plmn, Tac: 1}},
+		{{Plmn: plmn, Tac: 2}, {Plmn: plmn, Tac: 3}},
+	}
+
+	// Geofence events are posted to PHREAKING_GEOFENCE_WEBHOOK, or logged if unset
+	var notifier geofence.Notifier = geofence.LogNotifier{Logger: logger}
+	if url := os.Getenv("PHREAKING_GEOFENCE_WEBHOOK"); url != "" {
+		notifier = geofence.NewWebhook(logger, url, 3*time.Second)
+	}
+	fences := map[string][]geofence.Fence{
+		"imsi-001010000000001": {
+			{Id: "campus", Center: geofence.Point{Latitude: 63.4195, Longitude: 10.4023}, Radius: 500, Dwell: 10 * time.Minute},
+			{Id: "harbour", Polygon: []geofence.Point{{Latitude: 63.4380, Longitude: 10.3900}, {Latitude: 63.4380, Longitude: 10.4100},
+				{Latitude: 63.4330, Longitude: 10.4100}, {Latitude: 63.4330, Longitude: 10.3900}}},
+		},
+	}
+	gf, err := geofence.NewMonitor(fences, notifier)
+	if err != nil {
+		log.Fatalf("cannot configure geofences: %v", err)
+		return
+	}
+
+	// Antenna sites of the cells of gNB 0, 1 and 2 (cell ID is the gNB ID followed by 4 bits)
+	lm := &lmf.Lmf{Methods: []lpp.PositioningMethodType{lpp.MethodEcid}, Cells: map[uint64]lmf.Cell{
+		0x00: {Latitude: 63.4187, Longitude: 10.4027, Altitude: 60},
+		0x10: {Latitude: 63.4305, Longitude: 10.3951, Altitude: 20},
+		0x20: {Latitude: 63.4362, Longitude: 10.4012, Altitude: 10},
+	}}
+
+	// SEPPs of the home networks of roaming UEs
+	roamingPeers := &sepp.Client{Peers: map[nas.PlmnIdType]string{
+		{Mcc: 1, Mnc: 1}: "127.0.0.1:3400",
+		{Mcc: 2, Mnc: 2}: "127.0.0.1:3401",
+	}}
+	// Visited networks our subscribers may roam in, only the internet DNN in 00202
+	agreements := map[nas.PlmnIdType]sepp.Agreement{
+		{Mcc: 1, Mnc: 1}: {Allowed: true},
+		{Mcc: 2, Mnc: 2}: {Allowed: true, Dnns: []string{"internet"}},
+		{Mcc: 3, Mnc: 3}: {Allowed: false},
+	}
+	sl, err := net.Listen("tcp4", *seppAddr)
+	if err != nil {
+		log.Fatalf("SEPP failed to listen: %v", err)
+		return
+	}
+	defer sl.Close()
+	homeSepp := sepp.NewHome(logger, home, um, agreements)
+
+	// Equipment of the test devices is known, one reported stolen
+	equipment := &eir.Eir{Lists: map[string]eir.EquipmentStatus{
+		"35349006":       eir.White,
+		"35349006987331": eir.Black,
+		// Devices without type approval
+		"99000001": eir.Grey,
+	}, Unlisted: eir.White}
+
+	// Banned subscriber, PLMN and tracking area
+	barring := core.Barring{
+		Supis: map[string]bool{"imsi-001010000000666": true},
+		Plmns: map[nas.PlmnIdType]bool{{Mcc: 3, Mnc: 3}: true},
+		Tacs:  map[uint32]bool{9: true},
+	}
+
+	// Time zone of the cells
+	tz, err := time.LoadLocation("Europe/Oslo")
+	if err != nil {
+		log.Warnf("NITZ disabled: %v", err)
+	}
+
+	amf := core.Amf{Logger: logger, AmfName: "CORE", Plmns: plmns, AmfRegionId: 1, AmfSetId: 1, AmfPtr: 0, AmfCap: 255,
+		Nssai: []nas.SNssaiType{embb, urllc}, RegistrationAreas: areas, Registry: core.NewRegistry(), Smf: sm, Upf: up,
+		Geofence: gf, Lmf: lm, Smsf: smsf.New(32, 1024, 24*time.Hour), NetworkName: nas.NetworkNameType{Full: "Phreaking Mobile", Short: "Phreaking"},
+		TimeZone: tz, Emergency: core.EmergencyAuthenticated, EmergencyDnn: "sos", Sepp: roamingPeers,
+		Eir: equipment, Barring: barring}
+
+	go amf.ExpireIdleUEs()
+	go amf.ReportStats(5 * time.Minute)
+	go func() {
+		err := homeSepp.Serve(sl)
+		log.Fatalf("SEPP failed: %v", err)
+	}()
+	go func() {
+		err := up.ServeN3(sm.UpfAddr, sm)
+		log.Fatalf("UPF failed: %v", err)
+	}()
 
 	for {
 		c, err := l.Accept()
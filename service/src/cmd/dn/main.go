@@ -0,0 +1,86 @@
+// dn is the local data network behind the UPF, it serves the gopher and
+// http hosts the core allows PDU sessions to reach
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const menu = "iWelcome to the Phreaking data network\t\terror.host\t1\r\n" +
+	"0About\t/about\tgopher.website.org\t70\r\n" +
+	"hExample\tURL:http://example.org/\terror.host\t1\r\n" +
+	".\r\n"
+
+const about = "This data network is local to the Phreaking core, nothing leaves it.\r\n.\r\n"
+
+const page = "<!doctype html>\n<html><head><title>Example Domain</title></head>\n" +
+	"<body><h1>Example Domain</h1><p>This domain is served by the local data network.</p></body></html>\n"
+
+func main() {
+	gopherAddr := flag.String("gopher", ":70", "gopher address of gopher.website.org")
+	httpAddr := flag.String("http", ":80", "http address of example.org")
+	flag.Parse()
+
+	logger := zap.Must(zap.NewDevelopment())
+	defer logger.Sync()
+	log := logger.Sugar()
+
+	go func() {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/" {
+				http.NotFound(w, r)
+				return
+			}
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			fmt.Fprint(w, page)
+		})
+		srv := &http.Server{Addr: *httpAddr, Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+		log.Fatalf("http server failed: %v", srv.ListenAndServe())
+	}()
+
+	l, err := net.Listen("tcp", *gopherAddr)
+	if err != nil {
+		log.Fatalf("gopher server failed to listen: %v", err)
+		return
+	}
+	defer l.Close()
+
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			log.Warnf("connection for listener failed: %v", err)
+			return
+		}
+		go serveGopher(c)
+	}
+}
+
+// serveGopher answers a single selector
+func serveGopher(c net.Conn) {
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+
+	line, err := bufio.NewReader(c).ReadString('\n')
+	if err != nil {
+		return
+	}
+	selector, _, _ := strings.Cut(strings.TrimRight(line, "\r\n"), "\t")
+
+	switch selector {
+	case "", "/":
+		fmt.Fprint(c, menu)
+	case "/about":
+		fmt.Fprint(c, about)
+	default:
+		fmt.Fprint(c, "3Selector not found\t\terror.host\t1\r\n.\r\n")
+	}
+}
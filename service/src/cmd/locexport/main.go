@@ -0,0 +1,81 @@
+// locexport writes the location history of a UE as GeoJSON or GPX, using
+// the gRPC API of the UE
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"phreaking/internal/ue/pb"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+)
+
+var formats = map[string]pb.ExportFormat{"geojson": pb.ExportFormat_GEOJSON, "gpx": pb.ExportFormat_GPX}
+var stores = map[string]pb.LocationStore{"ue": pb.LocationStore_STORE_UE, "network": pb.LocationStore_STORE_NETWORK}
+
+func main() {
+	addr := flag.String("addr", "localhost:9930", "gRPC address of the UE")
+	format := flag.String("format", "geojson", "geojson or gpx")
+	store := flag.String("store", "ue", "ue or network")
+	supi := flag.String("supi", "", "UE to export from the network store, the UE itself if empty")
+	from := flag.String("from", "", "RFC 3339 start of the time range")
+	to := flag.String("to", "", "RFC 3339 end of the time range")
+	out := flag.String("o", "", "output file, stdout if empty")
+	flag.Parse()
+
+	req := &pb.ExportRequest{Supi: *supi}
+	var ok bool
+	if req.Format, ok = formats[*format]; !ok {
+		fail("unknown format %q", *format)
+	}
+	if req.Store, ok = stores[*store]; !ok {
+		fail("unknown store %q", *store)
+	}
+	req.From = parseTime(*from)
+	req.To = parseTime(*to)
+
+	conn, err := grpc.Dial(*addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		fail("cannot connect: %v", err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	ctx = metadata.AppendToOutgoingContext(ctx, "auth", os.Getenv("PHREAKING_GRPC_PASS"))
+
+	res, err := pb.NewLocationClient(conn).ExportLocations(ctx, req)
+	if err != nil {
+		fail("export failed: %v", err)
+	}
+
+	if *out == "" {
+		os.Stdout.Write(res.Data)
+		return
+	}
+	err = os.WriteFile(*out, res.Data, 0644)
+	if err != nil {
+		fail("%v", err)
+	}
+}
+
+func parseTime(s string) int64 {
+	if s == "" {
+		return 0
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		fail("invalid time %q: %v", s, err)
+	}
+	return t.UnixMilli()
+}
+
+func fail(format string, args ...any) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
+}
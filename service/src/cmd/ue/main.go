@@ -2,6 +2,7 @@ package main
 
 import (
 	"errors"
+	"flag"
 	"fmt"
 	"net"
 	"os"
@@ -11,6 +12,8 @@ import (
 	"phreaking/internal/ue/pb"
 	"phreaking/pkg/nas"
 	"phreaking/pkg/parser"
+	"strconv"
+	"strings"
 	"time"
 
 	"go.uber.org/zap"
@@ -18,20 +21,56 @@ import (
 	"google.golang.org/grpc/reflection"
 )
 
-func handleConnection(logger *zap.Logger, c net.Conn) {
+// Settings of the UE from the command line
+type config struct {
+	emergency bool
+	home      nas.PlmnIdType
+	msin      uint
+	imeisv    string
+	// Addresses of the gNBs the UE may be handed over to
+	handoverGnbs []string
+}
+
+// NAS messages only accepted integrity protected, all sent after the
+// security mode procedure
+var protectedNAS = map[nas.NasMsgType]bool{
+	nas.InitialContextSetupRequestRegAccept: true,
+	nas.ServiceAccept:                       true,
+	nas.PDUSessionEstReject:                 true,
+	nas.PDUSessionModificationCommand:       true,
+	nas.PDUSessionModificationReject:        true,
+	nas.PDUSessionResourceReleaseCommand:    true,
+	nas.PDUSessionReleaseReject:             true,
+	nas.ConfigurationUpdateCommand:          true,
+	nas.LocationUpdateAck:                   true,
+	nas.LocationReportResponse:              true,
+	nas.RRCHandoverCommand:                  true,
+	// LPP requests and mobile terminated SMS
+	nas.DLNASTransport: true,
+}
+
+// A connection without any frame for this long is closed, location updates
+// and their acknowledgements keep a registered UE connected
+const connIdleTimeout = time.Minute
+
+func handleConnection(logger *zap.Logger, ctx *ue.Context, c net.Conn, cfg config) {
 	log := logger.Sugar()
 	log.Infof("Serving %s", c.RemoteAddr().String())
 
-	timeout := time.NewTimer(time.Minute)
 	defer func() {
-		timeout.Stop()
+		ctx.Detach(c)
 		c.Close()
 		log.Infof("Closed connection for remote: %s", c.RemoteAddr().String())
 	}()
 
-	u := *ue.NewUE(logger)
+	u := ue.NewUE(logger, ctx)
+	u.Emergency = cfg.emergency
+	u.HomePlmn = cfg.home
+	u.Msin = cfg.msin
+	u.Imeisv = cfg.imeisv
+	u.HandoverGnbs = cfg.handoverGnbs
 
-	err := sendRegistrationRequest(&u, c)
+	err := sendRegistrationRequest(u, c)
 	if err != nil {
 		log.Error(err)
 		return
@@ -40,76 +79,251 @@ func handleConnection(logger *zap.Logger, c net.Conn) {
 	u.ToState(ue.RegistrationInitiated)
 
 	for {
-		select {
-		case <-timeout.C:
+		c.SetReadDeadline(time.Now().Add(connIdleTimeout))
+		buf, err := io.Recv(c)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
 			log.Infof("handleConnection timeout for remote: %s", c.RemoteAddr().String())
 			return
-		default:
-			buf, err := io.Recv(c)
+		}
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				log.Warnf("EOF: %s", c.RemoteAddr().String())
+			}
+			return
+		}
+
+		var gmm nas.GmmHeader
+		err = parser.DecodeMsg(buf, &gmm)
+		if err != nil {
+			log.Warnf("Cannot decode Gmm Header")
+			return
+		}
+
+		msgbuf := gmm.Message
+
+		if !gmm.Security && protectedNAS[gmm.MessageType] {
+			log.Warnf("Unprotected message type (%d) discarded", gmm.MessageType)
+			continue
+		}
+		if gmm.Security {
+			err = crypto.CheckIntegrity(u.IaAlg, msgbuf, gmm.Mac)
 			if err != nil {
-				if !errors.Is(err, io.EOF) {
-					log.Warnf("EOF: %s", c.RemoteAddr().String())
-				}
+				log.Error(err)
 				return
 			}
 
-			var gmm nas.GmmHeader
-			err = parser.DecodeMsg(buf, &gmm)
+			msgbuf, err = crypto.Decrypt(u.EaAlg, msgbuf)
 			if err != nil {
-				log.Warnf("Cannot decode Gmm Header")
+				log.Error(err)
 				return
 			}
+		}
 
-			msgbuf := gmm.Message
+		msgType := gmm.MessageType
 
-			if gmm.Security {
-				err = crypto.CheckIntegrity(u.IaAlg, msgbuf, gmm.Mac)
-				if err != nil {
-					log.Error(err)
-					return
-				}
-
-				msgbuf, err = crypto.Decrypt(u.EaAlg, msgbuf)
-				if err != nil {
-					log.Error(err)
-					return
-				}
+		switch {
+		case msgType == nas.NASAuthRequest && u.InState(ue.RegistrationInitiated):
+			err := u.HandleNASAuthRequest(c, msgbuf)
+			if err != nil {
+				log.Errorf("Error NASAuthRequest: %w", err)
+				return
 			}
-
-			msgType := gmm.MessageType
-
-			switch {
-			case msgType == nas.NASAuthRequest && u.InState(ue.RegistrationInitiated):
-				err := u.HandleNASAuthRequest(c, msgbuf)
-				if err != nil {
-					log.Errorf("Error NASAuthRequest: %w", err)
-					return
-				}
-				u.ToState(ue.Authentication)
-			case msgType == nas.NASSecurityModeCommand && u.InState(ue.Authentication):
-				err := u.HandleNASSecurityModeCommand(c, msgbuf)
+			u.ToState(ue.Authentication)
+		case msgType == nas.NASSecurityModeCommand && u.InState(ue.RegistrationInitiated) && u.Emergency:
+			// Emergency registration accepted without authentication
+			u.ClearContext()
+			err := u.HandleNASSecurityModeCommand(c, msgbuf)
+			if err != nil {
+				log.Errorf("Error NASSecurityModeCommand: %w", err)
+				return
+			}
+			u.ToState(ue.SecurityMode)
+		case msgType == nas.NASSecurityModeCommand && u.InState(ue.Authentication):
+			err := u.HandleNASSecurityModeCommand(c, msgbuf)
+			if err != nil {
+				log.Errorf("Error NASSecurityModeCommand: %w", err)
+				return
+			}
+			u.ToState(ue.SecurityMode)
+		case msgType == nas.PDUSessionEstAccept && u.InState(ue.SecurityMode):
+			err := u.HandlePDUSessionEstAccept(c, msgbuf)
+			if err != nil {
+				log.Errorf("Error PDUSessionEstAccept: %w", err)
+				return
+			}
+			err = u.SendPDUReq(c, "gopher://gopher.website.org/")
+			if err != nil {
+				log.Errorf("Error PDUReq: %w", err)
+				return
+			}
+			u.ToState(ue.ContextSetup)
+		case msgType == nas.PDUSessionEstReject && u.InState(ue.SecurityMode):
+			err := u.HandlePDUSessionEstReject(c, msgbuf)
+			log.Errorf("Error PDUSessionEstReject: %w", err)
+			return
+		case msgType == nas.PDURes && u.InState(ue.ContextSetup):
+			err := u.HandlePDURes(c, msgbuf)
+			if err != nil {
+				log.Errorf("Error PDURes: %w", err)
+				return
+			}
+			err = u.SendUserData(c, "gopher://gopher.website.org/")
+			if err != nil {
+				log.Errorf("Error UserData: %w", err)
+				return
+			}
+			err = u.SendSecurityModeComplete(c)
+			if err != nil {
+				log.Errorf("Error NASSecurityModeComplete: %w", err)
+				return
+			}
+		case msgType == nas.InitialContextSetupRequestRegAccept && u.InState(ue.ContextSetup):
+			err := u.HandleRegAccept(c, msgbuf)
+			if err != nil {
+				log.Errorf("Error RegAccept: %w", err)
+				return
+			}
+			u.ToState(ue.Registered)
+			ctx.Attach(c)
+			// Handed over before the TAI list was assigned
+			if u.LeftRegistrationArea() {
+				err = u.SendRegistrationUpdate(c)
 				if err != nil {
-					log.Errorf("Error NASSecurityModeCommand: %w", err)
+					log.Errorf("Error RegistrationUpdate: %w", err)
 					return
 				}
-				u.ToState(ue.SecurityMode)
-			case msgType == nas.PDUSessionEstAccept && u.InState(ue.SecurityMode):
-				err := u.HandlePDUSessionEstAccept(c, msgbuf)
+			}
+		case msgType == nas.InitialContextSetupRequestRegAccept && u.InState(ue.Registered):
+			err := u.HandleRegUpdateAccept(c, msgbuf)
+			if err != nil {
+				log.Errorf("Error RegAccept: %w", err)
+				return
+			}
+		case msgType == nas.UserData && (u.InState(ue.ContextSetup) || u.InState(ue.Registered)):
+			err := u.HandleUserData(c, msgbuf)
+			if err != nil {
+				log.Errorf("Error UserData: %w", err)
+				return
+			}
+		case msgType == nas.PDURes && u.InState(ue.Registered):
+			err := u.HandlePDURes(c, msgbuf)
+			if err != nil {
+				log.Errorf("Error PDURes: %w", err)
+				return
+			}
+		case msgType == nas.PDUSessionEstAccept && u.InState(ue.Registered):
+			err := u.HandlePDUSessionEstAccept(c, msgbuf)
+			if err != nil {
+				log.Errorf("Error PDUSessionEstAccept: %w", err)
+				return
+			}
+		case msgType == nas.PDUSessionEstReject && u.InState(ue.Registered):
+			err := u.HandlePDUSessionEstReject(c, msgbuf)
+			log.Warnf("PDUSessionEstReject: %v", err)
+		case msgType == nas.PDUSessionModificationCommand && u.InState(ue.Registered):
+			err := u.HandlePDUSessionModificationCommand(c, msgbuf)
+			if err != nil {
+				log.Errorf("Error PDUSessionModificationCommand: %w", err)
+				return
+			}
+		case msgType == nas.PDUSessionModificationReject && u.InState(ue.Registered):
+			err := u.HandlePDUSessionModificationReject(c, msgbuf)
+			if err != nil {
+				log.Errorf("Error PDUSessionModificationReject: %w", err)
+				return
+			}
+		case msgType == nas.PDUSessionResourceReleaseCommand && (u.InState(ue.ContextSetup) || u.InState(ue.Registered)):
+			err := u.HandlePDUSessionReleaseCommand(c, msgbuf)
+			if err != nil {
+				log.Errorf("Error PDUSessionReleaseCommand: %w", err)
+				return
+			}
+		case msgType == nas.PDUSessionReleaseReject && u.InState(ue.Registered):
+			err := u.HandlePDUSessionReleaseReject(c, msgbuf)
+			if err != nil {
+				log.Errorf("Error PDUSessionReleaseReject: %w", err)
+				return
+			}
+		case msgType == nas.DLNASTransport && u.InState(ue.Registered):
+			err := u.HandleDLNASTransport(c, msgbuf)
+			if err != nil {
+				log.Errorf("Error DLNASTransport: %w", err)
+				return
+			}
+		case msgType == nas.ConfigurationUpdateCommand && u.InState(ue.Registered):
+			err := u.HandleConfigurationUpdateCommand(c, msgbuf)
+			if err != nil {
+				log.Errorf("Error ConfigurationUpdateCommand: %w", err)
+				return
+			}
+		case msgType == nas.LocationUpdateAck && u.InState(ue.Registered):
+			err := u.HandleLocationUpdateAck(c, msgbuf)
+			if err != nil {
+				log.Errorf("Error LocationUpdateAck: %w", err)
+				return
+			}
+		case msgType == nas.LocationReportResponse && u.InState(ue.Registered):
+			err := u.HandleLocationReportResponse(c, msgbuf)
+			if err != nil {
+				log.Errorf("Error LocationReportResponse: %w", err)
+				return
+			}
+		case msgType == nas.RRCHandoverCommand && (u.InState(ue.ContextSetup) || u.InState(ue.Registered)):
+			target, err := u.HandleRRCHandoverCommand(msgbuf)
+			if err != nil {
+				log.Errorf("Error RRCHandoverCommand: %w", err)
+				return
+			}
+			log.Infof("Handover from %s to %s", c.RemoteAddr().String(), target.RemoteAddr().String())
+			ctx.Detach(c)
+			c.Close()
+			c = target
+			if u.InState(ue.Registered) {
+				ctx.Attach(c)
+			}
+			if u.InState(ue.Registered) && u.LeftRegistrationArea() {
+				err = u.SendRegistrationUpdate(c)
 				if err != nil {
-					log.Errorf("Error PDUSessionEstAccept: %w", err)
+					log.Errorf("Error RegistrationUpdate: %w", err)
 					return
 				}
-				u.ToState(ue.Registered)
-			case msgType == nas.PDURes && u.InState(ue.Registered):
-				err := u.HandlePDURes(c, msgbuf)
+			}
+			// Security Mode Complete may have been lost with the source gNB
+			if u.InState(ue.ContextSetup) {
+				err = u.SendSecurityModeComplete(c)
 				if err != nil {
-					log.Errorf("Error PDURes: %w", err)
+					log.Errorf("Error NASSecurityModeComplete: %w", err)
 					return
 				}
-			default:
-				log.Warnf("invalid message type (%d) for UE ", msgType)
+			}
+		case msgType == nas.RRCPaging && u.InState(ue.RegistrationInitiated):
+			paged, err := u.HandleRRCPaging(c, msgbuf)
+			if err != nil {
+				log.Errorf("Error RRCPaging: %w", err)
+				return
+			}
+			if paged {
+				u.ToState(ue.ServiceRequested)
+			}
+		case msgType == nas.ServiceAccept && u.InState(ue.ServiceRequested):
+			err := u.HandleServiceAccept(c, msgbuf)
+			if err != nil {
+				log.Errorf("Error ServiceAccept: %w", err)
 				return
 			}
+			u.ToState(ue.Registered)
+			ctx.Attach(c)
+		case msgType == nas.ServiceReject && u.InState(ue.ServiceRequested):
+			err := u.HandleServiceReject(c, msgbuf)
+			log.Errorf("Error ServiceReject: %w", err)
+			return
+		case msgType == nas.RegistrationReject && !u.InState(ue.ServiceRequested):
+			err := u.HandleRegistrationReject(c, msgbuf)
+			log.Errorf("Error RegistrationReject: %w", err)
+			return
+		default:
+			log.Warnf("invalid message type (%d) for UE ", msgType)
+			return
 		}
 	}
 
@@ -118,9 +332,15 @@ func handleConnection(logger *zap.Logger, c net.Conn) {
 func sendRegistrationRequest(u *ue.UE, c net.Conn) error {
 	sec := nas.SecCapType{EaCap: nas.EA1, IaCap: nas.IA1 ^ nas.IA2 ^ nas.IA3 ^ nas.IA4}
 	regMsg := nas.NASRegRequestMsg{
-		MobileId: nas.MobileIdType{Mcc: 1, Mnc: 1, HomeNetPki: 0, Msin: 0},
+		MobileId: nas.MobileIdType{Mcc: u.HomePlmn.Mcc, Mnc: u.HomePlmn.Mnc, HomeNetPki: 0, Msin: u.Msin},
 		SecCap:   sec,
+		// Default slices of the subscription are allowed as well
+		RequestedNssai: []nas.SNssaiType{{Sst: nas.SstEmbb}},
 	}
+	if u.Emergency {
+		regMsg.RegistrationType = nas.RegEmergency
+	}
+	u.MobileId = regMsg.MobileId
 	u.SecCap = sec
 
 	msg, err := parser.EncodeMsg(&regMsg)
@@ -133,10 +353,35 @@ func sendRegistrationRequest(u *ue.UE, c net.Conn) error {
 }
 
 func main() {
+	locationInterval := flag.Duration("location-interval", 30*time.Second, "period of location updates while connected, 0 to only send new locations")
+	emergency := flag.Bool("emergency", false, "register for emergency services only")
+	plmn := flag.String("plmn", "00101", "home PLMN of the subscriber, MCC and MNC")
+	msin := flag.String("msin", os.Getenv("PHREAKING_MSIN"), "MSIN of the subscriber, up to 10 digits")
+	imeisv := flag.String("imeisv", "3534900698733001", "IMEISV of the equipment, sent as PEI when requested")
+	handoverGnbs := flag.String("handover-gnbs", "", "comma separated addresses of the gNBs the UE may be handed over to")
+	lcsKey := flag.String("lcs-key", os.Getenv("PHREAKING_LCS_CLIENT_KEY"), "LCS client key of the subscriber to locate other UEs")
+	flag.Parse()
+
 	logger := zap.Must(zap.NewDevelopment())
 	defer logger.Sync()
 	log := logger.Sugar()
 
+	home, err := nas.ParsePlmnId(*plmn)
+	if err != nil {
+		log.Fatalf("invalid home PLMN %q: %v", *plmn, err)
+	}
+	var msinValue uint64
+	if *msin != "" {
+		msinValue, err = strconv.ParseUint(*msin, 10, 64)
+		if err != nil || msinValue > 9999999999 {
+			log.Fatalf("invalid MSIN %q", *msin)
+		}
+	}
+	var gnbs []string
+	if *handoverGnbs != "" {
+		gnbs = strings.Split(*handoverGnbs, ",")
+	}
+
 	readFile, err := os.Create("/service/data/location.data")
 	if err != nil {
 		log.Fatalf("Could not create location file")
@@ -149,11 +394,19 @@ func main() {
 	}
 	defer lis.Close()
 
-	s := pb.Server{}
+	var lcsClientKey []byte
+	if *lcsKey != "" {
+		lcsClientKey = []byte(*lcsKey)
+	}
+	ctx := ue.NewContext(lcsClientKey)
+	s := pb.Server{Ctx: ctx}
+	go ctx.ReportLocations(logger, *locationInterval)
 
-	grpcServer := grpc.NewServer(grpc.UnaryInterceptor(pb.AuthInterceptor))
+	grpcServer := grpc.NewServer(grpc.UnaryInterceptor(pb.AuthInterceptor), grpc.StreamInterceptor(pb.StreamAuthInterceptor))
 
 	pb.RegisterLocationServer(grpcServer, &s)
+	pb.RegisterSessionServer(grpcServer, &pb.SessionService{Ctx: ctx})
+	pb.RegisterSmsServer(grpcServer, &pb.SmsService{Ctx: ctx})
 	reflection.Register(grpcServer)
 
 	go func() {
@@ -175,6 +428,7 @@ func main() {
 			log.Warnf("connection for listener failed: %v", err)
 			return
 		}
-		go handleConnection(logger, c)
+		go handleConnection(logger, ctx, c, config{emergency: *emergency, home: home, msin: uint(msinValue), imeisv: *imeisv,
+			handoverGnbs: gnbs})
 	}
 }
@@ -1,8 +1,18 @@
 package core
 
 import (
+	"net"
+	"phreaking/internal/eir"
+	"phreaking/internal/geofence"
+	"phreaking/internal/lmf"
+	"phreaking/internal/sepp"
+	"phreaking/internal/smf"
+	"phreaking/internal/smsf"
+	"phreaking/internal/upf"
 	"phreaking/pkg/nas"
 	"phreaking/pkg/ngap"
+	"sync"
+	"time"
 
 	"go.uber.org/zap"
 )
@@ -10,28 +20,111 @@ import (
 type Amf struct {
 	Logger      *zap.Logger
 	AmfName     string
-	GuamPlmn    uint32
 	AmfRegionId uint16
 	AmfSetId    uint32
 	AmfPtr      uint32
 	AmfCap      uint8
+	// PLMNs served, the first is the PLMN of gNBs announcing none
+	Plmns []*Plmn
+	// Slices served by the AMF, the first is the default
+	Nssai []nas.SNssaiType
+	// TAI lists assigned to UEs in one of the TAIs
+	RegistrationAreas [][]nas.TaiType
+	Registry          *Registry
+	Smf               *smf.Smf
+	Upf               *upf.Upf
+	// Nil if no geofences are configured
+	Geofence *geofence.Monitor
+	// Nil disables network based positioning
+	Lmf *lmf.Lmf
+	// Nil disables SMS over NAS
+	Smsf *smsf.Smsf
+	// Sent to UEs on registration, an empty name is not sent
+	NetworkName nas.NetworkNameType
+	// Local time zone sent as NITZ, nil disables NITZ
+	TimeZone *time.Location
+	// Emergency registrations accepted, and the DNN of emergency PDU sessions
+	Emergency    EmergencyPolicy
+	EmergencyDnn string
+	// Nil refuses roaming UEs
+	Sepp *sepp.Client
+	// Nil skips the equipment check
+	Eir     *eir.Eir
+	Barring Barring
 }
 
 type AmfGNB struct {
+	Conn   net.Conn
 	GranId uint32
 	Tac    uint32
 	Plmn   uint32
-	AmfUEs map[ngap.AmfUeNgapIdType]AmfUE
+	Nssai  []nas.SNssaiType
+	// Served PLMNs the gNB broadcasts, the first is Plmn
+	Plmns []*Plmn
 }
 
 type AmfUE struct {
-	RanUeNgapId   uint32
-	AmfUeNgapId   ngap.AmfUeNgapIdType
+	// Serializes NAS procedures for the UE across gNB connections
+	mu sync.Mutex
+	// Guarded by Registry
+	Gnb         *AmfGNB
+	RanUeNgapId uint32
+	AmfUeNgapId ngap.AmfUeNgapIdType
+	Supi        string
+	Guti        nas.GutiType
+	CmState     CmStateType
+	idleSince   time.Time
+	ho          *handover
+	// Reallocated GUTI not yet confirmed by the UE
+	nextGuti nas.GutiType
+
 	SecCap        nas.SecCapType
 	EaAlg         uint8
 	IaAlg         uint8
 	Authenticated bool
+	Registered    bool
+	followOnReq   bool
 	RandToken     []byte
-	Locations     []string
-	PDUs          map[uint8]uint8
+	TaiList       []nas.TaiType
+	// Last known TAI and cell
+	Location      ngap.UserLocationType
+	AllowedNssai  []nas.SNssaiType
+	RejectedNssai []nas.RejectedSNssaiType
+	Locations     []nas.LocationRecordType
+	PDUs          map[uint8]*smf.SmContext
+	// Downlink NAS held back while the UE is paged
+	pending []nas.GmmHeader
+	// LPP transaction of the positioning session, nil if none
+	positioning *positioning
+	// Set while paging, the Service Request answering it reallocates the GUTI
+	paged bool
+	// Registered for emergency services only, not indexed by SUPI
+	Emergency bool
+	// Emergency registered without authentication
+	unauthenticated bool
+	// Authentication and subscription by the home network, nil if not roaming
+	roaming *roaming
+	// PLMN serving the UE
+	plmn *Plmn
+	// Equipment identity from Security Mode Complete
+	Pei string
+}
+
+type CmStateType string
+
+// connection management state for UE
+const (
+	CmIdle      CmStateType = "CM-IDLE"
+	CmConnected CmStateType = "CM-CONNECTED"
+)
+
+// Guami returns a GUTI template identifying this AMF in the PLMN
+func (amf *Amf) Guami(p *Plmn) nas.GutiType {
+	return nas.GutiType{Plmn: p.Plmn, AmfRegionId: amf.AmfRegionId, AmfSetId: amf.AmfSetId, AmfPtr: amf.AmfPtr}
+}
+
+type handover struct {
+	target            *AmfGNB
+	targetRanUeNgapId uint32
+	sourceReleased    bool
 }
@@ -0,0 +1,91 @@
+package core
+
+import (
+	"net"
+	"phreaking/internal/eir"
+	"phreaking/internal/io"
+	"phreaking/pkg/nas"
+	"phreaking/pkg/ngap"
+)
+
+// Barring refuses the registration of subscribers matching any rule.
+// Emergency registrations are never barred.
+type Barring struct {
+	Supis map[string]bool
+	// Home PLMNs of the subscribers
+	Plmns map[nas.PlmnIdType]bool
+	// Tracking area codes, in every served PLMN
+	Tacs map[uint32]bool
+}
+
+// barred returns the cause rejecting the registration if a rule matches
+func (b *Barring) barred(supi string, home nas.PlmnIdType, tai nas.TaiType) (nas.GmmCauseType, bool) {
+	switch {
+	case b.Supis[supi]:
+		return nas.GmmCause5gsServicesNotAllowed, true
+	case b.Plmns[home]:
+		return nas.GmmCausePlmnNotAllowed, true
+	case b.Tacs[tai.Tac]:
+		return nas.GmmCauseTrackingAreaNotAllowed, true
+	}
+	return 0, false
+}
+
+// checkPei looks up the equipment of the UE in the EIR, false if the UE is
+// to be rejected
+func (amf *Amf) checkPei(ue *AmfUE, pei string) bool {
+	if amf.Eir == nil {
+		return true
+	}
+	log := amf.Logger.Sugar()
+
+	status, err := amf.Eir.Check(pei)
+	if err != nil {
+		log.Warnf("UE %s sent invalid PEI %q: %v", ue.Supi, pei, err)
+	}
+	ue.Pei = pei
+
+	switch {
+	case status == eir.Black && ue.Emergency:
+		log.Warnf("EMERGENCY registration of UE %s with blacklisted equipment %s accepted", ue.Supi, pei)
+	case status == eir.Black:
+		log.Warnf("UE %s rejected, equipment %s is blacklisted", ue.Supi, pei)
+		return false
+	case status == eir.Grey:
+		log.Warnf("UE %s registering with greylisted equipment %s", ue.Supi, pei)
+	}
+	return true
+}
+
+// sendRegistrationReject refuses a registration before the UE has a context
+func (amf *Amf) sendRegistrationReject(c net.Conn, ranUeNgapId uint32, cause nas.GmmCauseType) error {
+	reject := nas.NASRegRejectMsg{Cause: cause}
+	rejectMsg, mac, err := nas.BuildMessagePlain(&reject)
+	if err != nil {
+		return errEncode
+	}
+
+	gmm := nas.GmmHeader{Security: false, Mac: mac, MessageType: nas.RegistrationReject, Message: rejectMsg}
+	downTrans := ngap.DownNASTransMsg{RanUeNgapId: ranUeNgapId, NasPdu: gmm}
+	return io.SendNgapMsg(c, ngap.DownNASTrans, &downTrans)
+}
+
+// rejectRegistration refuses the registration of a UE with a security
+// context. Its sessions are released and the context removed.
+func (amf *Amf) rejectRegistration(ue *AmfUE, cause nas.GmmCauseType) error {
+	ue.plmn.Stats.RejectedRegistrations.Add(1)
+
+	reject := nas.NASRegRejectMsg{Cause: cause}
+	err := sendNAS(amf, ue, nas.RegistrationReject, &reject)
+	if err != nil {
+		return err
+	}
+
+	for id, sess := range ue.PDUs {
+		amf.Smf.ReleaseSmContext(sess)
+		delete(ue.PDUs, id)
+	}
+	err = amf.releaseUE(ue, ngap.CauseUnspecified)
+	amf.Registry.RemoveUE(ue)
+	return err
+}
@@ -0,0 +1,73 @@
+package core
+
+import (
+	"errors"
+	"net"
+	"phreaking/pkg/nas"
+	"phreaking/pkg/parser"
+	"time"
+)
+
+// nitz returns the network time in the time zone of the AMF, nil if NITZ is
+// disabled
+func (amf *Amf) nitz() *nas.NitzType {
+	if amf.TimeZone == nil {
+		return nil
+	}
+	now := time.Now().In(amf.TimeZone)
+	_, offset := now.Zone()
+	nitz := &nas.NitzType{UniversalTime: now.UTC(), TimeZone: int32(offset)}
+	if now.IsDST() {
+		nitz.DaylightSaving = 1
+	}
+	return nitz
+}
+
+// sendNetworkInfo tells a newly registered UE the network name and time, no
+// acknowledgement is needed
+func (amf *Amf) sendNetworkInfo(ue *AmfUE) error {
+	cmd := nas.ConfigurationUpdateCommandMsg{NetworkName: amf.NetworkName, Nitz: amf.nitz()}
+	if cmd.NetworkName == (nas.NetworkNameType{}) && cmd.Nitz == nil {
+		return nil
+	}
+	return sendNAS(amf, ue, nas.ConfigurationUpdateCommand, &cmd)
+}
+
+// reconfigureUE assigns a new GUTI, and the TAI list and allowed NSSAI of the
+// serving cell, to a registered UE. The old GUTI stays valid until the UE
+// completes the update.
+func (amf *Amf) reconfigureUE(ue *AmfUE, amfg *AmfGNB) error {
+	guti := amf.Registry.ReallocateGuti(ue, amf.Guami(ue.plmn))
+	ue.TaiList = amf.taiList(ue.Location.Tai)
+	ue.AllowedNssai, ue.RejectedNssai = amf.allowedNssai(amf.subscription(ue), ue.AllowedNssai, amfg)
+
+	amf.Logger.Sugar().Infof("Configuration update of UE %s, new 5G-TMSI %08x", ue.Supi, guti.Tmsi)
+
+	cmd := nas.ConfigurationUpdateCommandMsg{AckRequested: true, Guti: guti, TaiList: ue.TaiList,
+		AllowedNssai: ue.AllowedNssai, RejectedNssai: ue.RejectedNssai, NetworkName: amf.NetworkName, Nitz: amf.nitz()}
+	return sendNAS(amf, ue, nas.ConfigurationUpdateCommand, &cmd)
+}
+
+func (amf *Amf) handleConfigurationUpdateComplete(c net.Conn, buf []byte, amfg *AmfGNB, ue *AmfUE) error {
+	var msg nas.ConfigurationUpdateCompleteMsg
+
+	if !ue.Registered {
+		return errNotAuth
+	}
+
+	err := parser.DecodeMsg(buf, &msg)
+	if err != nil {
+		return errDecode
+	}
+
+	switch msg.Guti {
+	case ue.nextGuti:
+		amf.Registry.CommitGuti(ue)
+		amf.Logger.Sugar().Infof("Configuration update of UE %s completed", ue.Supi)
+	case ue.Guti:
+		// Completes an update superseded by a later one
+	default:
+		return errors.New("configuration update completed with unknown GUTI")
+	}
+	return nil
+}
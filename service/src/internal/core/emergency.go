@@ -0,0 +1,64 @@
+package core
+
+import (
+	"errors"
+	"phreaking/pkg/nas"
+)
+
+var (
+	errEmergencyNotSupported = errors.New("emergency services not supported")
+	errEmergencyOnly         = errors.New("UE is registered for emergency services only")
+)
+
+type EmergencyPolicy uint8
+
+// Emergency registrations accepted by the AMF
+const (
+	EmergencyNotSupported EmergencyPolicy = iota
+	// The UE has to pass authentication like any other
+	EmergencyAuthenticated
+	// Authentication is skipped, the SUPI of the UE is not verified
+	EmergencyUnauthenticated
+)
+
+// NAS messages of an emergency registered UE, everything else is refused
+var emergencyNAS = map[nas.NasMsgType]bool{
+	nas.NASAuthResponse:           true,
+	nas.NASSecurityModeComplete:   true,
+	nas.RegisterComplete:          true,
+	nas.NASRegRequest:             true,
+	nas.PDUSessionEstRequest:      true,
+	nas.PDUReq:                    true,
+	nas.PDUSessionReleaseRequest:  true,
+	nas.PDUSessionReleaseComplete: true,
+	nas.LocationUpdate:            true,
+	nas.ULNASTransport:            true,
+}
+
+// authorized reports whether the UE passed authentication or was accepted
+// for emergency services without it
+func (ue *AmfUE) authorized() bool {
+	return ue.Authenticated || ue.unauthenticated
+}
+
+// establishEmergencySession sets up a session to the emergency DNN. The
+// subscription is not checked and no slice is used.
+func (amf *Amf) establishEmergencySession(ue *AmfUE, msg nas.PDUSessionEstRequestMsg) error {
+	if amf.Emergency == EmergencyNotSupported || amf.EmergencyDnn == "" {
+		return amf.rejectPDUSession(ue, msg.PduSesId, nas.GsmCauseRequestRejected)
+	}
+	if msg.PduSesType == nas.PduSesEthernet || msg.PduSesType == nas.PduSesUnstructured {
+		return amf.rejectPDUSession(ue, msg.PduSesId, nas.GsmCauseUnknownPduSesType)
+	}
+
+	sess, err := amf.Smf.CreateSmContext(ue.AmfUeNgapId, ue.Supi, msg.PduSesId, msg.PduSesType, nas.SNssaiType{}, amf.EmergencyDnn, msg.SscMode)
+	if err != nil {
+		amf.Logger.Sugar().Warnf("EMERGENCY PDU session %d rejected: %v", msg.PduSesId, err)
+		return amf.rejectPDUSession(ue, msg.PduSesId, gsmCause(err))
+	}
+	ue.PDUs[msg.PduSesId] = sess
+
+	amf.Logger.Sugar().Warnf("EMERGENCY PDU session %d of type %d to DNN %s for UE %s", sess.PduSesId, sess.PduSesType,
+		sess.Dnn, ue.Supi)
+	return amf.acceptPDUSession(ue, sess)
+}
@@ -5,8 +5,10 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"os"
 	"phreaking/internal/crypto"
 	"phreaking/internal/io"
+	"phreaking/internal/smf"
 	"phreaking/pkg/nas"
 	"phreaking/pkg/ngap"
 	"phreaking/pkg/parser"
@@ -16,65 +18,74 @@ import (
 )
 
 var (
-	errDecode  = errors.New("cannot decode message")
-	errEncode  = errors.New("cannot encode message")
-	errAuth    = errors.New("cannot authenticate UE")
-	errNotAuth = errors.New("not authenticated")
+	errDecode    = errors.New("cannot decode message")
+	errEncode    = errors.New("cannot encode message")
+	errAuth      = errors.New("cannot authenticate UE")
+	errNotAuth   = errors.New("not authenticated")
+	errIntegrity = errors.New("integrity check failed")
 )
 
+// A connection without any frame for this long is closed
+const connIdleTimeout = time.Minute
+
 func (amf *Amf) HandleConnection(c net.Conn) {
 	log := amf.Logger.Sugar()
 	log.Infof("Serving %s", c.RemoteAddr().String())
 
-	timeout := time.NewTimer(time.Minute)
+	var amfg *AmfGNB
+
 	defer func() {
-		timeout.Stop()
 		c.Close()
+		if amfg != nil {
+			released := amf.Registry.RemoveGNB(amfg)
+			log.Infof("Released %d UE contexts of gNB %d", len(released), amfg.GranId)
+		}
 		log.Infof("Closed connection for remote: %s", c.RemoteAddr().String())
 	}()
 
-	var amfg *AmfGNB
-
 	for {
-		select {
-		case <-timeout.C:
+		c.SetReadDeadline(time.Now().Add(connIdleTimeout))
+		buf, err := io.Recv(c)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
 			log.Infof("HandleConnection timeout for remote: %s", c.RemoteAddr().String())
 			return
-		default:
-			buf, err := io.Recv(c)
-			if err != nil {
-				if !errors.Is(err, io.EOF) {
-					log.Warnf("EOF: %s", c.RemoteAddr().String())
-				}
-				return
+		}
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				log.Warnf("EOF: %s", c.RemoteAddr().String())
 			}
+			return
+		}
+
+		var ngapHeader ngap.NgapHeader
+		err = parser.DecodeMsg(buf, &ngapHeader)
+		if err != nil {
+			log.Warnf("Cannot decode Gmm Header")
+			return
+		}
 
-			var ngapHeader ngap.NgapHeader
-			err = parser.DecodeMsg(buf, &ngapHeader)
+		msgType := ngapHeader.MessageType
+		msgbuf := ngapHeader.NgapPdu
+
+		if msgType == ngap.NGSetupRequest && amfg == nil {
+			amfg, err = amf.handleNGSetupRequest(c, msgbuf)
 			if err != nil {
-				log.Warnf("Cannot decode Gmm Header")
+				log.Errorf("Error creating gNB %w", err)
 				return
 			}
-
-			msgType := ngapHeader.MessageType
-			msgbuf := ngapHeader.NgapPdu
-
-			if msgType == ngap.NGSetupRequest && amfg == nil {
-				amfg, err = amf.handleNGSetupRequest(c, msgbuf)
-				if err != nil {
-					log.Errorf("Error creating gNB %w", err)
-					return
-				}
-			} else if amfg != nil {
-				err = amf.HandleTransport(c, ngapHeader, amfg)
-				if err != nil {
-					log.Errorf("Error NGAP: %w", err)
-					return
-				}
-			} else {
-				log.Errorln("Error gNB connection")
+		} else if amfg != nil {
+			err = amf.HandleTransport(c, ngapHeader, amfg)
+			if err != nil && ngap.IsUeAssociated(msgType) {
+				log.Warnf("Error NGAP for UE: %v", err)
+				err = amf.sendErrorIndication(c, msgbuf, amfg, err)
+			}
+			if err != nil {
+				log.Errorf("Error NGAP: %w", err)
 				return
 			}
+		} else {
+			log.Errorln("Error gNB connection")
+			return
 		}
 	}
 }
@@ -86,12 +97,33 @@ func (amf *Amf) handleNGSetupRequest(c net.Conn, buf []byte) (*AmfGNB, error) {
 		return nil, errDecode
 	}
 
-	amfg := &AmfGNB{GranId: msg.GranId, Tac: msg.Tac, Plmn: msg.Plmn, AmfUEs: make(map[ngap.AmfUeNgapIdType]AmfUE)}
+	nssai, err := amf.supportedNssai(msg.SupportedNssai)
+	if err != nil {
+		failure := ngap.NGSetupFailureMsg{Cause: ngap.CauseSlicesNotSupported}
+		io.SendNgapMsg(c, ngap.NGSetupFailure, &failure)
+		return nil, err
+	}
 
-	// 0x00ff10 = MCC 001, MNC 01
-	resMsg := ngap.NGSetupResponseMsg{AmfName: amf.AmfName, GuamPlmn: 0x00ff10,
+	// gNBs announcing no PLMN are in the first PLMN of the AMF
+	if msg.Plmn == 0 {
+		msg.Plmn = amf.Plmns[0].Plmn
+	}
+	plmns := amf.servedPlmns(append([]uint32{msg.Plmn}, msg.BroadcastPlmns...))
+	if len(plmns) == 0 {
+		failure := ngap.NGSetupFailureMsg{Cause: ngap.CausePlmnNotServed}
+		io.SendNgapMsg(c, ngap.NGSetupFailure, &failure)
+		return nil, errPlmnNotServed
+	}
+	amfg := &AmfGNB{Conn: c, GranId: msg.GranId, Tac: msg.Tac, Plmn: plmns[0].Plmn, Nssai: nssai, Plmns: plmns}
+	amf.Registry.AddGNB(amfg)
+
+	served := make([]uint32, len(plmns))
+	for i, p := range plmns {
+		served[i] = p.Plmn
+	}
+	resMsg := ngap.NGSetupResponseMsg{AmfName: amf.AmfName, GuamPlmn: amfg.Plmn,
 		AmfRegionId: amf.AmfRegionId, AmfSetId: amf.AmfSetId, AmfPtr: amf.AmfPtr,
-		AmfCap: amf.AmfCap, Plmn: msg.Plmn}
+		AmfCap: amf.AmfCap, Plmn: amfg.Plmn, Nssai: amf.Nssai, ServedPlmns: served}
 
 	return amfg, io.SendNgapMsg(c, ngap.NGSetupResponse, &resMsg)
 }
@@ -110,12 +142,74 @@ func (amf *Amf) HandleTransport(c net.Conn, ngapHeader ngap.NgapHeader, amfg *Am
 		if err != nil {
 			return err
 		}
+	case ngap.NGReset:
+		err := amf.handleNGReset(c, ngapHeader.NgapPdu, amfg)
+		if err != nil {
+			return err
+		}
+	case ngap.HandoverRequired:
+		err := amf.handleHandoverRequired(c, ngapHeader.NgapPdu, amfg)
+		if err != nil {
+			return err
+		}
+	case ngap.HandoverRequestAck:
+		err := amf.handleHandoverRequestAck(c, ngapHeader.NgapPdu, amfg)
+		if err != nil {
+			return err
+		}
+	case ngap.HandoverFailure:
+		err := amf.handleHandoverFailure(c, ngapHeader.NgapPdu, amfg)
+		if err != nil {
+			return err
+		}
+	case ngap.HandoverNotify:
+		err := amf.handleHandoverNotify(c, ngapHeader.NgapPdu, amfg)
+		if err != nil {
+			return err
+		}
+	case ngap.UEContextReleaseComplete:
+		err := amf.handleUEContextReleaseComplete(c, ngapHeader.NgapPdu, amfg)
+		if err != nil {
+			return err
+		}
+	case ngap.UEContextReleaseRequest:
+		err := amf.handleUEContextReleaseRequest(c, ngapHeader.NgapPdu, amfg)
+		if err != nil {
+			return err
+		}
+	case ngap.ErrorIndication:
+		err := amf.handleErrorIndication(c, ngapHeader.NgapPdu, amfg)
+		if err != nil {
+			return err
+		}
+	case ngap.PDUSessionResourceSetupResponse:
+		err := amf.handlePDUSessionResourceSetupResponse(c, ngapHeader.NgapPdu, amfg)
+		if err != nil {
+			return err
+		}
+	case ngap.PDUSessionResourceModifyResponse:
+		err := amf.handlePDUSessionResourceModifyResponse(c, ngapHeader.NgapPdu, amfg)
+		if err != nil {
+			return err
+		}
+	case ngap.PDUSessionResourceReleaseResponse:
+		err := amf.handlePDUSessionResourceReleaseResponse(c, ngapHeader.NgapPdu, amfg)
+		if err != nil {
+			return err
+		}
 	default:
 		return fmt.Errorf("invalid message type (%d) for NGAP (non NAS-PDU)", msgType)
 	}
 	return nil
 }
 
+// NAS messages only accepted integrity protected
+var protectedNAS = map[nas.NasMsgType]bool{
+	nas.LocationReportRequest:       true,
+	nas.ULNASTransport:              true,
+	nas.ConfigurationUpdateComplete: true,
+}
+
 func (amf *Amf) handleUpNASTrans(c net.Conn, buf []byte, amfg *AmfGNB) error {
 	var msg ngap.UpNASTransMsg
 	err := parser.DecodeMsg(buf, &msg)
@@ -124,32 +218,52 @@ func (amf *Amf) handleUpNASTrans(c net.Conn, buf []byte, amfg *AmfGNB) error {
 	}
 
 	msgbuf := msg.NasPdu.Message
-	ue, ok := amfg.AmfUEs[msg.AmfUeNgapId]
-	if ok {
-		if msg.NasPdu.Security {
-			err = crypto.CheckIntegrity(ue.IaAlg, msgbuf, msg.NasPdu.Mac)
-			if err != nil {
-				return err
-			}
+	ue, ok := amf.Registry.ServedUE(amfg, msg.AmfUeNgapId, msg.RanUeNgapId)
+	if !ok {
+		return errUnknownUE
+	}
 
-			msgbuf, err = crypto.Decrypt(ue.EaAlg, msg.NasPdu.Message)
-			if err != nil {
-				return err
-			}
+	ue.mu.Lock()
+	defer ue.mu.Unlock()
+
+	if !msg.NasPdu.Security && protectedNAS[msg.NasPdu.MessageType] {
+		return errIntegrity
+	}
+	if msg.NasPdu.Security {
+		err = crypto.CheckIntegrity(ue.IaAlg, msgbuf, msg.NasPdu.Mac)
+		if err != nil {
+			return errIntegrity
 		}
 
-		err = amf.handleNASPDU(c, msg.NasPdu.MessageType, msgbuf, amfg, &ue)
+		msgbuf, err = crypto.Decrypt(ue.EaAlg, msg.NasPdu.Message)
 		if err != nil {
 			return err
 		}
-		// Update UE state
-		amfg.AmfUEs[msg.AmfUeNgapId] = ue
-		return nil
 	}
-	return errors.New("cannot find NG for AmfUeNgapId")
+
+	ue.Location = userLocation(msg.UserLocation, amfg, ue.plmn)
+	return amf.handleNASPDU(c, msg.NasPdu.MessageType, msgbuf, amfg, ue)
+}
+
+func (amf *Amf) handleNGReset(c net.Conn, buf []byte, amfg *AmfGNB) error {
+	var msg ngap.NGResetMsg
+	err := parser.DecodeMsg(buf, &msg)
+	if err != nil {
+		return errDecode
+	}
+
+	released := amf.Registry.ResetGNB(amfg, msg.RanUeNgapIds)
+	amf.Logger.Sugar().Infof("NG Reset (cause %d) from gNB %d released %d UE contexts", msg.Cause, amfg.GranId, len(released))
+
+	ack := ngap.NGResetAckMsg{RanUeNgapIds: msg.RanUeNgapIds}
+	return io.SendNgapMsg(c, ngap.NGResetAck, &ack)
 }
 
 func (amf *Amf) handleNASPDU(c net.Conn, msgType nas.NasMsgType, msgBuf []byte, amfg *AmfGNB, ue *AmfUE) error {
+	if ue.Emergency && !emergencyNAS[msgType] {
+		return errEmergencyOnly
+	}
+
 	switch msgType {
 	case nas.NASAuthResponse:
 		err := amf.handleNASAuthResponse(c, msgBuf, amfg, ue)
@@ -166,11 +280,69 @@ func (amf *Amf) handleNASPDU(c net.Conn, msgType nas.NasMsgType, msgBuf []byte,
 		if err != nil {
 			return err
 		}
+	case nas.ULNASTransport:
+		err := amf.handleULNASTransport(c, msgBuf, amfg, ue)
+		if err != nil {
+			return err
+		}
+	case nas.LocationReportRequest:
+		err := amf.handleLocationReportRequest(c, msgBuf, amfg, ue)
+		if err != nil {
+			return err
+		}
 	case nas.PDUReq:
 		err := amf.handlePDUReq(c, msgBuf, amfg, ue)
 		if err != nil {
 			return err
 		}
+	case nas.NASSecurityModeComplete:
+		err := amf.handleNASSecurityModeComplete(c, msgBuf, amfg, ue)
+		if err != nil {
+			return err
+		}
+	case nas.RegisterComplete:
+		err := amf.handleRegisterComplete(c, msgBuf, amfg, ue)
+		if err != nil {
+			return err
+		}
+	case nas.NASRegRequest:
+		var msg nas.NASRegRequestMsg
+		err := parser.DecodeMsg(msgBuf, &msg)
+		if err != nil {
+			return errDecode
+		}
+		if msg.RegistrationType != nas.RegMobilityUpdating && msg.RegistrationType != nas.RegPeriodicUpdating {
+			return errors.New("initial registration of connected UE")
+		}
+		err = amf.handleRegistrationUpdate(ue, msg, amfg)
+		if err != nil {
+			return err
+		}
+	case nas.PDUSessionModificationRequest:
+		err := amf.handlePDUSessionModificationRequest(c, msgBuf, amfg, ue)
+		if err != nil {
+			return err
+		}
+	case nas.PDUSessionModificationComplete:
+		err := amf.handlePDUSessionModificationComplete(c, msgBuf, amfg, ue)
+		if err != nil {
+			return err
+		}
+	case nas.PDUSessionReleaseRequest:
+		err := amf.handlePDUSessionReleaseRequest(c, msgBuf, amfg, ue)
+		if err != nil {
+			return err
+		}
+	case nas.PDUSessionReleaseComplete:
+		err := amf.handlePDUSessionReleaseComplete(c, msgBuf, amfg, ue)
+		if err != nil {
+			return err
+		}
+	case nas.ConfigurationUpdateComplete:
+		err := amf.handleConfigurationUpdateComplete(c, msgBuf, amfg, ue)
+		if err != nil {
+			return err
+		}
 	default:
 		return errors.New("invalid message type for NAS-PDU")
 	}
@@ -180,7 +352,7 @@ func (amf *Amf) handleNASPDU(c net.Conn, msgType nas.NasMsgType, msgBuf []byte,
 func (amf *Amf) handlePDUReq(c net.Conn, buf []byte, amfg *AmfGNB, ue *AmfUE) error {
 	var msg nas.PDUReqMsg
 
-	if !ue.Authenticated {
+	if !ue.authorized() {
 		return errNotAuth
 	}
 
@@ -189,58 +361,37 @@ func (amf *Amf) handlePDUReq(c net.Conn, buf []byte, amfg *AmfGNB, ue *AmfUE) er
 		return errDecode
 	}
 
-	pduType, ok := ue.PDUs[msg.PduSesId]
+	sess, ok := ue.PDUs[msg.PduSesId]
 	if !ok {
 		return errors.New("pdu session id not found")
 	}
 
-	switch pduType {
-	case 0:
-		/*
-			res, err := http.Get(string(msg.Request))
-			if err != nil {
-				return err
-			}
+	go amf.forwardPDUReq(ue, sess.PduSesType, msg)
+	return nil
+}
 
-			response, err := ioutil.ReadAll(res.Body)
-			if err != nil {
-				return err
-			}
-		*/
-		response := ` 
-		<!DOCTYPE html>
-			<html>
-			<head>
-			</head>
-			<body>
-				<h1>Web page</h1>
-
-				<div>
-					<p>
-						HELLO FROM A UNIX SYSTEM	
-					</p>
-				</div>
-			</body>
-			</html>
-		`
-		pduRes := nas.PDUResMsg{PduSesId: msg.PduSesId, Response: []byte(response)}
-		pduResMsg, mac, err := nas.BuildMessage(ue.EaAlg, ue.IaAlg, &pduRes)
-		if err != nil {
-			return errDecode
-		}
+// forwardPDUReq runs outside of the UE lock, the data network may be slow
+func (amf *Amf) forwardPDUReq(ue *AmfUE, pduSesType nas.PduSesType, msg nas.PDUReqMsg) {
+	response, err := amf.Upf.Handle(pduSesType, msg.Request)
+	if err != nil {
+		amf.Logger.Sugar().Infof("PDU request failed: %v", err)
+		response = []byte("error: " + err.Error())
+	}
 
-		gmm := nas.GmmHeader{true, mac, nas.PDUSessionEstAccept, pduResMsg}
-		downTrans := ngap.DownNASTransMsg{AmfUeNgapId: ue.AmfUeNgapId, RanUeNgapId: ue.RanUeNgapId, NasPdu: gmm}
-		return io.SendNgapMsg(c, ngap.DownNASTrans, &downTrans)
-	default:
-		return errors.New("pdu type not supported")
+	ue.mu.Lock()
+	defer ue.mu.Unlock()
+
+	pduRes := nas.PDUResMsg{PduSesId: msg.PduSesId, Response: response}
+	err = sendNAS(amf, ue, nas.PDURes, &pduRes)
+	if err != nil {
+		amf.Logger.Sugar().Warnf("Cannot send PDU response: %v", err)
 	}
 }
 
-func (amf *Amf) handleLocationUpdate(c net.Conn, buf []byte, amfg *AmfGNB, ue *AmfUE) error {
-	var msg nas.LocationUpdateMsg
+func (amf *Amf) handlePDUSessionEstRequest(c net.Conn, buf []byte, amfg *AmfGNB, ue *AmfUE) error {
+	var msg nas.PDUSessionEstRequestMsg
 
-	if !ue.Authenticated {
+	if !ue.authorized() {
 		return errNotAuth
 	}
 
@@ -249,14 +400,61 @@ func (amf *Amf) handleLocationUpdate(c net.Conn, buf []byte, amfg *AmfGNB, ue *A
 		return errDecode
 	}
 
-	ue.Locations = append(ue.Locations, msg.Location)
-	return nil
+	// Session ID reused by the UE, drop the stale resources first
+	if _, ok := ue.PDUs[msg.PduSesId]; ok {
+		err = amf.releasePDUSessionResources(ue, msg.PduSesId, ngap.CauseUnspecified, nas.GmmHeader{})
+		if err != nil {
+			return err
+		}
+	}
+
+	if msg.Emergency {
+		return amf.establishEmergencySession(ue, msg)
+	}
+	if ue.Emergency {
+		amf.Logger.Sugar().Warnf("PDU session %d rejected, UE %s is emergency registered", msg.PduSesId, ue.Supi)
+		return amf.rejectPDUSession(ue, msg.PduSesId, nas.GsmCauseRequestRejected)
+	}
+
+	if !amf.subscription(ue).AllowsPduSesType(msg.PduSesType) {
+		return amf.rejectPDUSession(ue, msg.PduSesId, nas.GsmCauseServiceOptionNotSubscribed)
+	}
+
+	snssai, dnn, cause, ok := amf.selectSlice(ue, msg.SNssai, msg.Dnn)
+	if !ok {
+		amf.Logger.Sugar().Warnf("PDU session %d rejected for slice %d/%d DNN %q", msg.PduSesId, snssai.Sst, snssai.Sd, dnn)
+		return amf.rejectPDUSession(ue, msg.PduSesId, cause)
+	}
+
+	sess, err := amf.Smf.CreateSmContext(ue.AmfUeNgapId, ue.Supi, msg.PduSesId, msg.PduSesType, snssai, dnn, msg.SscMode)
+	if err != nil {
+		amf.Logger.Sugar().Warnf("PDU session %d rejected: %v", msg.PduSesId, err)
+		return amf.rejectPDUSession(ue, msg.PduSesId, gsmCause(err))
+	}
+	ue.PDUs[msg.PduSesId] = sess
+
+	amf.Logger.Sugar().Infof("PDU session %d of type %d to DNN %s in slice %d/%d", sess.PduSesId, sess.PduSesType, sess.Dnn,
+		sess.SNssai.Sst, sess.SNssai.Sd)
+	return amf.acceptPDUSession(ue, sess)
 }
 
-func (amf *Amf) handlePDUSessionEstRequest(c net.Conn, buf []byte, amfg *AmfGNB, ue *AmfUE) error {
-	var msg nas.PDUSessionEstRequestMsg
+// acceptPDUSession sends the PDU Session Establishment Accept with the
+// resource setup of the session
+func (amf *Amf) acceptPDUSession(ue *AmfUE, sess *smf.SmContext) error {
+	pduAcc := nas.PDUSessionEstAcceptMsg{PduSesId: sess.PduSesId, PduSesType: sess.PduSesType, PduAddress: sess.PduAddress,
+		SscMode: sess.SscMode, QosRules: sess.QosRules, Ambr: sess.Ambr, Dnn: sess.Dnn, SNssai: sess.SNssai}
+	gmm, err := buildNAS(ue, nas.PDUSessionEstAccept, &pduAcc)
+	if err != nil {
+		return err
+	}
+	ue.plmn.Stats.PduSessions.Add(1)
+	return amf.setupPDUSessionResources(ue, sess, gmm)
+}
+
+func (amf *Amf) handleNASSecurityModeComplete(c net.Conn, buf []byte, amfg *AmfGNB, ue *AmfUE) error {
+	var msg nas.NASSecurityModeCompleteMsg
 
-	if !ue.Authenticated {
+	if !ue.authorized() {
 		return errNotAuth
 	}
 
@@ -265,25 +463,74 @@ func (amf *Amf) handlePDUSessionEstRequest(c net.Conn, buf []byte, amfg *AmfGNB,
 		return errDecode
 	}
 
-	ue.PDUs[msg.PduSesId] = msg.PduSesType
+	if !amf.checkPei(ue, msg.Pei) {
+		return amf.rejectRegistration(ue, nas.GmmCausePeiNotAccepted)
+	}
+
+	regAcc := nas.NASRegAcceptMsg{Guti: ue.Guti, TaiList: ue.TaiList, AllowedNssai: ue.AllowedNssai,
+		RejectedNssai: ue.RejectedNssai, EmergencyRegistered: ue.Emergency}
+	return sendNAS(amf, ue, nas.InitialContextSetupRequestRegAccept, &regAcc)
+}
+
+func (amf *Amf) handleRegisterComplete(c net.Conn, buf []byte, amfg *AmfGNB, ue *AmfUE) error {
+	var msg nas.NASRegCompleteMsg
+
+	if !ue.authorized() {
+		return errNotAuth
+	}
 
-	pduAcc := nas.PDUSessionEstAcceptMsg{PduSesId: msg.PduSesId}
-	pduAccMsg, mac, err := nas.BuildMessage(ue.EaAlg, ue.IaAlg, &pduAcc)
+	err := parser.DecodeMsg(buf, &msg)
 	if err != nil {
 		return errDecode
 	}
 
-	gmm := nas.GmmHeader{true, mac, nas.PDUSessionEstAccept, pduAccMsg}
-	downTrans := ngap.DownNASTransMsg{AmfUeNgapId: ue.AmfUeNgapId, RanUeNgapId: ue.RanUeNgapId, NasPdu: gmm}
-	return io.SendNgapMsg(c, ngap.DownNASTrans, &downTrans)
-}
+	if msg.Guti != ue.Guti {
+		return errors.New("registration completed with stale GUTI")
+	}
 
-func handlePDUSessionResourceSetupRequest() {
-	panic("unimplemented")
-}
+	ue.Registered = true
+	if !ue.Emergency {
+		amf.Registry.IndexSupi(ue)
+	}
+	ue.plmn.Stats.Registrations.Add(1)
+	if ue.roaming != nil {
+		ue.plmn.Stats.RoamingRegistrations.Add(1)
+	}
+	if ue.Emergency {
+		ue.plmn.Stats.EmergencyRegistrations.Add(1)
+		amf.Logger.Sugar().Warnf("UE %s registered for EMERGENCY services only in PLMN %s", ue.Supi, ue.plmn.Id)
+	} else {
+		amf.Logger.Sugar().Infof("UE %s registered in PLMN %s", ue.Supi, ue.plmn.Id)
+	}
 
-func handleRegisterComplete() {
-	panic("unimplemented")
+	err = amf.sendNetworkInfo(ue)
+	if err != nil {
+		return err
+	}
+
+	// With user plane resources the gNB releases the UE on inactivity
+	active := false
+	for _, sess := range ue.PDUs {
+		if amf.Smf.Active(sess) {
+			active = true
+		}
+	}
+	if !active && !ue.followOnReq {
+		err = amf.releaseUE(ue, ngap.CauseNormalRelease)
+		if err != nil {
+			return err
+		}
+	} else {
+		// Positioned while still connected
+		err = amf.startPositioning(ue)
+		if err != nil {
+			amf.Logger.Sugar().Warnf("Cannot start positioning of UE %s: %v", ue.Supi, err)
+		}
+	}
+
+	// Paged for if released
+	amf.deliverPendingSms(ue)
+	return nil
 }
 
 func handleInitialContextSetupResponse() {
@@ -294,10 +541,6 @@ func handleUECapInfoIndication() {
 	panic("unimplemented")
 }
 
-func handleNASSecurityModeComplete() {
-	panic("unimplemented")
-}
-
 func handleNASIdResponse() {
 	panic("unimplemented")
 }
@@ -309,38 +552,92 @@ func (amf *Amf) handleInitUEMessage(c net.Conn, buf []byte, amfg *AmfGNB) error
 		return errDecode
 	}
 
-	ue := AmfUE{RanUeNgapId: initmsg.RanUeNgapId, PDUs: make(map[uint8]uint8)}
-
 	msgType := initmsg.NasPdu.MessageType
-	if msgType != nas.NASRegRequest {
+	switch msgType {
+	case nas.NASRegRequest:
+	case nas.ServiceRequest:
+		return amf.handleServiceRequest(c, initmsg, amfg)
+	default:
 		return errors.New("InitUEMessage contains unknown message type")
 	}
+
 	var regmsg nas.NASRegRequestMsg
 	err = parser.DecodeMsg(initmsg.NasPdu.Message, &regmsg)
 	if err != nil {
 		return errDecode
 	}
 
+	if regmsg.RegistrationType == nas.RegMobilityUpdating || regmsg.RegistrationType == nas.RegPeriodicUpdating {
+		return amf.handleIdleRegistrationUpdate(c, initmsg, regmsg, amfg)
+	}
+
+	emergency := regmsg.RegistrationType == nas.RegEmergency
+	if emergency && amf.Emergency == EmergencyNotSupported {
+		return errEmergencyNotSupported
+	}
+
+	plmn, err := selectPlmn(amfg, regmsg.SelectedPlmn, regmsg.MobileId.Plmn())
+	if err != nil {
+		amf.Logger.Sugar().Infof("Registration of UE %s rejected: %v", regmsg.MobileId.Supi(), err)
+		return amf.sendRegistrationReject(c, initmsg.RanUeNgapId, nas.GmmCausePlmnNotAllowed)
+	}
+
+	loc := userLocation(initmsg.UserLocation, amfg, plmn)
+	if !emergency {
+		cause, barred := amf.Barring.barred(regmsg.MobileId.Supi(), regmsg.MobileId.Plmn(), loc.Tai)
+		if barred {
+			amf.Logger.Sugar().Infof("Registration of barred UE %s rejected (cause %d)", regmsg.MobileId.Supi(), cause)
+			plmn.Stats.RejectedRegistrations.Add(1)
+			return amf.sendRegistrationReject(c, initmsg.RanUeNgapId, cause)
+		}
+	}
+	ue := &AmfUE{Gnb: amfg, RanUeNgapId: initmsg.RanUeNgapId, Location: loc, TaiList: amf.taiList(loc.Tai),
+		PDUs: make(map[uint8]*smf.SmContext), plmn: plmn}
+
+	// Not indexed until registered, the SUPI is not proven before
+	ue.Supi = regmsg.MobileId.Supi()
 	ue.SecCap = regmsg.SecCap
+	ue.followOnReq = regmsg.FollowOnReq
+	if emergency {
+		// No slices, emergency sessions go to the emergency DNN
+		ue.Emergency = true
+		ue.unauthenticated = amf.Emergency == EmergencyUnauthenticated
+		amf.Logger.Sugar().Warnf("EMERGENCY registration of UE %s over gNB %d", ue.Supi, amfg.GranId)
+	}
 
-	randToken := make([]byte, 32)
-	rand.Read(randToken)
+	var authReq nas.NASAuthRequestMsg
+	if isRoaming(ue, regmsg.MobileId) && !ue.unauthenticated {
+		// Slices are allowed once the home network sends the subscription
+		authReq, err = amf.roamingAuthRequest(ue, regmsg)
+		if err != nil {
+			plmn.Stats.RejectedRegistrations.Add(1)
+			return amf.sendRegistrationReject(c, initmsg.RanUeNgapId, nas.GmmCausePlmnNotAllowed)
+		}
+	} else {
+		if !ue.Emergency {
+			ue.AllowedNssai, ue.RejectedNssai = amf.allowedNssai(plmn.Udm.Subscription(regmsg.MobileId.Supi()),
+				regmsg.RequestedNssai, amfg)
+		}
+
+		randToken := make([]byte, 32)
+		rand.Read(randToken)
 
-	authRand := make([]byte, 32)
-	rand.Read(authRand)
+		authRand := make([]byte, 32)
+		rand.Read(authRand)
 
-	auth := crypto.IA2(authRand)
+		auth := crypto.IA2(authRand)
 
-	ue.RandToken = randToken
+		ue.RandToken = randToken
 
-	authReq := nas.NASAuthRequestMsg{Rand: ue.RandToken, AuthRand: authRand, Auth: auth}
+		authReq = nas.NASAuthRequestMsg{Rand: ue.RandToken, AuthRand: authRand, Auth: auth}
+	}
 
 	authReqbuf, mac, err := nas.BuildMessagePlain(&authReq)
 	if err != nil {
 		return errEncode
 	}
 
-	gmm := nas.GmmHeader{false, mac, nas.NASAuthRequest, authReqbuf}
+	gmm := nas.GmmHeader{Security: false, Mac: mac, MessageType: nas.NASAuthRequest, Message: authReqbuf}
 
 	uv4, _ := uuid.NewV4()
 	amfueid := ngap.AmfUeNgapIdType(uv4)
@@ -348,7 +645,13 @@ func (amf *Amf) handleInitUEMessage(c net.Conn, buf []byte, amfg *AmfGNB) error
 
 	downTrans := ngap.DownNASTransMsg{AmfUeNgapId: ue.AmfUeNgapId, RanUeNgapId: ue.RanUeNgapId, NasPdu: gmm}
 
-	amfg.AmfUEs[amfueid] = ue
+	amf.Registry.AddUE(ue)
+
+	if ue.unauthenticated {
+		amf.Logger.Sugar().Warnf("EMERGENCY registration of UE %s without authentication", ue.Supi)
+		amf.Registry.AssignGuti(ue, amf.Guami(ue.plmn))
+		return amf.sendSecurityModeCommand(c, ue)
+	}
 	return io.SendNgapMsg(c, ngap.DownNASTrans, &downTrans)
 }
 
@@ -359,46 +662,46 @@ func (amf *Amf) handleNASAuthResponse(c net.Conn, buf []byte, amfg *AmfGNB, ue *
 		return errDecode
 	}
 
-	hkres := crypto.ComputeHash(crypto.IA2(ue.RandToken))
-	hres := crypto.ComputeHash(msg.Res)
+	if ue.roaming != nil {
+		err = amf.confirmRoamingAuth(ue, msg.Res, amfg)
+		if err != nil {
+			ue.plmn.Stats.AuthenticationFailures.Add(1)
+			return err
+		}
+	} else {
+		hkres := crypto.ComputeHash(crypto.IA2(ue.RandToken))
+		hres := crypto.ComputeHash(msg.Res)
 
-	if hkres != hres {
-		return errAuth
+		if hkres != hres {
+			ue.plmn.Stats.AuthenticationFailures.Add(1)
+			return errAuth
+		}
 	}
 
 	amf.Logger.Sugar().Infoln("AUTHENTICATION SUCCESSFULL")
 	ue.Authenticated = true
+	amf.Registry.AssignGuti(ue, amf.Guami(ue.plmn))
+	return amf.sendSecurityModeCommand(c, ue)
+}
 
-	var EA uint8
-	var IA uint8
-
-	for i := len(nas.EaMaskMap) - 1; i >= 0; i-- {
-		alg := nas.EaMaskMap[i]
-		if ue.SecCap.EaCap&alg != 0 {
-			EA = uint8(i)
-			break
-		}
-	}
-
-	for i := len(nas.IaMaskMap) - 1; i >= 0; i-- {
-		alg := nas.IaMaskMap[i]
-		if ue.SecCap.IaCap&alg != 0 {
-			IA = uint8(i)
-			break
-		}
+// sendSecurityModeCommand selects the strongest algorithms the UE supports
+func (amf *Amf) sendSecurityModeCommand(c net.Conn, ue *AmfUE) error {
+	EA, IA, err := selectAlgs(ue.plmn, ue.SecCap)
+	if err != nil {
+		return err
 	}
 
 	ue.EaAlg = EA
 	ue.IaAlg = IA
 	secModeCmd := nas.NASSecurityModeCommandMsg{EaAlg: ue.EaAlg,
-		IaAlg: ue.IaAlg, ReplaySecCap: ue.SecCap,
+		IaAlg: ue.IaAlg, ReplaySecCap: ue.SecCap, ImeisvRequest: amf.Eir != nil,
 	}
 	secModeMsg, mac, err := nas.BuildMessagePlain(&secModeCmd)
 	if err != nil {
 		return errDecode
 	}
 
-	gmm := nas.GmmHeader{false, mac, nas.NASSecurityModeCommand, secModeMsg}
+	gmm := nas.GmmHeader{Security: false, Mac: mac, MessageType: nas.NASSecurityModeCommand, Message: secModeMsg}
 	downTrans := ngap.DownNASTransMsg{AmfUeNgapId: ue.AmfUeNgapId, RanUeNgapId: ue.RanUeNgapId, NasPdu: gmm}
 	return io.SendNgapMsg(c, ngap.DownNASTrans, &downTrans)
 }
@@ -0,0 +1,158 @@
+package core
+
+import (
+	"errors"
+	"net"
+	"phreaking/internal/io"
+	"phreaking/pkg/nas"
+	"phreaking/pkg/ngap"
+	"phreaking/pkg/parser"
+)
+
+var errUnknownUE = errors.New("cannot find UE context for gNB")
+
+func (amf *Amf) handleHandoverRequired(c net.Conn, buf []byte, amfg *AmfGNB) error {
+	var msg ngap.HandoverRequiredMsg
+	err := parser.DecodeMsg(buf, &msg)
+	if err != nil {
+		return errDecode
+	}
+
+	ue, ok := amf.Registry.ServedUE(amfg, msg.AmfUeNgapId, msg.RanUeNgapId)
+	if !ok {
+		return errUnknownUE
+	}
+
+	ue.mu.Lock()
+	defer ue.mu.Unlock()
+
+	fail := ngap.HandoverPreparationFailureMsg{AmfUeNgapId: msg.AmfUeNgapId, RanUeNgapId: msg.RanUeNgapId}
+
+	target, ok := amf.Registry.GNB(msg.TargetGranId)
+	if !ok || target == amfg || !ue.Authenticated {
+		fail.Cause = ngap.CauseUnknownTargetId
+		return io.SendNgapMsg(c, ngap.HandoverPreparationFailure, &fail)
+	}
+
+	err = amf.Registry.StartHandover(ue, target)
+	if err != nil {
+		fail.Cause = ngap.CauseHandoverCancelled
+		return io.SendNgapMsg(c, ngap.HandoverPreparationFailure, &fail)
+	}
+
+	pduSesIds := make([]uint8, 0, len(ue.PDUs))
+	for id := range ue.PDUs {
+		pduSesIds = append(pduSesIds, id)
+	}
+
+	amf.Logger.Sugar().Infof("Handover of UE from gNB %d to gNB %d", amfg.GranId, target.GranId)
+
+	req := ngap.HandoverRequestMsg{AmfUeNgapId: ue.AmfUeNgapId, Cause: msg.Cause, SecCap: ue.SecCap,
+		PduSesIds: pduSesIds, SourceToTargetContainer: msg.SourceToTargetContainer}
+	err = io.SendNgapMsg(target.Conn, ngap.HandoverRequest, &req)
+	if err != nil {
+		amf.Registry.CancelHandover(ue, target)
+		fail.Cause = ngap.CauseUnknownTargetId
+		return io.SendNgapMsg(c, ngap.HandoverPreparationFailure, &fail)
+	}
+	return nil
+}
+
+func (amf *Amf) handleHandoverRequestAck(c net.Conn, buf []byte, amfg *AmfGNB) error {
+	var msg ngap.HandoverRequestAckMsg
+	err := parser.DecodeMsg(buf, &msg)
+	if err != nil {
+		return errDecode
+	}
+
+	ue, ok := amf.Registry.UE(msg.AmfUeNgapId)
+	if !ok {
+		return errUnknownUE
+	}
+
+	ue.mu.Lock()
+	defer ue.mu.Unlock()
+
+	err = amf.Registry.AckHandover(ue, amfg, msg.RanUeNgapId)
+	if err != nil {
+		return err
+	}
+
+	// The UE only follows a command protected with its security context, not
+	// one a gNB made up
+	var rrc nas.RRCHandoverCommandMsg
+	err = parser.DecodeMsg(msg.TargetToSourceContainer, &rrc)
+	if err != nil {
+		amf.Registry.CancelHandover(ue, amfg)
+		return errDecode
+	}
+	gmm, err := buildNAS(ue, nas.RRCHandoverCommand, &rrc)
+	if err != nil {
+		amf.Registry.CancelHandover(ue, amfg)
+		return err
+	}
+
+	source, sourceRanUeNgapId, _ := amf.Registry.Serving(ue)
+	cmd := ngap.HandoverCommandMsg{AmfUeNgapId: ue.AmfUeNgapId, RanUeNgapId: sourceRanUeNgapId, NasPdu: gmm}
+	err = io.SendNgapMsg(source.Conn, ngap.HandoverCommand, &cmd)
+	if err != nil {
+		amf.Registry.CancelHandover(ue, amfg)
+	}
+	return nil
+}
+
+func (amf *Amf) handleHandoverFailure(c net.Conn, buf []byte, amfg *AmfGNB) error {
+	var msg ngap.HandoverFailureMsg
+	err := parser.DecodeMsg(buf, &msg)
+	if err != nil {
+		return errDecode
+	}
+
+	ue, ok := amf.Registry.UE(msg.AmfUeNgapId)
+	if !ok {
+		return errUnknownUE
+	}
+
+	ue.mu.Lock()
+	defer ue.mu.Unlock()
+
+	err = amf.Registry.CancelHandover(ue, amfg)
+	if err != nil {
+		return err
+	}
+
+	source, sourceRanUeNgapId, _ := amf.Registry.Serving(ue)
+	fail := ngap.HandoverPreparationFailureMsg{AmfUeNgapId: ue.AmfUeNgapId, RanUeNgapId: sourceRanUeNgapId, Cause: msg.Cause}
+	io.SendNgapMsg(source.Conn, ngap.HandoverPreparationFailure, &fail)
+	return nil
+}
+
+func (amf *Amf) handleHandoverNotify(c net.Conn, buf []byte, amfg *AmfGNB) error {
+	var msg ngap.HandoverNotifyMsg
+	err := parser.DecodeMsg(buf, &msg)
+	if err != nil {
+		return errDecode
+	}
+
+	ue, ok := amf.Registry.UE(msg.AmfUeNgapId)
+	if !ok {
+		return errUnknownUE
+	}
+
+	ue.mu.Lock()
+	defer ue.mu.Unlock()
+
+	source, sourceRanUeNgapId, err := amf.Registry.CompleteHandover(ue, amfg, msg.RanUeNgapId)
+	if err != nil {
+		return err
+	}
+
+	ue.Location = userLocation(msg.UserLocation, amfg, ue.plmn)
+	amf.Logger.Sugar().Infof("Handover of UE from gNB %d to gNB %d completed", source.GranId, amfg.GranId)
+
+	// Source gNB may already be gone
+	release := ngap.UEContextReleaseCommandMsg{AmfUeNgapId: ue.AmfUeNgapId, RanUeNgapId: sourceRanUeNgapId,
+		Cause: ngap.CauseSuccessfulHandover}
+	io.SendNgapMsg(source.Conn, ngap.UEContextReleaseCommand, &release)
+	return nil
+}
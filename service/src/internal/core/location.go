@@ -0,0 +1,139 @@
+package core
+
+import (
+	"crypto/hmac"
+	"errors"
+	"net"
+	"phreaking/internal/crypto"
+	"phreaking/internal/geofence"
+	"phreaking/pkg/nas"
+	"phreaking/pkg/parser"
+	"time"
+)
+
+var errInvalidPosition = errors.New("invalid position")
+
+// Location history kept per UE context
+const maxLocations = 64
+
+func (amf *Amf) handleLocationUpdate(c net.Conn, buf []byte, amfg *AmfGNB, ue *AmfUE) error {
+	var msg nas.LocationUpdateMsg
+
+	if !ue.authorized() {
+		return errNotAuth
+	}
+
+	err := parser.DecodeMsg(buf, &msg)
+	if err != nil {
+		return errDecode
+	}
+
+	record := nas.LocationRecordType{Location: msg.Location, Time: time.Now()}
+	if !msg.Position.IsZero() {
+		err = msg.Position.Validate()
+		if err != nil {
+			amf.Logger.Sugar().Warnf("Invalid position from UE %s: %v", ue.Supi, err)
+			return errInvalidPosition
+		}
+		record.Position = msg.Position
+		if record.Position.Time.IsZero() {
+			record.Position.Time = record.Time
+		}
+		// The serving cell is taken from the RAN, not from the UE
+		record.Position.Tai = ue.Location.Tai
+		record.Position.CellId = ue.Location.NrCgi.CellId
+	}
+	amf.addLocation(ue, record)
+
+	if msg.Seq == 0 {
+		return nil
+	}
+	ack := nas.LocationUpdateAckMsg{Seq: msg.Seq}
+	return sendNAS(amf, ue, nas.LocationUpdateAck, &ack)
+}
+
+// addLocation stores a location reported by the UE or estimated by the LMF
+func (amf *Amf) addLocation(ue *AmfUE, record nas.LocationRecordType) {
+	ue.Locations = append(ue.Locations, record)
+	if len(ue.Locations) > maxLocations {
+		ue.Locations = ue.Locations[len(ue.Locations)-maxLocations:]
+	}
+
+	// Geofences are per subscriber, the SUPI of an unauthenticated UE is not verified
+	if amf.Geofence != nil && !record.Position.IsZero() && ue.Authenticated {
+		p := geofence.Point{Latitude: record.Position.Latitude, Longitude: record.Position.Longitude}
+		amf.Geofence.Update(ue.Supi, p, record.Position.Time)
+	}
+}
+
+// handleLocationReportRequest returns the location history of the UE itself
+// or, for an authorised LCS client, of the most recent context of the target
+func (amf *Amf) handleLocationReportRequest(c net.Conn, buf []byte, amfg *AmfGNB, ue *AmfUE) error {
+	var msg nas.LocationReportRequestMsg
+
+	if !ue.Authenticated {
+		return errNotAuth
+	}
+
+	err := parser.DecodeMsg(buf, &msg)
+	if err != nil {
+		return errDecode
+	}
+
+	if err = msg.Query.Validate(); err != nil {
+		res := nas.LocationReportResponseMsg{TargetSupi: msg.TargetSupi, Cause: nas.LcsCauseInvalidQuery}
+		return sendNAS(amf, ue, nas.LocationReportResponse, &res)
+	}
+
+	if msg.TargetSupi == "" || msg.TargetSupi == ue.Supi {
+		res := nas.LocationReportResponseMsg{TargetSupi: ue.Supi, Locations: queryLocations(ue.Locations, msg.Query)}
+		return sendNAS(amf, ue, nas.LocationReportResponse, &res)
+	}
+
+	// All UEs share the SIM key, only the LCS client key proves the SUPI
+	res := nas.LocationReportResponseMsg{TargetSupi: msg.TargetSupi}
+	sub := amf.subscription(ue)
+	if len(sub.LcsClientKey) == 0 || len(ue.RandToken) == 0 ||
+		!hmac.Equal(msg.ClientMac, crypto.LcsClientMac(sub.LcsClientKey, ue.RandToken, msg.TargetSupi)) ||
+		!sub.AllowsLcsTarget(msg.TargetSupi) {
+		amf.Logger.Sugar().Warnf("UE %s not authorised to locate %s", ue.Supi, msg.TargetSupi)
+		res.Cause = nas.LcsCauseUnauthorized
+		return sendNAS(amf, ue, nas.LocationReportResponse, &res)
+	}
+
+	target, ok := amf.Registry.UEBySupi(msg.TargetSupi)
+	if !ok {
+		res.Cause = nas.LcsCauseUnknownTarget
+		return sendNAS(amf, ue, nas.LocationReportResponse, &res)
+	}
+
+	amf.Logger.Sugar().Infof("Location report of %s for LCS client %s", msg.TargetSupi, ue.Supi)
+	go amf.reportLocation(ue, target, msg.Query)
+	return nil
+}
+
+// reportLocation runs outside of the UE lock, only one UE is locked at a time
+func (amf *Amf) reportLocation(ue *AmfUE, target *AmfUE, query nas.LocationQueryType) {
+	target.mu.Lock()
+	res := nas.LocationReportResponseMsg{TargetSupi: target.Supi, Locations: queryLocations(target.Locations, query)}
+	target.mu.Unlock()
+
+	ue.mu.Lock()
+	defer ue.mu.Unlock()
+
+	err := sendNAS(amf, ue, nas.LocationReportResponse, &res)
+	if err != nil {
+		amf.Logger.Sugar().Warnf("Cannot send location report: %v", err)
+	}
+}
+
+// queryLocations copies the records matching the query
+func queryLocations(locations []nas.LocationRecordType, query nas.LocationQueryType) []nas.LocationRecordType {
+	var res []nas.LocationRecordType
+	for _, l := range locations {
+		if query.Matches(l) {
+			res = append(res, l)
+		}
+	}
+	return res
+}
@@ -0,0 +1,182 @@
+package core
+
+import (
+	"errors"
+	"net"
+	"phreaking/internal/crypto"
+	"phreaking/internal/io"
+	"phreaking/pkg/nas"
+	"phreaking/pkg/ngap"
+	"phreaking/pkg/parser"
+	"time"
+)
+
+// Idle UE contexts are dropped after the implicit deregistration timer
+const ImplicitDeregistrationTimer = 10 * time.Minute
+
+// Downlink NAS queued per paged UE
+const maxPendingNAS = 16
+
+func (amf *Amf) ExpireIdleUEs() {
+	for range time.Tick(time.Minute) {
+		removed := amf.Registry.RemoveIdle(ImplicitDeregistrationTimer)
+		for _, ue := range removed {
+			ue.mu.Lock()
+			for _, sess := range ue.PDUs {
+				amf.Smf.ReleaseSmContext(sess)
+			}
+			ue.mu.Unlock()
+		}
+		if len(removed) > 0 {
+			amf.Logger.Sugar().Infof("Implicitly deregistered %d idle UE contexts", len(removed))
+		}
+		if amf.Smsf != nil {
+			if expired := amf.Smsf.Expire(amf.ueExists); expired > 0 {
+				amf.Logger.Sugar().Infof("Dropped %d undelivered short messages", expired)
+			}
+		}
+	}
+}
+
+// sendNAS protects a downlink NAS message and sends it to the serving gNB.
+// Messages for a UE in CM-IDLE are queued until the UE answers paging with a
+// Service Request.
+func sendNAS[T any](amf *Amf, ue *AmfUE, msgType nas.NasMsgType, msgPtr *T) error {
+	gmm, err := buildNAS(ue, msgType, msgPtr)
+	if err != nil {
+		return err
+	}
+
+	g, ranUeNgapId, connected := amf.Registry.Serving(ue)
+	if !connected {
+		if len(ue.pending) == maxPendingNAS {
+			ue.pending = ue.pending[1:]
+		}
+		ue.pending = append(ue.pending, gmm)
+		return amf.page(ue)
+	}
+
+	downTrans := ngap.DownNASTransMsg{AmfUeNgapId: ue.AmfUeNgapId, RanUeNgapId: ranUeNgapId, NasPdu: gmm}
+	return io.SendNgapMsg(g.Conn, ngap.DownNASTrans, &downTrans)
+}
+
+// buildNAS protects a downlink NAS message with the UE security context
+func buildNAS[T any](ue *AmfUE, msgType nas.NasMsgType, msgPtr *T) (nas.GmmHeader, error) {
+	msg, mac, err := nas.BuildMessage(ue.EaAlg, ue.IaAlg, msgPtr)
+	if err != nil {
+		return nas.GmmHeader{}, errEncode
+	}
+	return nas.GmmHeader{Security: true, Mac: mac, MessageType: msgType, Message: msg}, nil
+}
+
+// page sends Paging to every gNB in the tracking area list of the UE
+func (amf *Amf) page(ue *AmfUE) error {
+	paging := ngap.PagingMsg{UePagingId: ue.Guti.STmsi(), TaiList: ue.TaiList}
+
+	paged := 0
+	for _, g := range amf.Registry.GNBs() {
+		if g.broadcasts(ue.plmn) && containsTai(ue.TaiList, g.Tai(ue.plmn)) {
+			if io.SendNgapMsg(g.Conn, ngap.Paging, &paging) == nil {
+				paged++
+			}
+		}
+	}
+
+	if paged == 0 {
+		return errors.New("no gNB in tracking area list of UE")
+	}
+	ue.paged = true
+	amf.Logger.Sugar().Infof("Paging UE on %d gNBs", paged)
+	return nil
+}
+
+func (amf *Amf) handleServiceRequest(c net.Conn, initmsg ngap.InitUEMessageMsg, amfg *AmfGNB) error {
+	var msg nas.ServiceRequestMsg
+	err := parser.DecodeMsg(initmsg.NasPdu.Message, &msg)
+	if err != nil {
+		return errDecode
+	}
+
+	// The 5G-TMSI is unique across PLMNs, any PLMN of the gNB may hold it
+	var guti nas.GutiType
+	var ue *AmfUE
+	ok := false
+	for _, p := range amfg.Plmns {
+		guti = amf.Guami(p)
+		guti.Tmsi = uint32(msg.STmsi)
+		ue, ok = amf.Registry.UEByGuti(guti)
+		if ok {
+			break
+		}
+	}
+	if !ok || guti.STmsi() != msg.STmsi {
+		return amf.sendServiceReject(c, initmsg.RanUeNgapId, nas.GmmCauseUeIdentityCannotBeDerived)
+	}
+
+	ue.mu.Lock()
+	defer ue.mu.Unlock()
+
+	// Ciphering is not applied, the 5G-S-TMSI is needed to find the context
+	if !initmsg.NasPdu.Security {
+		return errNotAuth
+	}
+	err = crypto.CheckIntegrity(ue.IaAlg, initmsg.NasPdu.Message, initmsg.NasPdu.Mac)
+	if err != nil {
+		return errIntegrity
+	}
+
+	// The UE received the reallocated GUTI
+	if guti == ue.nextGuti {
+		amf.Registry.CommitGuti(ue)
+	}
+
+	err = amf.Registry.ConnectUE(ue, amfg, initmsg.RanUeNgapId)
+	if err != nil {
+		return amf.sendServiceReject(c, initmsg.RanUeNgapId, nas.GmmCauseImplicitlyDeregistered)
+	}
+
+	ue.Location = userLocation(initmsg.UserLocation, amfg, ue.plmn)
+	ue.plmn.Stats.ServiceRequests.Add(1)
+	amf.Logger.Sugar().Infof("Service Request, UE connected over gNB %d", amfg.GranId)
+
+	pduSesIds := make([]uint8, 0, len(ue.PDUs))
+	for id := range ue.PDUs {
+		pduSesIds = append(pduSesIds, id)
+	}
+
+	accept := nas.ServiceAcceptMsg{PduSesIds: pduSesIds}
+	err = sendNAS(amf, ue, nas.ServiceAccept, &accept)
+	if err != nil {
+		return err
+	}
+
+	pending := ue.pending
+	ue.pending = nil
+	for _, gmm := range pending {
+		downTrans := ngap.DownNASTransMsg{AmfUeNgapId: ue.AmfUeNgapId, RanUeNgapId: initmsg.RanUeNgapId, NasPdu: gmm}
+		err = io.SendNgapMsg(c, ngap.DownNASTrans, &downTrans)
+		if err != nil {
+			return err
+		}
+	}
+
+	// A new GUTI after paging, the old 5G-S-TMSI was sent in the clear.
+	// Emergency registered UEs only complete emergency procedures.
+	if ue.paged && !ue.Emergency {
+		ue.paged = false
+		return amf.reconfigureUE(ue, amfg)
+	}
+	return nil
+}
+
+func (amf *Amf) sendServiceReject(c net.Conn, ranUeNgapId uint32, cause nas.GmmCauseType) error {
+	reject := nas.ServiceRejectMsg{Cause: cause}
+	rejectMsg, mac, err := nas.BuildMessagePlain(&reject)
+	if err != nil {
+		return errEncode
+	}
+
+	gmm := nas.GmmHeader{Security: false, Mac: mac, MessageType: nas.ServiceReject, Message: rejectMsg}
+	downTrans := ngap.DownNASTransMsg{RanUeNgapId: ranUeNgapId, NasPdu: gmm}
+	return io.SendNgapMsg(c, ngap.DownNASTrans, &downTrans)
+}
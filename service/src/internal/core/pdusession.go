@@ -0,0 +1,299 @@
+package core
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"phreaking/internal/io"
+	"phreaking/internal/smf"
+	"phreaking/pkg/nas"
+	"phreaking/pkg/ngap"
+	"phreaking/pkg/parser"
+)
+
+var errNotConnected = errors.New("UE is not connected")
+
+// gsmCause maps a failed SM context creation to the 5GSM cause
+func gsmCause(err error) nas.GsmCauseType {
+	switch {
+	case errors.Is(err, smf.ErrUnknownDnn):
+		return nas.GsmCauseUnknownDnn
+	case errors.Is(err, smf.ErrUnknownPduSesType):
+		return nas.GsmCauseUnknownPduSesType
+	case errors.Is(err, smf.ErrNoIpv4):
+		return nas.GsmCausePduSesTypeIpv6OnlyAllowed
+	case errors.Is(err, smf.ErrNoIpv6):
+		return nas.GsmCausePduSesTypeIpv4OnlyAllowed
+	case errors.Is(err, smf.ErrUnsupported5Qi):
+		return nas.GsmCauseUnsupported5Qi
+	case errors.Is(err, smf.ErrPduSesIdInUse):
+		return nas.GsmCauseInvalidPduSesId
+	}
+	return nas.GsmCauseInsufficientResources
+}
+
+func (amf *Amf) rejectPDUSession(ue *AmfUE, pduSesId uint8, cause nas.GsmCauseType) error {
+	reject := nas.PDUSessionEstRejectMsg{PduSesId: pduSesId, Cause: cause}
+	return sendNAS(amf, ue, nas.PDUSessionEstReject, &reject)
+}
+
+// ulTunnel advertises a UPF listening on all interfaces on the address the gNB
+// reaches the core on
+func ulTunnel(g *AmfGNB, tunnel ngap.TunnelInfoType) ngap.TunnelInfoType {
+	host, port, err := net.SplitHostPort(tunnel.Addr)
+	if err != nil {
+		return tunnel
+	}
+	if host != "" {
+		ip, err := netip.ParseAddr(host)
+		if err != nil || !ip.IsUnspecified() {
+			return tunnel
+		}
+	}
+	local, err := netip.ParseAddrPort(g.Conn.LocalAddr().String())
+	if err != nil {
+		return tunnel
+	}
+	tunnel.Addr = net.JoinHostPort(local.Addr().Unmap().String(), port)
+	return tunnel
+}
+
+// setupPDUSessionResources asks the serving gNB to set up the session and
+// forward the NAS accept to the UE
+func (amf *Amf) setupPDUSessionResources(ue *AmfUE, sess *smf.SmContext, gmm nas.GmmHeader) error {
+	g, ranUeNgapId, connected := amf.Registry.Serving(ue)
+	if !connected {
+		return errNotConnected
+	}
+
+	item := ngap.PDUSessionResourceSetupItem{PduSesId: sess.PduSesId, PduSesType: sess.PduSesType, SNssai: sess.SNssai,
+		QosFlows: sess.QosFlows, ULTunnel: ulTunnel(g, sess.ULTunnel)}
+	req := ngap.PDUSessionResourceSetupRequestMsg{AmfUeNgapId: ue.AmfUeNgapId, RanUeNgapId: ranUeNgapId, NasPdu: gmm,
+		PduSessions: []ngap.PDUSessionResourceSetupItem{item}}
+	return io.SendNgapMsg(g.Conn, ngap.PDUSessionResourceSetupRequest, &req)
+}
+
+// modifyPDUSessionResources sends the QoS flows of a modified session to the
+// serving gNB together with the NAS command
+func (amf *Amf) modifyPDUSessionResources(ue *AmfUE, sess *smf.SmContext, gmm nas.GmmHeader) error {
+	g, ranUeNgapId, connected := amf.Registry.Serving(ue)
+	if !connected {
+		return errNotConnected
+	}
+
+	item := ngap.PDUSessionResourceModifyItem{PduSesId: sess.PduSesId, QosFlows: sess.QosFlows}
+	req := ngap.PDUSessionResourceModifyRequestMsg{AmfUeNgapId: ue.AmfUeNgapId, RanUeNgapId: ranUeNgapId, NasPdu: gmm,
+		PduSessions: []ngap.PDUSessionResourceModifyItem{item}}
+	return io.SendNgapMsg(g.Conn, ngap.PDUSessionResourceModifyRequest, &req)
+}
+
+// releasePDUSessionResources drops the session in the SMF, the gNB is told if
+// the UE is connected
+func (amf *Amf) releasePDUSessionResources(ue *AmfUE, pduSesId uint8, cause ngap.CauseType, gmm nas.GmmHeader) error {
+	if sess, ok := ue.PDUs[pduSesId]; ok {
+		amf.Smf.ReleaseSmContext(sess)
+		delete(ue.PDUs, pduSesId)
+	}
+
+	g, ranUeNgapId, connected := amf.Registry.Serving(ue)
+	if !connected {
+		return nil
+	}
+
+	item := ngap.PDUSessionResourceFailedItem{PduSesId: pduSesId, Cause: cause}
+	cmd := ngap.PDUSessionResourceReleaseCommandMsg{AmfUeNgapId: ue.AmfUeNgapId, RanUeNgapId: ranUeNgapId, NasPdu: gmm,
+		PduSessions: []ngap.PDUSessionResourceFailedItem{item}}
+	return io.SendNgapMsg(g.Conn, ngap.PDUSessionResourceReleaseCommand, &cmd)
+}
+
+func (amf *Amf) handlePDUSessionModificationRequest(c net.Conn, buf []byte, amfg *AmfGNB, ue *AmfUE) error {
+	var msg nas.PDUSessionModificationRequestMsg
+
+	if !ue.Authenticated {
+		return errNotAuth
+	}
+
+	err := parser.DecodeMsg(buf, &msg)
+	if err != nil {
+		return errDecode
+	}
+
+	sess, ok := ue.PDUs[msg.PduSesId]
+	if !ok {
+		reject := nas.PDUSessionModificationRejectMsg{PduSesId: msg.PduSesId, Cause: nas.GsmCauseInvalidPduSesId}
+		return sendNAS(amf, ue, nas.PDUSessionModificationReject, &reject)
+	}
+
+	err = amf.Smf.ModifySmContext(sess, msg.FiveQi, msg.Ambr)
+	if err != nil {
+		amf.Logger.Sugar().Warnf("PDU session %d modification rejected: %v", msg.PduSesId, err)
+		reject := nas.PDUSessionModificationRejectMsg{PduSesId: msg.PduSesId, Cause: gsmCause(err)}
+		return sendNAS(amf, ue, nas.PDUSessionModificationReject, &reject)
+	}
+
+	amf.Logger.Sugar().Infof("PDU session %d modified, 5QI %d", sess.PduSesId, sess.QosFlows[0].FiveQi)
+
+	cmd := nas.PDUSessionModificationCommandMsg{PduSesId: sess.PduSesId, FiveQi: sess.QosFlows[0].FiveQi,
+		QosRules: sess.QosRules, Ambr: sess.Ambr}
+	gmm, err := buildNAS(ue, nas.PDUSessionModificationCommand, &cmd)
+	if err != nil {
+		return err
+	}
+	return amf.modifyPDUSessionResources(ue, sess, gmm)
+}
+
+func (amf *Amf) handlePDUSessionModificationComplete(c net.Conn, buf []byte, amfg *AmfGNB, ue *AmfUE) error {
+	var msg nas.PDUSessionModificationCompleteMsg
+
+	if !ue.Authenticated {
+		return errNotAuth
+	}
+
+	err := parser.DecodeMsg(buf, &msg)
+	if err != nil {
+		return errDecode
+	}
+
+	amf.Logger.Sugar().Debugf("PDU session %d modification completed", msg.PduSesId)
+	return nil
+}
+
+func (amf *Amf) handlePDUSessionReleaseRequest(c net.Conn, buf []byte, amfg *AmfGNB, ue *AmfUE) error {
+	var msg nas.PDUSessionReleaseRequestMsg
+
+	if !ue.authorized() {
+		return errNotAuth
+	}
+
+	err := parser.DecodeMsg(buf, &msg)
+	if err != nil {
+		return errDecode
+	}
+
+	if _, ok := ue.PDUs[msg.PduSesId]; !ok {
+		reject := nas.PDUSessionReleaseRejectMsg{PduSesId: msg.PduSesId, Cause: nas.GsmCauseInvalidPduSesId}
+		return sendNAS(amf, ue, nas.PDUSessionReleaseReject, &reject)
+	}
+
+	amf.Logger.Sugar().Infof("PDU session %d released by UE (cause %d)", msg.PduSesId, msg.Cause)
+
+	cmd := nas.PDUSessionResourceReleaseCommandMsg{PduSesId: msg.PduSesId, Cause: nas.GsmCauseRegularDeactivation}
+	gmm, err := buildNAS(ue, nas.PDUSessionResourceReleaseCommand, &cmd)
+	if err != nil {
+		return err
+	}
+	return amf.releasePDUSessionResources(ue, msg.PduSesId, ngap.CauseNormalRelease, gmm)
+}
+
+func (amf *Amf) handlePDUSessionReleaseComplete(c net.Conn, buf []byte, amfg *AmfGNB, ue *AmfUE) error {
+	var msg nas.PDUSessionReleaseCompleteMsg
+
+	if !ue.authorized() {
+		return errNotAuth
+	}
+
+	err := parser.DecodeMsg(buf, &msg)
+	if err != nil {
+		return errDecode
+	}
+
+	amf.Logger.Sugar().Debugf("PDU session %d release completed", msg.PduSesId)
+	return nil
+}
+
+// tunnelOnGnb checks that a tunnel address is an IP of the gNB's N2
+// association
+func tunnelOnGnb(amfg *AmfGNB, tunnel ngap.TunnelInfoType) bool {
+	host, _, err := net.SplitHostPort(tunnel.Addr)
+	if err != nil {
+		return false
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	peer, err := netip.ParseAddrPort(amfg.Conn.RemoteAddr().String())
+	if err != nil {
+		return false
+	}
+	return addr.Unmap() == peer.Addr().Unmap()
+}
+
+func (amf *Amf) handlePDUSessionResourceSetupResponse(c net.Conn, buf []byte, amfg *AmfGNB) error {
+	var msg ngap.PDUSessionResourceSetupResponseMsg
+	err := parser.DecodeMsg(buf, &msg)
+	if err != nil {
+		return errDecode
+	}
+
+	ue, ok := amf.Registry.ServedUE(amfg, msg.AmfUeNgapId, msg.RanUeNgapId)
+	if !ok {
+		return errUnknownUE
+	}
+
+	ue.mu.Lock()
+	defer ue.mu.Unlock()
+
+	for _, res := range msg.Setup {
+		sess, ok := ue.PDUs[res.PduSesId]
+		if !ok {
+			continue
+		}
+		// The UPF answers to the downlink tunnel, it has to end at the gNB
+		// itself or the core could be pointed at any host
+		if !tunnelOnGnb(amfg, res.DLTunnel) {
+			amf.Smf.ReleaseSmContext(sess)
+			delete(ue.PDUs, res.PduSesId)
+			amf.Logger.Sugar().Warnf("PDU session %d setup rejected, DL tunnel %q is not on gNB %d", res.PduSesId, res.DLTunnel.Addr, amfg.GranId)
+			continue
+		}
+		err = amf.Smf.ActivateSmContext(sess, res.DLTunnel, ue.EaAlg, ue.IaAlg)
+		if err != nil {
+			continue
+		}
+		amf.Logger.Sugar().Infof("PDU session %d set up, UL TEID %d, DL TEID %d", sess.PduSesId, sess.ULTunnel.Teid, sess.DLTunnel.Teid)
+	}
+
+	for _, failed := range msg.Failed {
+		if sess, ok := ue.PDUs[failed.PduSesId]; ok {
+			amf.Smf.ReleaseSmContext(sess)
+			delete(ue.PDUs, failed.PduSesId)
+		}
+		amf.Logger.Sugar().Warnf("PDU session %d setup failed (cause %d)", failed.PduSesId, failed.Cause)
+	}
+	return nil
+}
+
+func (amf *Amf) handlePDUSessionResourceModifyResponse(c net.Conn, buf []byte, amfg *AmfGNB) error {
+	var msg ngap.PDUSessionResourceModifyResponseMsg
+	err := parser.DecodeMsg(buf, &msg)
+	if err != nil {
+		return errDecode
+	}
+
+	_, ok := amf.Registry.ServedUE(amfg, msg.AmfUeNgapId, msg.RanUeNgapId)
+	if !ok {
+		return errUnknownUE
+	}
+
+	for _, failed := range msg.Failed {
+		amf.Logger.Sugar().Warnf("PDU session %d modification failed (cause %d)", failed.PduSesId, failed.Cause)
+	}
+	return nil
+}
+
+func (amf *Amf) handlePDUSessionResourceReleaseResponse(c net.Conn, buf []byte, amfg *AmfGNB) error {
+	var msg ngap.PDUSessionResourceReleaseResponseMsg
+	err := parser.DecodeMsg(buf, &msg)
+	if err != nil {
+		return errDecode
+	}
+
+	_, ok := amf.Registry.ServedUE(amfg, msg.AmfUeNgapId, msg.RanUeNgapId)
+	if !ok {
+		return errUnknownUE
+	}
+
+	amf.Logger.Sugar().Infof("gNB %d released %d PDU sessions", amfg.GranId, len(msg.Released))
+	return nil
+}
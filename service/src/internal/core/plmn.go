@@ -0,0 +1,134 @@
+package core
+
+import (
+	"errors"
+	"phreaking/internal/udm"
+	"phreaking/pkg/nas"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	errPlmnNotServed       = errors.New("no PLMN of the gNB is served by the AMF")
+	errPlmnNotBroadcast    = errors.New("selected PLMN is not broadcast by the gNB")
+	errAlgorithmNotAllowed = errors.New("no security algorithm of the UE is allowed in the PLMN")
+)
+
+// Plmn is a PLMN served by the AMF, with its own GUAMI, subscribers and
+// security algorithm policy
+type Plmn struct {
+	// PLMN identity on N2, in the GUAMI and TAIs
+	Plmn uint32
+	// PLMN of the subscribers in Udm, UEs of other PLMNs are roaming
+	Id  nas.PlmnIdType
+	Udm *udm.Udm
+	// Algorithms in order of preference, the strongest the UE supports if empty
+	EaAlgs []uint8
+	IaAlgs []uint8
+	Stats  PlmnStats
+}
+
+// PlmnStats counts the procedures of the UEs served in a PLMN
+type PlmnStats struct {
+	Registrations          atomic.Uint64
+	RoamingRegistrations   atomic.Uint64
+	EmergencyRegistrations atomic.Uint64
+	AuthenticationFailures atomic.Uint64
+	RejectedRegistrations  atomic.Uint64
+	ServiceRequests        atomic.Uint64
+	PduSessions            atomic.Uint64
+}
+
+// servedPlmns returns the PLMNs served by the AMF among those broadcast, in
+// the order of the gNB
+func (amf *Amf) servedPlmns(broadcast []uint32) []*Plmn {
+	var plmns []*Plmn
+	for _, b := range broadcast {
+		for _, p := range amf.Plmns {
+			if p.Plmn == b && !containsPlmn(plmns, p) {
+				plmns = append(plmns, p)
+			}
+		}
+	}
+	return plmns
+}
+
+func containsPlmn(plmns []*Plmn, p *Plmn) bool {
+	for _, q := range plmns {
+		if q == p {
+			return true
+		}
+	}
+	return false
+}
+
+// broadcasts reports whether the gNB broadcasts the served PLMN
+func (g *AmfGNB) broadcasts(p *Plmn) bool {
+	return containsPlmn(g.Plmns, p)
+}
+
+// selectPlmn picks the PLMN serving a registering UE among those of the gNB:
+// the PLMN the UE selected, else its home PLMN, else the first of the gNB,
+// where the UE is roaming
+func selectPlmn(g *AmfGNB, selected nas.PlmnIdType, home nas.PlmnIdType) (*Plmn, error) {
+	if selected == (nas.PlmnIdType{}) {
+		for _, p := range g.Plmns {
+			if p.Id == home {
+				return p, nil
+			}
+		}
+		return g.Plmns[0], nil
+	}
+	for _, p := range g.Plmns {
+		if p.Id == selected {
+			return p, nil
+		}
+	}
+	return nil, errPlmnNotBroadcast
+}
+
+// selectAlgs picks the ciphering and integrity algorithms of the UE by the
+// policy of its PLMN
+func selectAlgs(p *Plmn, sec nas.SecCapType) (uint8, uint8, error) {
+	ea, ok := selectAlg(nas.EaMaskMap, sec.EaCap, p.EaAlgs)
+	if !ok {
+		return 0, 0, errAlgorithmNotAllowed
+	}
+	ia, ok := selectAlg(nas.IaMaskMap, sec.IaCap, p.IaAlgs)
+	if !ok {
+		return 0, 0, errAlgorithmNotAllowed
+	}
+	return ea, ia, nil
+}
+
+// selectAlg returns the first algorithm of the policy the UE supports, or
+// the strongest it supports if there is no policy
+func selectAlg[M nas.EaMask | nas.IaMask](masks []M, capability M, policy []uint8) (uint8, bool) {
+	if len(policy) == 0 {
+		for i := len(masks) - 1; i >= 0; i-- {
+			if capability&masks[i] != 0 {
+				return uint8(i), true
+			}
+		}
+		return 0, true
+	}
+	for _, alg := range policy {
+		if int(alg) < len(masks) && capability&masks[alg] != 0 {
+			return alg, true
+		}
+	}
+	return 0, false
+}
+
+// ReportStats logs the counters of each served PLMN
+func (amf *Amf) ReportStats(interval time.Duration) {
+	for range time.Tick(interval) {
+		for _, p := range amf.Plmns {
+			s := &p.Stats
+			amf.Logger.Sugar().Infof("PLMN %s: %d registrations (%d roaming, %d emergency), %d authentication failures, "+
+				"%d rejected registrations, %d service requests, %d PDU sessions", p.Id, s.Registrations.Load(),
+				s.RoamingRegistrations.Load(), s.EmergencyRegistrations.Load(), s.AuthenticationFailures.Load(),
+				s.RejectedRegistrations.Load(), s.ServiceRequests.Load(), s.PduSessions.Load())
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package core
+
+import (
+	"phreaking/pkg/lpp"
+	"phreaking/pkg/nas"
+	"phreaking/pkg/parser"
+	"time"
+)
+
+// Time the UE is given to measure, in milliseconds
+const lppResponseTime = 1000
+
+type positioning struct {
+	transactionId uint8
+	method        lpp.PositioningMethodType
+}
+
+// startPositioning opens an LPP session with the UE, a running session is
+// replaced
+func (amf *Amf) startPositioning(ue *AmfUE) error {
+	if amf.Lmf == nil {
+		return nil
+	}
+
+	var tid uint8
+	if ue.positioning != nil {
+		tid = ue.positioning.transactionId + 1
+	}
+	ue.positioning = &positioning{transactionId: tid}
+
+	var req lpp.RequestCapabilitiesMsg
+	return sendLpp(amf, ue, lpp.RequestCapabilities, &req)
+}
+
+func sendLpp[T any](amf *Amf, ue *AmfUE, msgType lpp.LppMsgType, msgPtr *T) error {
+	msg, err := parser.EncodeMsg(msgPtr)
+	if err != nil {
+		return errEncode
+	}
+	header := lpp.LppHeader{TransactionId: ue.positioning.transactionId, MessageType: msgType, Message: msg}
+	container, err := parser.EncodeMsg(&header)
+	if err != nil {
+		return errEncode
+	}
+
+	dl := nas.DLNASTransportMsg{PayloadContainerType: nas.PayloadLpp, PayloadContainer: container}
+	return sendNAS(amf, ue, nas.DLNASTransport, &dl)
+}
+
+// handleLpp runs the LMF side of the positioning session: capabilities,
+// then measurements, then the estimate is stored with the UE's locations
+func (amf *Amf) handleLpp(ue *AmfUE, container []byte) error {
+	var header lpp.LppHeader
+	err := parser.DecodeMsg(container, &header)
+	if err != nil {
+		return errDecode
+	}
+
+	if ue.positioning == nil || header.TransactionId != ue.positioning.transactionId {
+		amf.Logger.Sugar().Debugf("Dropping LPP message of stale transaction %d", header.TransactionId)
+		return nil
+	}
+
+	switch header.MessageType {
+	case lpp.ProvideCapabilities:
+		var msg lpp.ProvideCapabilitiesMsg
+		err = parser.DecodeMsg(header.Message, &msg)
+		if err != nil {
+			return errDecode
+		}
+
+		method, ok := amf.Lmf.SelectMethod(msg.Methods)
+		if !ok {
+			abort := lpp.AbortMsg{Cause: lpp.AbortMethodNotSupported}
+			err = sendLpp(amf, ue, lpp.Abort, &abort)
+			ue.positioning = nil
+			return err
+		}
+		ue.positioning.method = method
+		req := lpp.RequestLocationInformationMsg{Method: method, ResponseTime: lppResponseTime}
+		return sendLpp(amf, ue, lpp.RequestLocationInformation, &req)
+	case lpp.ProvideLocationInformation:
+		var msg lpp.ProvideLocationInformationMsg
+		err = parser.DecodeMsg(header.Message, &msg)
+		if err != nil {
+			return errDecode
+		}
+		method := ue.positioning.method
+		ue.positioning = nil
+
+		if msg.Method != method || method != lpp.MethodEcid {
+			amf.Logger.Sugar().Warnf("Positioning of UE %s: unexpected method %d", ue.Supi, msg.Method)
+			return nil
+		}
+		pos, err := amf.Lmf.EstimateEcid(ue.Location.Tai, ue.Location.NrCgi.CellId, msg.Measurement)
+		if err != nil {
+			amf.Logger.Sugar().Warnf("Positioning of UE %s: %v", ue.Supi, err)
+			return nil
+		}
+
+		amf.Logger.Sugar().Infof("UE %s positioned at %f,%f ±%.0f m", ue.Supi, pos.Latitude, pos.Longitude, pos.Uncertainty)
+		amf.addLocation(ue, nas.LocationRecordType{Position: pos, Time: time.Now()})
+		return nil
+	case lpp.Abort:
+		var msg lpp.AbortMsg
+		err = parser.DecodeMsg(header.Message, &msg)
+		if err != nil {
+			return errDecode
+		}
+		amf.Logger.Sugar().Infof("Positioning of UE %s aborted by UE (cause %d)", ue.Supi, msg.Cause)
+		ue.positioning = nil
+		return nil
+	}
+	return errDecode
+}
@@ -0,0 +1,438 @@
+package core
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"phreaking/pkg/nas"
+	"phreaking/pkg/ngap"
+	"sync"
+	"time"
+)
+
+type ranUeKey struct {
+	gnb         *AmfGNB
+	ranUeNgapId uint32
+}
+
+// Registry is the AMF wide view of connected gNBs and UE contexts, shared by
+// all N2 connections.
+type Registry struct {
+	mu     sync.RWMutex
+	gnbs   map[uint32]*AmfGNB
+	ues    map[ngap.AmfUeNgapIdType]*AmfUE
+	ranUes map[ranUeKey]*AmfUE
+	// Registered UEs, the most recent last
+	supis map[string][]*AmfUE
+	// By 5G-TMSI, unique across the served PLMNs as the 5G-S-TMSI has no PLMN
+	gutis map[uint32]*AmfUE
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		gnbs:   make(map[uint32]*AmfGNB),
+		ues:    make(map[ngap.AmfUeNgapIdType]*AmfUE),
+		ranUes: make(map[ranUeKey]*AmfUE),
+		supis:  make(map[string][]*AmfUE),
+		gutis:  make(map[uint32]*AmfUE),
+	}
+}
+
+// AddGNB registers a gNB after NG Setup. A gNB reusing the GranId of an
+// existing association replaces it as the lookup target.
+func (r *Registry) AddGNB(g *AmfGNB) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.gnbs[g.GranId] = g
+}
+
+// RemoveGNB drops the gNB and releases every UE context it serves
+func (r *Registry) RemoveGNB(g *AmfGNB) []*AmfUE {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.gnbs[g.GranId] == g {
+		delete(r.gnbs, g.GranId)
+	}
+	return r.removeGNBUEs(g)
+}
+
+// ResetGNB releases the given UE associations of the gNB, or all of them if
+// ranUeNgapIds is empty.
+func (r *Registry) ResetGNB(g *AmfGNB, ranUeNgapIds []uint32) []*AmfUE {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(ranUeNgapIds) == 0 {
+		return r.removeGNBUEs(g)
+	}
+
+	var released []*AmfUE
+	for _, id := range ranUeNgapIds {
+		ue, ok := r.ranUes[ranUeKey{g, id}]
+		if ok {
+			r.releaseUE(ue)
+			released = append(released, ue)
+		}
+	}
+	return released
+}
+
+func (r *Registry) removeGNBUEs(g *AmfGNB) []*AmfUE {
+	var released []*AmfUE
+	for _, ue := range r.ues {
+		switch {
+		case ue.ho != nil && ue.ho.target == g:
+			// Handover target is gone, the UE stays with the source
+			sourceReleased := ue.ho.sourceReleased
+			ue.ho = nil
+			if !sourceReleased {
+				continue
+			}
+		case ue.Gnb == g && ue.ho != nil:
+			// Source may leave once the UE has been commanded to the target
+			ue.ho.sourceReleased = true
+			continue
+		case ue.Gnb != g:
+			continue
+		}
+		r.releaseUE(ue)
+		released = append(released, ue)
+	}
+	return released
+}
+
+// releaseUE moves a UE with a GUTI to CM-IDLE and removes any other context
+func (r *Registry) releaseUE(ue *AmfUE) {
+	if ue.Guti == (nas.GutiType{}) {
+		r.removeUE(ue)
+		return
+	}
+
+	key := ranUeKey{ue.Gnb, ue.RanUeNgapId}
+	if r.ranUes[key] == ue {
+		delete(r.ranUes, key)
+	}
+	ue.Gnb = nil
+	ue.RanUeNgapId = 0
+	ue.CmState = CmIdle
+	ue.idleSince = time.Now()
+}
+
+func (r *Registry) GNB(granId uint32) (*AmfGNB, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	g, ok := r.gnbs[granId]
+	return g, ok
+}
+
+func (r *Registry) GNBs() []*AmfGNB {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	gnbs := make([]*AmfGNB, 0, len(r.gnbs))
+	for _, g := range r.gnbs {
+		gnbs = append(gnbs, g)
+	}
+	return gnbs
+}
+
+// AddUE registers a new UE context served by ue.Gnb. An existing context
+// using the same RAN UE NGAP ID on that gNB is released.
+func (r *Registry) AddUE(ue *AmfUE) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := ranUeKey{ue.Gnb, ue.RanUeNgapId}
+	if old, ok := r.ranUes[key]; ok {
+		r.releaseUE(old)
+	}
+	ue.CmState = CmConnected
+	r.ues[ue.AmfUeNgapId] = ue
+	r.ranUes[key] = ue
+}
+
+// ReleaseUE releases the N2 association of the UE if it is served by g
+func (r *Registry) ReleaseUE(ue *AmfUE, g *AmfGNB, ranUeNgapId uint32) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if ue.Gnb != g || ue.RanUeNgapId != ranUeNgapId {
+		return errors.New("UE is not served by gNB")
+	}
+	r.releaseUE(ue)
+	return nil
+}
+
+// ConnectUE moves the UE to CM-CONNECTED over the given gNB, replacing any
+// stale N2 association
+func (r *Registry) ConnectUE(ue *AmfUE, g *AmfGNB, ranUeNgapId uint32) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.ues[ue.AmfUeNgapId]; !ok {
+		return errors.New("UE context expired")
+	}
+
+	key := ranUeKey{ue.Gnb, ue.RanUeNgapId}
+	if ue.CmState == CmConnected && r.ranUes[key] == ue {
+		delete(r.ranUes, key)
+	}
+	ue.ho = nil
+
+	key = ranUeKey{g, ranUeNgapId}
+	if old, ok := r.ranUes[key]; ok {
+		r.releaseUE(old)
+	}
+	ue.Gnb = g
+	ue.RanUeNgapId = ranUeNgapId
+	ue.CmState = CmConnected
+	r.ranUes[key] = ue
+	return nil
+}
+
+// RemoveIdle drops UE contexts that stayed in CM-IDLE for longer than maxIdle
+func (r *Registry) RemoveIdle(maxIdle time.Duration) []*AmfUE {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var removed []*AmfUE
+	for _, ue := range r.ues {
+		if ue.CmState == CmIdle && time.Since(ue.idleSince) > maxIdle {
+			r.removeUE(ue)
+			removed = append(removed, ue)
+		}
+	}
+	return removed
+}
+
+// Serving returns the gNB and RAN UE NGAP ID of a CM-CONNECTED UE
+func (r *Registry) Serving(ue *AmfUE) (*AmfGNB, uint32, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return ue.Gnb, ue.RanUeNgapId, ue.CmState == CmConnected
+}
+
+// ServedUE returns the UE context only if it is served by g
+func (r *Registry) ServedUE(g *AmfGNB, amfUeNgapId ngap.AmfUeNgapIdType, ranUeNgapId uint32) (*AmfUE, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	ue, ok := r.ues[amfUeNgapId]
+	if !ok || ue.Gnb != g || ue.RanUeNgapId != ranUeNgapId {
+		return nil, false
+	}
+	return ue, true
+}
+
+func (r *Registry) RemoveUE(ue *AmfUE) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.removeUE(ue)
+}
+
+func (r *Registry) removeUE(ue *AmfUE) {
+	if r.ues[ue.AmfUeNgapId] == ue {
+		delete(r.ues, ue.AmfUeNgapId)
+	}
+	key := ranUeKey{ue.Gnb, ue.RanUeNgapId}
+	if r.ranUes[key] == ue {
+		delete(r.ranUes, key)
+	}
+	r.unindexSupi(ue)
+	if r.gutis[ue.Guti.Tmsi] == ue {
+		delete(r.gutis, ue.Guti.Tmsi)
+	}
+	r.dropNextGuti(ue)
+}
+
+func (r *Registry) UE(amfUeNgapId ngap.AmfUeNgapIdType) (*AmfUE, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	ue, ok := r.ues[amfUeNgapId]
+	return ue, ok
+}
+
+func (r *Registry) UEByRanUeNgapId(g *AmfGNB, ranUeNgapId uint32) (*AmfUE, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	ue, ok := r.ranUes[ranUeKey{g, ranUeNgapId}]
+	return ue, ok
+}
+
+func (r *Registry) UEBySupi(supi string) (*AmfUE, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	ues := r.supis[supi]
+	if len(ues) == 0 {
+		return nil, false
+	}
+	return ues[len(ues)-1], true
+}
+
+func (r *Registry) UEByGuti(guti nas.GutiType) (*AmfUE, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	ue, ok := r.gutis[guti.Tmsi]
+	if !ok || (ue.Guti != guti && ue.nextGuti != guti) {
+		return nil, false
+	}
+	return ue, true
+}
+
+// IndexSupi makes a registered UE the one found by its SUPI. Several
+// contexts may share a SUPI, the most recent one is found until removed.
+func (r *Registry) IndexSupi(ue *AmfUE) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.unindexSupi(ue)
+	r.supis[ue.Supi] = append(r.supis[ue.Supi], ue)
+}
+
+func (r *Registry) unindexSupi(ue *AmfUE) {
+	ues := r.supis[ue.Supi]
+	for i, u := range ues {
+		if u == ue {
+			ues = append(ues[:i:i], ues[i+1:]...)
+			break
+		}
+	}
+	if len(ues) == 0 {
+		delete(r.supis, ue.Supi)
+	} else {
+		r.supis[ue.Supi] = ues
+	}
+}
+
+// AssignGuti allocates a unique 5G-TMSI for the UE under the given GUAMI
+func (r *Registry) AssignGuti(ue *AmfUE, guami nas.GutiType) nas.GutiType {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.gutis[ue.Guti.Tmsi] == ue {
+		delete(r.gutis, ue.Guti.Tmsi)
+	}
+	r.dropNextGuti(ue)
+
+	guti := r.newGuti(guami)
+	ue.Guti = guti
+	r.gutis[guti.Tmsi] = ue
+	return guti
+}
+
+// ReallocateGuti allocates a new GUTI for a registered UE. Both GUTIs
+// identify the UE until CommitGuti, the UE may not have received the new one.
+func (r *Registry) ReallocateGuti(ue *AmfUE, guami nas.GutiType) nas.GutiType {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.dropNextGuti(ue)
+	guti := r.newGuti(guami)
+	ue.nextGuti = guti
+	r.gutis[guti.Tmsi] = ue
+	return guti
+}
+
+// CommitGuti switches the UE to its reallocated GUTI and releases the old one
+func (r *Registry) CommitGuti(ue *AmfUE) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if ue.nextGuti == (nas.GutiType{}) {
+		return
+	}
+	if r.gutis[ue.Guti.Tmsi] == ue {
+		delete(r.gutis, ue.Guti.Tmsi)
+	}
+	ue.Guti = ue.nextGuti
+	ue.nextGuti = nas.GutiType{}
+}
+
+func (r *Registry) dropNextGuti(ue *AmfUE) {
+	if ue.nextGuti == (nas.GutiType{}) {
+		return
+	}
+	if r.gutis[ue.nextGuti.Tmsi] == ue {
+		delete(r.gutis, ue.nextGuti.Tmsi)
+	}
+	ue.nextGuti = nas.GutiType{}
+}
+
+// newGuti picks a 5G-TMSI not used under any GUAMI
+func (r *Registry) newGuti(guami nas.GutiType) nas.GutiType {
+	guti := guami
+	buf := make([]byte, 4)
+	for {
+		rand.Read(buf)
+		guti.Tmsi = binary.BigEndian.Uint32(buf)
+		if _, ok := r.gutis[guti.Tmsi]; !ok {
+			return guti
+		}
+	}
+}
+
+// StartHandover marks the UE as being prepared for handover to target
+func (r *Registry) StartHandover(ue *AmfUE, target *AmfGNB) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if ue.ho != nil {
+		return errors.New("handover already in progress")
+	}
+	ue.ho = &handover{target: target}
+	return nil
+}
+
+// AckHandover records the UE association allocated by the target gNB
+func (r *Registry) AckHandover(ue *AmfUE, target *AmfGNB, ranUeNgapId uint32) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if ue.ho == nil || ue.ho.target != target {
+		return errors.New("no handover to gNB in progress")
+	}
+	ue.ho.targetRanUeNgapId = ranUeNgapId
+	return nil
+}
+
+// CompleteHandover moves the UE context to the target gNB and returns the
+// source gNB and RAN UE NGAP ID.
+func (r *Registry) CompleteHandover(ue *AmfUE, target *AmfGNB, ranUeNgapId uint32) (*AmfGNB, uint32, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if ue.ho == nil || ue.ho.target != target || ue.ho.targetRanUeNgapId != ranUeNgapId {
+		return nil, 0, errors.New("no handover to gNB in progress")
+	}
+	if _, ok := r.ues[ue.AmfUeNgapId]; !ok {
+		return nil, 0, errors.New("UE context released during handover")
+	}
+
+	source, sourceRanUeNgapId := ue.Gnb, ue.RanUeNgapId
+	key := ranUeKey{source, sourceRanUeNgapId}
+	if r.ranUes[key] == ue {
+		delete(r.ranUes, key)
+	}
+
+	key = ranUeKey{target, ranUeNgapId}
+	if old, ok := r.ranUes[key]; ok {
+		r.releaseUE(old)
+	}
+	ue.Gnb = target
+	ue.RanUeNgapId = ranUeNgapId
+	ue.CmState = CmConnected
+	ue.ho = nil
+	r.ranUes[key] = ue
+	return source, sourceRanUeNgapId, nil
+}
+
+func (r *Registry) CancelHandover(ue *AmfUE, target *AmfGNB) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if ue.ho == nil || ue.ho.target != target {
+		return errors.New("no handover to gNB in progress")
+	}
+	ue.ho = nil
+	return nil
+}
@@ -0,0 +1,84 @@
+package core
+
+import (
+	"errors"
+	"phreaking/internal/crypto"
+	"phreaking/internal/udm"
+	"phreaking/pkg/nas"
+)
+
+var errRoamingNotAllowed = errors.New("roaming not allowed")
+
+type roaming struct {
+	home      nas.PlmnIdType
+	authCtxId string
+	hxres     string
+	// Slices requested on registration, allowed once the subscription is known
+	requestedNssai []nas.SNssaiType
+	subscription   udm.Subscription
+}
+
+// isRoaming reports whether the UE is a subscriber of another PLMN than the
+// serving one
+func isRoaming(ue *AmfUE, id nas.MobileIdType) bool {
+	return id.Plmn() != ue.plmn.Id
+}
+
+// roamingAuthRequest gets a challenge for a roaming UE from its home network
+func (amf *Amf) roamingAuthRequest(ue *AmfUE, regmsg nas.NASRegRequestMsg) (nas.NASAuthRequestMsg, error) {
+	home := regmsg.MobileId.Plmn()
+	supi := regmsg.MobileId.Supi()
+	if amf.Sepp == nil {
+		amf.Logger.Sugar().Infof("Roaming UE %s from PLMN %s refused: roaming not supported", supi, home)
+		return nas.NASAuthRequestMsg{}, errRoamingNotAllowed
+	}
+
+	res, err := amf.Sepp.Authenticate(home, supi, ue.plmn.Id)
+	if err != nil {
+		amf.Logger.Sugar().Infof("Roaming UE %s from PLMN %s refused: %v", supi, home, err)
+		return nas.NASAuthRequestMsg{}, errRoamingNotAllowed
+	}
+
+	ue.roaming = &roaming{home: home, authCtxId: res.AuthCtxId, hxres: res.HxRes, requestedNssai: regmsg.RequestedNssai}
+	return nas.NASAuthRequestMsg{Rand: res.Rand, AuthRand: res.AuthRand, Auth: res.Auth}, nil
+}
+
+// confirmRoamingAuth checks the response against the hash from the home
+// network, which then confirms it and hands out the subscription
+func (amf *Amf) confirmRoamingAuth(ue *AmfUE, res []byte, amfg *AmfGNB) error {
+	r := ue.roaming
+	if crypto.ComputeHash(res) != r.hxres {
+		return errAuth
+	}
+	conf, err := amf.Sepp.Confirm(r.home, r.authCtxId, res)
+	if err != nil {
+		amf.Logger.Sugar().Infof("Home network %s did not confirm UE %s: %v", r.home, ue.Supi, err)
+		return errAuth
+	}
+	if conf.Supi != ue.Supi {
+		return errAuth
+	}
+
+	r.subscription = conf.Subscription
+	if !ue.Emergency {
+		ue.AllowedNssai, ue.RejectedNssai = amf.allowedNssai(r.subscription, r.requestedNssai, amfg)
+	}
+	amf.Logger.Sugar().Infof("Roaming UE %s from PLMN %s authenticated by its home network", ue.Supi, r.home)
+	return nil
+}
+
+// homePlmn returns the PLMN the UE is a subscriber of
+func homePlmn(ue *AmfUE) nas.PlmnIdType {
+	if ue.roaming != nil {
+		return ue.roaming.home
+	}
+	return ue.plmn.Id
+}
+
+// subscription returns the subscription of the UE, from the home network if roaming
+func (amf *Amf) subscription(ue *AmfUE) udm.Subscription {
+	if ue.roaming != nil {
+		return ue.roaming.subscription
+	}
+	return ue.plmn.Udm.Subscription(ue.Supi)
+}
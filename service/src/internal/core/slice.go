@@ -0,0 +1,92 @@
+package core
+
+import (
+	"errors"
+	"phreaking/internal/udm"
+	"phreaking/pkg/nas"
+)
+
+var errNoSlices = errors.New("no slice supported by the AMF")
+
+func containsSNssai(nssai []nas.SNssaiType, snssai nas.SNssaiType) bool {
+	for _, s := range nssai {
+		if s == snssai {
+			return true
+		}
+	}
+	return false
+}
+
+// supportedNssai returns the slices of a gNB the AMF serves. A gNB listing no
+// slices supports the default slice of the AMF.
+func (amf *Amf) supportedNssai(gnbNssai []nas.SNssaiType) ([]nas.SNssaiType, error) {
+	if len(gnbNssai) == 0 {
+		return amf.Nssai[:1], nil
+	}
+
+	var nssai []nas.SNssaiType
+	for _, snssai := range gnbNssai {
+		if containsSNssai(amf.Nssai, snssai) && !containsSNssai(nssai, snssai) {
+			nssai = append(nssai, snssai)
+		}
+	}
+	if len(nssai) == 0 {
+		return nil, errNoSlices
+	}
+	return nssai, nil
+}
+
+// allowedNssai checks the requested slices against the subscription and the
+// slices of the serving gNB. The default subscribed slices are allowed if
+// none of the requested ones is.
+func (amf *Amf) allowedNssai(sub udm.Subscription, requested []nas.SNssaiType, amfg *AmfGNB) ([]nas.SNssaiType, []nas.RejectedSNssaiType) {
+	var allowed []nas.SNssaiType
+	var rejected []nas.RejectedSNssaiType
+
+	for _, snssai := range requested {
+		_, subscribed := sub.Slice(snssai)
+		switch {
+		case !subscribed || !containsSNssai(amf.Nssai, snssai):
+			rejected = append(rejected, nas.RejectedSNssaiType{SNssai: snssai, Cause: nas.NssaiNotAvailableInPlmn})
+		case !containsSNssai(amfg.Nssai, snssai):
+			rejected = append(rejected, nas.RejectedSNssaiType{SNssai: snssai, Cause: nas.NssaiNotAvailableInRegArea})
+		case !containsSNssai(allowed, snssai):
+			allowed = append(allowed, snssai)
+		}
+	}
+
+	if len(allowed) == 0 {
+		for _, snssai := range sub.DefaultNssai() {
+			if containsSNssai(amfg.Nssai, snssai) {
+				allowed = append(allowed, snssai)
+			}
+		}
+	}
+	return allowed, rejected
+}
+
+// selectSlice picks the slice and DNN of a new PDU session, defaulting to the
+// first allowed slice and the default DNN of the slice
+func (amf *Amf) selectSlice(ue *AmfUE, snssai nas.SNssaiType, dnn string) (nas.SNssaiType, string, nas.GsmCauseType, bool) {
+	if snssai.Sst == 0 {
+		if len(ue.AllowedNssai) == 0 {
+			return snssai, dnn, nas.GsmCauseServiceOptionNotSubscribed, false
+		}
+		snssai = ue.AllowedNssai[0]
+	}
+	if !containsSNssai(ue.AllowedNssai, snssai) {
+		return snssai, dnn, nas.GsmCauseServiceOptionNotSubscribed, false
+	}
+
+	slice, ok := amf.subscription(ue).Slice(snssai)
+	if !ok || len(slice.Dnns) == 0 {
+		return snssai, dnn, nas.GsmCauseUnknownDnnInSlice, false
+	}
+	if dnn == "" {
+		dnn = slice.Dnns[0]
+	}
+	if !slice.AllowsDnn(dnn) {
+		return snssai, dnn, nas.GsmCauseUnknownDnnInSlice, false
+	}
+	return snssai, dnn, 0, true
+}
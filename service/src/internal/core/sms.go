@@ -0,0 +1,169 @@
+package core
+
+import (
+	"errors"
+	"phreaking/internal/smsf"
+	"phreaking/pkg/nas"
+	"phreaking/pkg/ngap"
+	"phreaking/pkg/parser"
+	"phreaking/pkg/sms"
+	"time"
+)
+
+func smsCause(err error) sms.SmsCauseType {
+	switch {
+	case err == nil:
+		return sms.SmsCauseNone
+	case errors.Is(err, smsf.ErrInvalidRecipient):
+		return sms.SmsCauseUnassignedNumber
+	case errors.Is(err, smsf.ErrInboxFull), errors.Is(err, smsf.ErrStoreFull):
+		return sms.SmsCauseMemoryExceeded
+	}
+	return sms.SmsCauseInvalidMessage
+}
+
+func sendSms[T any](amf *Amf, ue *AmfUE, msgType sms.SmsMsgType, msgPtr *T) error {
+	msg, err := parser.EncodeMsg(msgPtr)
+	if err != nil {
+		return errEncode
+	}
+	header := sms.SmsHeader{MessageType: msgType, Message: msg}
+	container, err := parser.EncodeMsg(&header)
+	if err != nil {
+		return errEncode
+	}
+
+	dl := nas.DLNASTransportMsg{PayloadContainerType: nas.PayloadSms, PayloadContainer: container}
+	return sendNAS(amf, ue, nas.DLNASTransport, &dl)
+}
+
+// handleSms is the SMSF side of SMS over NAS
+func (amf *Amf) handleSms(ue *AmfUE, container []byte) error {
+	var header sms.SmsHeader
+	err := parser.DecodeMsg(container, &header)
+	if err != nil {
+		return errDecode
+	}
+
+	switch header.MessageType {
+	case sms.Submit:
+		var msg sms.SubmitMsg
+		err = parser.DecodeMsg(header.Message, &msg)
+		if err != nil {
+			return errDecode
+		}
+
+		var stored smsf.Message
+		toUe, err := amf.smsRecipient(msg.Recipient)
+		if err == nil {
+			stored, err = amf.Smsf.Submit(ue.AmfUeNgapId, ue.Supi, msg.Recipient, toUe, msg.Text, msg.Reference,
+				msg.StatusReport)
+		}
+		if err != nil {
+			amf.Logger.Sugar().Infof("SMS from %s refused: %v", ue.Supi, err)
+		} else {
+			amf.Logger.Sugar().Infof("SMS %d from %s to %s stored", stored.Id, ue.Supi, stored.To)
+			go amf.deliverSms(stored)
+		}
+		report := sms.SubmitReportMsg{Reference: msg.Reference, Cause: smsCause(err)}
+		return sendSms(amf, ue, sms.SubmitReport, &report)
+	case sms.DeliverReport:
+		var msg sms.DeliverReportMsg
+		err = parser.DecodeMsg(header.Message, &msg)
+		if err != nil {
+			return errDecode
+		}
+
+		delivered, ok := amf.Smsf.Delivered(ue.Supi, ue.AmfUeNgapId, msg.MessageId)
+		if ok && delivered.StatusReport {
+			go amf.reportSmsStatus(delivered)
+		}
+		return nil
+	}
+	return errDecode
+}
+
+// smsRecipient accepts subscribers of the core and SUPIs registered right
+// now. The SUPI of a UE is only claimed, so a message for a SUPI without a
+// subscription is pinned to the context registered with it and not queued for
+// whoever claims the SUPI next.
+func (amf *Amf) smsRecipient(supi string) (ngap.AmfUeNgapIdType, error) {
+	for _, p := range amf.Plmns {
+		if p.Udm != nil && p.Udm.Subscribed(supi) {
+			return ngap.AmfUeNgapIdType{}, nil
+		}
+	}
+	if ue, ok := amf.Registry.UEBySupi(supi); ok {
+		return ue.AmfUeNgapId, nil
+	}
+	return ngap.AmfUeNgapIdType{}, smsf.ErrInvalidRecipient
+}
+
+// deliverSms runs outside of the UE lock like reportLocation. An idle
+// recipient is paged, an unregistered subscriber gets the message on
+// registration.
+func (amf *Amf) deliverSms(msg smsf.Message) {
+	ue, ok := amf.Registry.UEBySupi(msg.To)
+	if msg.Pinned {
+		ue, ok = amf.Registry.UE(msg.DeliveredTo)
+	}
+	if !ok {
+		return
+	}
+
+	ue.mu.Lock()
+	defer ue.mu.Unlock()
+	if !ue.Registered {
+		return
+	}
+	msg, ok = amf.Smsf.Deliver(msg, ue.AmfUeNgapId, amf.ueExists)
+	if !ok {
+		return
+	}
+	err := amf.sendDeliver(ue, msg)
+	if err != nil {
+		amf.Logger.Sugar().Warnf("Cannot deliver SMS %d: %v", msg.Id, err)
+	}
+}
+
+// deliverPendingSms sends a newly registered UE the messages of its inbox
+// that no other context of the SUPI holds
+func (amf *Amf) deliverPendingSms(ue *AmfUE) {
+	if amf.Smsf == nil || ue.Emergency {
+		return
+	}
+	for _, msg := range amf.Smsf.Pending(ue.Supi, ue.AmfUeNgapId, amf.ueExists) {
+		err := amf.sendDeliver(ue, msg)
+		if err != nil {
+			amf.Logger.Sugar().Warnf("Cannot deliver SMS %d: %v", msg.Id, err)
+			return
+		}
+	}
+}
+
+func (amf *Amf) sendDeliver(ue *AmfUE, msg smsf.Message) error {
+	deliver := sms.DeliverMsg{MessageId: msg.Id, Originator: msg.From, Text: msg.Text, Time: msg.Submitted}
+	return sendSms(amf, ue, sms.Deliver, &deliver)
+}
+
+// ueExists reports whether the UE context still exists
+func (amf *Amf) ueExists(amfUeNgapId ngap.AmfUeNgapIdType) bool {
+	_, ok := amf.Registry.UE(amfUeNgapId)
+	return ok
+}
+
+// reportSmsStatus tells the context that submitted the message
+func (amf *Amf) reportSmsStatus(msg smsf.Message) {
+	ue, ok := amf.Registry.UE(msg.FromUe)
+	if !ok {
+		return
+	}
+
+	ue.mu.Lock()
+	defer ue.mu.Unlock()
+	report := sms.StatusReportMsg{Reference: msg.Reference, Recipient: msg.To, Delivered: time.Now()}
+	err := sendSms(amf, ue, sms.StatusReport, &report)
+	if err != nil {
+		amf.Logger.Sugar().Warnf("Cannot send SMS status report: %v", err)
+	}
+}
@@ -0,0 +1,106 @@
+package core
+
+import (
+	"errors"
+	"net"
+	"phreaking/internal/crypto"
+	"phreaking/pkg/nas"
+	"phreaking/pkg/ngap"
+)
+
+// Tai returns the tracking area the gNB serves in the PLMN
+func (g *AmfGNB) Tai(p *Plmn) nas.TaiType {
+	return nas.TaiType{Plmn: p.Plmn, Tac: g.Tac}
+}
+
+func containsTai(taiList []nas.TaiType, tai nas.TaiType) bool {
+	for _, t := range taiList {
+		if t == tai {
+			return true
+		}
+	}
+	return false
+}
+
+// userLocation fills in the TAI of the gNB in the serving PLMN if the
+// location has none
+func userLocation(loc ngap.UserLocationType, amfg *AmfGNB, plmn *Plmn) ngap.UserLocationType {
+	if loc.Tai == (nas.TaiType{}) {
+		loc.Tai = amfg.Tai(plmn)
+	}
+	return loc
+}
+
+// taiList returns the registration area containing the TAI, a TAI outside
+// of the configured areas is a registration area of its own
+func (amf *Amf) taiList(tai nas.TaiType) []nas.TaiType {
+	for _, area := range amf.RegistrationAreas {
+		if containsTai(area, tai) {
+			return area
+		}
+	}
+	return []nas.TaiType{tai}
+}
+
+// handleRegistrationUpdate assigns a new TAI list to a registered UE that
+// left its registration area or updates periodically. The GUTI is kept, so
+// no Registration Complete is expected.
+func (amf *Amf) handleRegistrationUpdate(ue *AmfUE, msg nas.NASRegRequestMsg, amfg *AmfGNB) error {
+	if !ue.Registered {
+		return errNotAuth
+	}
+	// The UE received the reallocated GUTI
+	if msg.Guti != (nas.GutiType{}) && msg.Guti == ue.nextGuti {
+		amf.Registry.CommitGuti(ue)
+	}
+	if msg.Guti != ue.Guti {
+		return errors.New("registration update with stale GUTI")
+	}
+
+	if cause, barred := amf.Barring.barred(ue.Supi, homePlmn(ue), ue.Location.Tai); barred && !ue.Emergency {
+		amf.Logger.Sugar().Infof("Registration update of barred UE %s rejected (cause %d)", ue.Supi, cause)
+		return amf.rejectRegistration(ue, cause)
+	}
+
+	ue.TaiList = amf.taiList(ue.Location.Tai)
+	if !ue.Emergency {
+		ue.AllowedNssai, ue.RejectedNssai = amf.allowedNssai(amf.subscription(ue), msg.RequestedNssai, amfg)
+	}
+
+	amf.Logger.Sugar().Infof("Registration update (type %d) of UE %s in TAI %d/%d", msg.RegistrationType, ue.Supi,
+		ue.Location.Tai.Plmn, ue.Location.Tai.Tac)
+
+	regAcc := nas.NASRegAcceptMsg{Guti: ue.Guti, TaiList: ue.TaiList, AllowedNssai: ue.AllowedNssai,
+		RejectedNssai: ue.RejectedNssai}
+	return sendNAS(amf, ue, nas.InitialContextSetupRequestRegAccept, &regAcc)
+}
+
+// handleIdleRegistrationUpdate handles a registration update starting a new
+// N2 connection. Like a Service Request it is integrity protected only.
+func (amf *Amf) handleIdleRegistrationUpdate(c net.Conn, initmsg ngap.InitUEMessageMsg, msg nas.NASRegRequestMsg, amfg *AmfGNB) error {
+	ue, ok := amf.Registry.UEByGuti(msg.Guti)
+	if !ok {
+		return errUnknownUE
+	}
+	if !amfg.broadcasts(ue.plmn) {
+		return errPlmnNotBroadcast
+	}
+
+	ue.mu.Lock()
+	defer ue.mu.Unlock()
+
+	if !initmsg.NasPdu.Security {
+		return errNotAuth
+	}
+	err := crypto.CheckIntegrity(ue.IaAlg, initmsg.NasPdu.Message, initmsg.NasPdu.Mac)
+	if err != nil {
+		return errIntegrity
+	}
+
+	err = amf.Registry.ConnectUE(ue, amfg, initmsg.RanUeNgapId)
+	if err != nil {
+		return err
+	}
+	ue.Location = userLocation(initmsg.UserLocation, amfg, ue.plmn)
+	return amf.handleRegistrationUpdate(ue, msg, amfg)
+}
@@ -0,0 +1,105 @@
+package core
+
+import (
+	"net"
+	"phreaking/pkg/nas"
+	"phreaking/pkg/parser"
+)
+
+// handleULNASTransport routes the payload container to the function serving
+// its type, payloads without one are answered with 5GMM cause 90
+func (amf *Amf) handleULNASTransport(c net.Conn, buf []byte, amfg *AmfGNB, ue *AmfUE) error {
+	var msg nas.ULNASTransportMsg
+
+	if !ue.authorized() {
+		return errNotAuth
+	}
+
+	err := parser.DecodeMsg(buf, &msg)
+	if err != nil {
+		return errDecode
+	}
+
+	// Emergency registered UEs get session management and positioning only
+	switch msg.PayloadContainerType {
+	case nas.PayloadN1SmInfo:
+		return amf.handleN1SmInfo(c, amfg, ue, msg)
+	case nas.PayloadLpp:
+		return amf.handleLpp(ue, msg.PayloadContainer)
+	case nas.PayloadCIoTUserData:
+		if !ue.Emergency {
+			return amf.handleCIoTUserData(ue, msg)
+		}
+	case nas.PayloadSms:
+		if amf.Smsf != nil && !ue.Emergency {
+			return amf.handleSms(ue, msg.PayloadContainer)
+		}
+	}
+
+	amf.Logger.Sugar().Infof("UL NAS Transport payload type %d of UE %s not forwarded", msg.PayloadContainerType, ue.Supi)
+	return amf.rejectPayload(ue, msg.PayloadContainerType, msg.PduSesId)
+}
+
+// rejectPayload answers an uplink payload with 5GMM cause 90
+func (amf *Amf) rejectPayload(ue *AmfUE, payloadType nas.PayloadContainerType, pduSesId uint8) error {
+	dl := nas.DLNASTransportMsg{PayloadContainerType: payloadType, PduSesId: pduSesId, Cause: nas.GmmCausePayloadNotForwarded}
+	return sendNAS(amf, ue, nas.DLNASTransport, &dl)
+}
+
+// handleN1SmInfo passes a 5GSM message to the session management handlers,
+// they answer with the plain downlink messages
+func (amf *Amf) handleN1SmInfo(c net.Conn, amfg *AmfGNB, ue *AmfUE, msg nas.ULNASTransportMsg) error {
+	var sm nas.N1SmContainerType
+	err := parser.DecodeMsg(msg.PayloadContainer, &sm)
+	if err != nil {
+		return errDecode
+	}
+
+	if ue.Emergency && !emergencyNAS[sm.MessageType] {
+		return amf.rejectPayload(ue, nas.PayloadN1SmInfo, msg.PduSesId)
+	}
+
+	switch sm.MessageType {
+	case nas.PDUSessionEstRequest:
+		return amf.handlePDUSessionEstRequest(c, sm.Message, amfg, ue)
+	case nas.PDUSessionModificationRequest:
+		return amf.handlePDUSessionModificationRequest(c, sm.Message, amfg, ue)
+	case nas.PDUSessionModificationComplete:
+		return amf.handlePDUSessionModificationComplete(c, sm.Message, amfg, ue)
+	case nas.PDUSessionReleaseRequest:
+		return amf.handlePDUSessionReleaseRequest(c, sm.Message, amfg, ue)
+	case nas.PDUSessionReleaseComplete:
+		return amf.handlePDUSessionReleaseComplete(c, sm.Message, amfg, ue)
+	}
+	return amf.rejectPayload(ue, nas.PayloadN1SmInfo, msg.PduSesId)
+}
+
+// handleCIoTUserData sends small data over NAS to the data network of the
+// session, without user plane resources
+func (amf *Amf) handleCIoTUserData(ue *AmfUE, msg nas.ULNASTransportMsg) error {
+	sess, ok := ue.PDUs[msg.PduSesId]
+	if !ok {
+		return amf.rejectPayload(ue, nas.PayloadCIoTUserData, msg.PduSesId)
+	}
+
+	go amf.forwardCIoTUserData(ue, sess.PduSesType, msg.PduSesId, msg.PayloadContainer)
+	return nil
+}
+
+// forwardCIoTUserData runs outside of the UE lock like forwardPDUReq
+func (amf *Amf) forwardCIoTUserData(ue *AmfUE, pduSesType nas.PduSesType, pduSesId uint8, data []byte) {
+	response, err := amf.Upf.Handle(pduSesType, data)
+	if err != nil {
+		amf.Logger.Sugar().Infof("CIoT user data failed: %v", err)
+		response = []byte("error: " + err.Error())
+	}
+
+	ue.mu.Lock()
+	defer ue.mu.Unlock()
+
+	dl := nas.DLNASTransportMsg{PayloadContainerType: nas.PayloadCIoTUserData, PayloadContainer: response, PduSesId: pduSesId}
+	err = sendNAS(amf, ue, nas.DLNASTransport, &dl)
+	if err != nil {
+		amf.Logger.Sugar().Warnf("Cannot send CIoT user data: %v", err)
+	}
+}
@@ -0,0 +1,111 @@
+package core
+
+import (
+	"errors"
+	"net"
+	"phreaking/internal/io"
+	"phreaking/pkg/ngap"
+	"phreaking/pkg/parser"
+)
+
+// errorCause maps a failed UE associated procedure to the NGAP cause
+func errorCause(err error) ngap.CauseType {
+	switch {
+	case errors.Is(err, errDecode):
+		return ngap.CauseAbstractSyntaxError
+	case errors.Is(err, errUnknownUE):
+		return ngap.CauseUnknownLocalUeNgapId
+	case errors.Is(err, errAuth), errors.Is(err, errNotAuth), errors.Is(err, errIntegrity):
+		return ngap.CauseAuthenticationFailure
+	}
+	return ngap.CauseUnspecified
+}
+
+// sendErrorIndication reports a failed UE associated message to the gNB and
+// releases the UE, leaving the N2 association and other UEs untouched
+func (amf *Amf) sendErrorIndication(c net.Conn, buf []byte, amfg *AmfGNB, err error) error {
+	cause := errorCause(err)
+
+	var ids ngap.UeNgapIdsMsg
+	if parser.DecodeMsg(buf, &ids) != nil {
+		ind := ngap.ErrorIndicationMsg{Cause: ngap.CauseAbstractSyntaxError}
+		return io.SendNgapMsg(c, ngap.ErrorIndication, &ind)
+	}
+
+	ind := ngap.ErrorIndicationMsg{AmfUeNgapId: ids.AmfUeNgapId, RanUeNgapId: ids.RanUeNgapId, Cause: cause}
+	err = io.SendNgapMsg(c, ngap.ErrorIndication, &ind)
+	if err != nil {
+		return err
+	}
+
+	ue, ok := amf.Registry.ServedUE(amfg, ids.AmfUeNgapId, ids.RanUeNgapId)
+	if !ok {
+		return nil
+	}
+	return amf.releaseUE(ue, cause)
+}
+
+func (amf *Amf) handleErrorIndication(c net.Conn, buf []byte, amfg *AmfGNB) error {
+	var msg ngap.ErrorIndicationMsg
+	err := parser.DecodeMsg(buf, &msg)
+	if err != nil {
+		return errDecode
+	}
+
+	// Never answered, so that both sides cannot loop on each other
+	amf.Logger.Sugar().Warnf("Error Indication (cause %d) from gNB %d for RAN UE NGAP ID %d",
+		msg.Cause, amfg.GranId, msg.RanUeNgapId)
+	return nil
+}
+
+func (amf *Amf) handleUEContextReleaseRequest(c net.Conn, buf []byte, amfg *AmfGNB) error {
+	var msg ngap.UEContextReleaseRequestMsg
+	err := parser.DecodeMsg(buf, &msg)
+	if err != nil {
+		return errDecode
+	}
+
+	ue, ok := amf.Registry.ServedUE(amfg, msg.AmfUeNgapId, msg.RanUeNgapId)
+	if !ok {
+		return errUnknownUE
+	}
+
+	ue.mu.Lock()
+	defer ue.mu.Unlock()
+
+	amf.Logger.Sugar().Infof("UE context release requested by gNB %d (cause %d)", amfg.GranId, msg.Cause)
+	return amf.releaseUE(ue, msg.Cause)
+}
+
+// releaseUE asks the serving gNB to release the UE, which enters CM-IDLE on
+// UE Context Release Complete
+func (amf *Amf) releaseUE(ue *AmfUE, cause ngap.CauseType) error {
+	g, ranUeNgapId, connected := amf.Registry.Serving(ue)
+	if !connected {
+		return nil
+	}
+
+	cmd := ngap.UEContextReleaseCommandMsg{AmfUeNgapId: ue.AmfUeNgapId, RanUeNgapId: ranUeNgapId, Cause: cause}
+	return io.SendNgapMsg(g.Conn, ngap.UEContextReleaseCommand, &cmd)
+}
+
+func (amf *Amf) handleUEContextReleaseComplete(c net.Conn, buf []byte, amfg *AmfGNB) error {
+	var msg ngap.UEContextReleaseCompleteMsg
+	err := parser.DecodeMsg(buf, &msg)
+	if err != nil {
+		return errDecode
+	}
+
+	ue, ok := amf.Registry.ServedUE(amfg, msg.AmfUeNgapId, msg.RanUeNgapId)
+	if !ok {
+		// Already moved, e.g. source gNB after handover
+		return nil
+	}
+
+	err = amf.Registry.ReleaseUE(ue, amfg, msg.RanUeNgapId)
+	if err != nil {
+		return nil
+	}
+	amf.Logger.Sugar().Infof("UE context released by gNB %d", amfg.GranId)
+	return nil
+}
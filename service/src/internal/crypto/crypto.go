@@ -141,4 +141,13 @@ func IA4(msg []byte) (mac []byte) {
 	return hash.Sum(nil)
 }
 
+// LcsClientMac proves an LCS client holds its key, bound to the RAND of the
+// authentication so it is not valid in another UE context
+func LcsClientMac(clientKey []byte, rand []byte, targetSupi string) []byte {
+	hash := hmac.New(sha256.New, clientKey)
+	hash.Write(rand)
+	hash.Write([]byte(targetSupi))
+	return hash.Sum(nil)
+}
+
 var IAalg = map[uint8]func([]byte) []byte{0: IA0, 1: IA1, 2: IA2, 3: IA3, 4: IA4}
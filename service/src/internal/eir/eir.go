@@ -0,0 +1,82 @@
+package eir
+
+import (
+	"errors"
+	"strings"
+)
+
+var errInvalidPei = errors.New("PEI is not an IMEI or IMEISV")
+
+type EquipmentStatus uint8
+
+// Lists of the EIR
+const (
+	White EquipmentStatus = iota
+	// Allowed, but the equipment is watched
+	Grey
+	// Stolen or banned equipment, refused
+	Black
+)
+
+func (s EquipmentStatus) String() string {
+	switch s {
+	case White:
+		return "white"
+	case Grey:
+		return "grey"
+	case Black:
+		return "black"
+	}
+	return "unknown"
+}
+
+// Eir stands in for the equipment identity register
+type Eir struct {
+	// List per type allocation code (8 digits) or per equipment (TAC and
+	// serial number, 14 digits). An equipment entry overrides its TAC.
+	Lists map[string]EquipmentStatus
+	// Equipment on no list
+	Unlisted EquipmentStatus
+}
+
+// Check returns the list the equipment is on. A PEI that is no IMEI or
+// IMEISV is treated as unlisted.
+func (e *Eir) Check(pei string) (EquipmentStatus, error) {
+	id, err := equipmentId(pei)
+	if err != nil {
+		return e.Unlisted, err
+	}
+	if s, ok := e.Lists[id]; ok {
+		return s, nil
+	}
+	if s, ok := e.Lists[id[:8]]; ok {
+		return s, nil
+	}
+	return e.Unlisted, nil
+}
+
+// equipmentId returns the TAC and serial number of an IMEI or IMEISV PEI,
+// without check digit or software version
+func equipmentId(pei string) (string, error) {
+	var digits string
+	switch {
+	case strings.HasPrefix(pei, "imei-"):
+		digits = strings.TrimPrefix(pei, "imei-")
+		if len(digits) != 15 {
+			return "", errInvalidPei
+		}
+	case strings.HasPrefix(pei, "imeisv-"):
+		digits = strings.TrimPrefix(pei, "imeisv-")
+		if len(digits) != 16 {
+			return "", errInvalidPei
+		}
+	default:
+		return "", errInvalidPei
+	}
+	for _, d := range digits {
+		if d < '0' || d > '9' {
+			return "", errInvalidPei
+		}
+	}
+	return digits[:14], nil
+}
@@ -0,0 +1,90 @@
+package geofence
+
+import (
+	"errors"
+	"math"
+	"time"
+)
+
+var (
+	errNoId    = errors.New("fence without ID")
+	errShape   = errors.New("fence needs either a radius or a polygon of at least 3 points")
+	errPoint   = errors.New("fence point out of range")
+	errDwell   = errors.New("negative dwell time")
+	errDupName = errors.New("duplicate fence ID")
+)
+
+// Mean earth radius in meters
+const earthRadius = 6371000
+
+type Point struct {
+	Latitude  float64
+	Longitude float64
+}
+
+// Fence is a circle around Center if Radius is set, a polygon otherwise.
+// Polygons are evaluated on the plane of degrees, they should not span more
+// than a few kilometers or cross the antimeridian.
+type Fence struct {
+	Id     string
+	Center Point
+	// Meters
+	Radius  float64
+	Polygon []Point
+	// Time inside after which a dwell event is sent, zero for none
+	Dwell time.Duration
+}
+
+func (p Point) valid() bool {
+	return p.Latitude >= -90 && p.Latitude <= 90 && p.Longitude >= -180 && p.Longitude <= 180
+}
+
+func (f Fence) Validate() error {
+	if f.Id == "" {
+		return errNoId
+	}
+	if f.Dwell < 0 {
+		return errDwell
+	}
+	if (f.Radius > 0) == (len(f.Polygon) > 0) || (f.Radius == 0 && len(f.Polygon) < 3) {
+		return errShape
+	}
+	if !f.Center.valid() || math.IsInf(f.Radius, 1) {
+		return errPoint
+	}
+	for _, p := range f.Polygon {
+		if !p.valid() {
+			return errPoint
+		}
+	}
+	return nil
+}
+
+// Contains reports whether the point lies inside the fence
+func (f Fence) Contains(p Point) bool {
+	if f.Radius > 0 {
+		return distance(f.Center, p) <= f.Radius
+	}
+
+	// Ray casting
+	inside := false
+	for i, j := 0, len(f.Polygon)-1; i < len(f.Polygon); j, i = i, i+1 {
+		a, b := f.Polygon[i], f.Polygon[j]
+		if (a.Latitude > p.Latitude) != (b.Latitude > p.Latitude) &&
+			p.Longitude < (b.Longitude-a.Longitude)*(p.Latitude-a.Latitude)/(b.Latitude-a.Latitude)+a.Longitude {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// distance returns the great-circle distance in meters
+func distance(a, b Point) float64 {
+	lat1 := a.Latitude * math.Pi / 180
+	lat2 := b.Latitude * math.Pi / 180
+	dLat := lat2 - lat1
+	dLon := (b.Longitude - a.Longitude) * math.Pi / 180
+
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(h))
+}
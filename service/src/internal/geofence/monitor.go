@@ -0,0 +1,112 @@
+package geofence
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+type EventType string
+
+const (
+	Enter EventType = "ENTER"
+	Leave EventType = "LEAVE"
+	Dwell EventType = "DWELL"
+)
+
+// Event reports a UE crossing or staying in one of its fences
+type Event struct {
+	Supi      string    `json:"supi"`
+	Fence     string    `json:"fence"`
+	Type      EventType `json:"event"`
+	Latitude  float64   `json:"latitude"`
+	Longitude float64   `json:"longitude"`
+	Time      time.Time `json:"time"`
+}
+
+// Notifier delivers events, it must not block
+type Notifier interface {
+	Notify(Event)
+}
+
+type fenceState struct {
+	inside bool
+	since  time.Time
+	dwelt  bool
+}
+
+// Monitor evaluates the fences of a subscriber on each position update
+type Monitor struct {
+	notifier Notifier
+
+	mu     sync.Mutex
+	fences map[string][]Fence
+	// Per SUPI and fence ID
+	states map[string]map[string]*fenceState
+}
+
+// NewMonitor checks the fences, keyed by SUPI
+func NewMonitor(fences map[string][]Fence, notifier Notifier) (*Monitor, error) {
+	for supi, list := range fences {
+		ids := make(map[string]bool)
+		for _, f := range list {
+			err := f.Validate()
+			if err != nil {
+				return nil, fmt.Errorf("fence %q of %s: %w", f.Id, supi, err)
+			}
+			if ids[f.Id] {
+				return nil, fmt.Errorf("fence %q of %s: %w", f.Id, supi, errDupName)
+			}
+			ids[f.Id] = true
+		}
+	}
+	return &Monitor{notifier: notifier, fences: fences, states: make(map[string]map[string]*fenceState)}, nil
+}
+
+// Update evaluates a new position of the UE measured at t. Entering a fence
+// is only reported once a position inside is seen, the first update outside
+// reports nothing.
+func (m *Monitor) Update(supi string, p Point, t time.Time) {
+	m.mu.Lock()
+	fences := m.fences[supi]
+	if len(fences) == 0 {
+		m.mu.Unlock()
+		return
+	}
+	states, ok := m.states[supi]
+	if !ok {
+		states = make(map[string]*fenceState)
+		m.states[supi] = states
+	}
+
+	var events []Event
+	for _, f := range fences {
+		s, ok := states[f.Id]
+		if !ok {
+			s = &fenceState{}
+			states[f.Id] = s
+		}
+
+		event := Event{Supi: supi, Fence: f.Id, Latitude: p.Latitude, Longitude: p.Longitude, Time: t}
+		inside := f.Contains(p)
+		switch {
+		case inside && !s.inside:
+			*s = fenceState{inside: true, since: t}
+			event.Type = Enter
+		case !inside && s.inside:
+			*s = fenceState{}
+			event.Type = Leave
+		case inside && f.Dwell > 0 && !s.dwelt && t.Sub(s.since) >= f.Dwell:
+			s.dwelt = true
+			event.Type = Dwell
+		default:
+			continue
+		}
+		events = append(events, event)
+	}
+	m.mu.Unlock()
+
+	for _, e := range events {
+		m.notifier.Notify(e)
+	}
+}
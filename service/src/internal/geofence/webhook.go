@@ -0,0 +1,79 @@
+package geofence
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Events waiting for delivery, newer ones are dropped when full
+const webhookQueue = 256
+
+// Webhook posts each event as JSON to a local URL, in order and one at a time
+type Webhook struct {
+	Logger  *zap.Logger
+	Url     string
+	Timeout time.Duration
+
+	queue chan Event
+}
+
+func NewWebhook(logger *zap.Logger, url string, timeout time.Duration) *Webhook {
+	w := &Webhook{Logger: logger, Url: url, Timeout: timeout, queue: make(chan Event, webhookQueue)}
+	go w.run()
+	return w
+}
+
+func (w *Webhook) Notify(e Event) {
+	select {
+	case w.queue <- e:
+	default:
+		w.Logger.Sugar().Warnf("Geofence webhook queue full, dropping %s event of %s", e.Type, e.Supi)
+	}
+}
+
+func (w *Webhook) run() {
+	client := http.Client{Timeout: w.Timeout}
+	for e := range w.queue {
+		err := w.post(&client, e)
+		if err != nil {
+			w.Logger.Sugar().Warnf("Geofence webhook: %v", err)
+		}
+	}
+}
+
+func (w *Webhook) post(client *http.Client, e Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, w.Url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("status %s", res.Status)
+	}
+	return nil
+}
+
+// LogNotifier only logs events, used when no webhook is configured
+type LogNotifier struct {
+	Logger *zap.Logger
+}
+
+func (l LogNotifier) Notify(e Event) {
+	l.Logger.Sugar().Infof("Geofence %s: %s %s at %f,%f", e.Fence, e.Supi, e.Type, e.Latitude, e.Longitude)
+}
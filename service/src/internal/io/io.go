@@ -3,6 +3,7 @@ package io
 import (
 	"errors"
 	"io"
+	"math"
 	"net"
 	"phreaking/pkg/nas"
 	"phreaking/pkg/ngap"
@@ -11,20 +12,19 @@ import (
 
 var EOF error = io.EOF
 
+// Send writes a length prefixed frame with a single Write, so frames from
+// goroutines sharing a connection do not interleave.
 func Send(conn net.Conn, msg []byte) (err error) {
+	if len(msg) > math.MaxUint16 {
+		return errors.New("msg length exceeds frame size")
+	}
 	msgLen := uint16(len(msg))
-	buf := make([]byte, 2)
+	buf := make([]byte, 2, 2+len(msg))
 	buf[0] = uint8(msgLen >> 8)
 	buf[1] = uint8(msgLen & 0xff)
+	buf = append(buf, msg...)
 	_, err = conn.Write(buf)
-	if err != nil {
-		return err
-	}
-	_, err = conn.Write(msg)
-	if err != nil {
-		return err
-	}
-	return nil
+	return err
 }
 
 func SendGmm(conn net.Conn, gmm nas.GmmHeader) (err error) {
@@ -53,7 +53,7 @@ func SendNgapMsg[T any](conn net.Conn, ngapType ngap.NgapMsgType, msgPtr *T) (er
 func Recv(conn net.Conn) ([]byte, error) {
 	buf := make([]byte, 2)
 
-	_, err := conn.Read(buf)
+	_, err := io.ReadFull(conn, buf)
 	if err != nil {
 		return nil, err
 	}
@@ -63,7 +63,7 @@ func Recv(conn net.Conn) ([]byte, error) {
 		return nil, errors.New("msg length of buffer is zero or negative")
 	}
 	buf = make([]byte, msgLen)
-	_, err = conn.Read(buf)
+	_, err = io.ReadFull(conn, buf)
 	return buf, err
 
 }
@@ -0,0 +1,68 @@
+package lmf
+
+import (
+	"errors"
+	"math"
+	"phreaking/pkg/lpp"
+	"phreaking/pkg/nas"
+	"time"
+)
+
+var ErrUnknownCell = errors.New("position of serving cell not known")
+
+// Log-distance path loss model of the simulated cells
+const (
+	// dBm received at 1 m from the antenna
+	rssiAt1m = -30
+	exponent = 3
+	// Bounds of the uncertainty in meters
+	minUncertainty = 50
+	maxUncertainty = 5000
+)
+
+// Cell is the antenna site of a cell
+type Cell struct {
+	Latitude  float64
+	Longitude float64
+	Altitude  float64
+}
+
+// Lmf estimates UE positions from LPP measurements
+type Lmf struct {
+	// By NR cell identity
+	Cells map[uint64]Cell
+	// Methods the LMF can use, in order of preference
+	Methods []lpp.PositioningMethodType
+}
+
+// SelectMethod picks the preferred method the UE supports
+func (l *Lmf) SelectMethod(supported []lpp.PositioningMethodType) (lpp.PositioningMethodType, bool) {
+	for _, m := range l.Methods {
+		for _, s := range supported {
+			if m == s {
+				return m, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// EstimateEcid places the UE at the antenna of its serving cell, the signal
+// strength gives the distance and thereby the uncertainty
+func (l *Lmf) EstimateEcid(loc nas.TaiType, cellId uint64, m lpp.CellMeasurementType) (nas.PositionType, error) {
+	cell, ok := l.Cells[cellId]
+	if !ok {
+		return nas.PositionType{}, ErrUnknownCell
+	}
+
+	distance := math.Pow(10, float64(rssiAt1m-int(m.Rssi))/(10*exponent))
+	if distance < minUncertainty {
+		distance = minUncertainty
+	}
+	if distance > maxUncertainty {
+		distance = maxUncertainty
+	}
+
+	return nas.PositionType{Latitude: cell.Latitude, Longitude: cell.Longitude, Altitude: cell.Altitude,
+		Uncertainty: math.Round(distance), Source: nas.PositionSourceCell, Time: time.Now(), Tai: loc, CellId: cellId}, nil
+}
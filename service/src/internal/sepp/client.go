@@ -0,0 +1,105 @@
+package sepp
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"phreaking/internal/io"
+	"phreaking/pkg/nas"
+	"phreaking/pkg/parser"
+	"time"
+)
+
+var (
+	ErrNoRoute              = errors.New("no SEPP of the home network")
+	ErrRoamingNotAllowed    = errors.New("roaming not allowed")
+	ErrAuthenticationFailed = errors.New("authentication rejected by the home network")
+	ErrUnknownSubscriber    = errors.New("unknown subscriber")
+)
+
+// Time to answer a request, on both sides
+const requestTimeout = 3 * time.Second
+
+// Client reaches the home networks of roaming UEs
+type Client struct {
+	// SEPP address per home PLMN
+	Peers map[nas.PlmnIdType]string
+}
+
+// Authenticate asks the home network for a challenge for the UE
+func (c *Client) Authenticate(home nas.PlmnIdType, supi string, serving nas.PlmnIdType) (AuthenticateResponseMsg, error) {
+	req := AuthenticateRequestMsg{Supi: supi, ServingNetwork: serving}
+	var res AuthenticateResponseMsg
+	err := request(c, home, AuthenticateRequest, &req, AuthenticateResponse, &res)
+	if err != nil {
+		return res, err
+	}
+	return res, causeError(res.Cause)
+}
+
+// Confirm passes the response of the UE to the home network
+func (c *Client) Confirm(home nas.PlmnIdType, authCtxId string, resp []byte) (ConfirmResponseMsg, error) {
+	req := ConfirmRequestMsg{AuthCtxId: authCtxId, Res: resp}
+	var res ConfirmResponseMsg
+	err := request(c, home, ConfirmRequest, &req, ConfirmResponse, &res)
+	if err != nil {
+		return res, err
+	}
+	return res, causeError(res.Cause)
+}
+
+func request[Req any, Res any](c *Client, home nas.PlmnIdType, reqType MsgType, req *Req, resType MsgType, res *Res) error {
+	addr, ok := c.Peers[home]
+	if !ok {
+		return ErrNoRoute
+	}
+	conn, err := net.DialTimeout("tcp", addr, requestTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(requestTimeout))
+
+	err = send(conn, reqType, req)
+	if err != nil {
+		return err
+	}
+
+	buf, err := io.Recv(conn)
+	if err != nil {
+		return err
+	}
+	var hdr Header
+	err = parser.DecodeMsg(buf, &hdr)
+	if err != nil {
+		return err
+	}
+	if hdr.MessageType != resType {
+		return fmt.Errorf("SEPP answered with message type %d", hdr.MessageType)
+	}
+	return parser.DecodeMsg(hdr.Message, res)
+}
+
+func send[T any](conn net.Conn, msgType MsgType, msgPtr *T) error {
+	msg, err := parser.EncodeMsg(msgPtr)
+	if err != nil {
+		return err
+	}
+	buf, err := parser.EncodeMsg(&Header{MessageType: msgType, Message: msg})
+	if err != nil {
+		return err
+	}
+	return io.Send(conn, buf)
+}
+
+func causeError(cause CauseType) error {
+	switch cause {
+	case CauseNone:
+		return nil
+	case CauseRoamingNotAllowed:
+		return ErrRoamingNotAllowed
+	case CauseUnknownSubscriber:
+		return ErrUnknownSubscriber
+	}
+	return ErrAuthenticationFailed
+}
@@ -0,0 +1,209 @@
+package sepp
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+	"phreaking/internal/crypto"
+	"phreaking/internal/io"
+	"phreaking/internal/udm"
+	"phreaking/pkg/nas"
+	"phreaking/pkg/parser"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Time a serving network has to confirm an authentication
+const authTimeout = 30 * time.Second
+
+// Pending authentications kept at most
+const maxPending = 1024
+
+// Agreement of the home network with a visited PLMN
+type Agreement struct {
+	// A visited PLMN without agreement is denied as well
+	Allowed bool
+	// DNNs roamers may use, every subscribed DNN if empty
+	Dnns []string
+}
+
+// Home answers the serving networks of its roaming subscribers, standing in
+// for AUSF and UDM of the home network behind its SEPP
+type Home struct {
+	Logger     *zap.Logger
+	Plmn       nas.PlmnIdType
+	Udm        *udm.Udm
+	Agreements map[nas.PlmnIdType]Agreement
+
+	mu      sync.Mutex
+	pending map[string]authContext
+}
+
+type authContext struct {
+	supi    string
+	serving nas.PlmnIdType
+	xres    []byte
+	expires time.Time
+}
+
+func NewHome(logger *zap.Logger, plmn nas.PlmnIdType, u *udm.Udm, agreements map[nas.PlmnIdType]Agreement) *Home {
+	return &Home{Logger: logger, Plmn: plmn, Udm: u, Agreements: agreements, pending: make(map[string]authContext)}
+}
+
+// Serve answers one request per connection
+func (h *Home) Serve(l net.Listener) error {
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go h.handle(c)
+	}
+}
+
+func (h *Home) handle(c net.Conn) {
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(requestTimeout))
+
+	buf, err := io.Recv(c)
+	if err != nil {
+		return
+	}
+	var hdr Header
+	err = parser.DecodeMsg(buf, &hdr)
+	if err != nil {
+		h.Logger.Sugar().Warnf("SEPP cannot decode request from %s", c.RemoteAddr())
+		return
+	}
+
+	switch hdr.MessageType {
+	case AuthenticateRequest:
+		var req AuthenticateRequestMsg
+		if parser.DecodeMsg(hdr.Message, &req) != nil {
+			return
+		}
+		res := h.Authenticate(req)
+		send(c, AuthenticateResponse, &res)
+	case ConfirmRequest:
+		var req ConfirmRequestMsg
+		if parser.DecodeMsg(hdr.Message, &req) != nil {
+			return
+		}
+		res := h.Confirm(req)
+		send(c, ConfirmResponse, &res)
+	default:
+		h.Logger.Sugar().Warnf("SEPP request of unknown type %d", hdr.MessageType)
+	}
+}
+
+// Authenticate creates a challenge for a subscriber registering in the
+// serving network, if the roaming agreement allows it
+func (h *Home) Authenticate(req AuthenticateRequestMsg) AuthenticateResponseMsg {
+	log := h.Logger.Sugar()
+
+	if !supiOf(req.Supi, h.Plmn) {
+		log.Warnf("SEPP authentication of %s, not a subscriber of PLMN %s", req.Supi, h.Plmn)
+		return AuthenticateResponseMsg{Cause: CauseUnknownSubscriber}
+	}
+	if !h.Agreements[req.ServingNetwork].Allowed {
+		log.Infof("SEPP roaming of %s in PLMN %s not allowed", req.Supi, req.ServingNetwork)
+		return AuthenticateResponseMsg{Cause: CauseRoamingNotAllowed}
+	}
+
+	randToken := make([]byte, 32)
+	rand.Read(randToken)
+	authRand := make([]byte, 32)
+	rand.Read(authRand)
+	id := make([]byte, 16)
+	rand.Read(id)
+
+	xres := crypto.IA2(randToken)
+	ctx := authContext{supi: req.Supi, serving: req.ServingNetwork, xres: xres, expires: time.Now().Add(authTimeout)}
+	authCtxId := hex.EncodeToString(id)
+
+	h.mu.Lock()
+	h.expire()
+	if len(h.pending) == maxPending {
+		h.mu.Unlock()
+		return AuthenticateResponseMsg{Cause: CauseAuthenticationRejected}
+	}
+	h.pending[authCtxId] = ctx
+	h.mu.Unlock()
+
+	log.Infof("SEPP authentication of %s roaming in PLMN %s", req.Supi, req.ServingNetwork)
+	return AuthenticateResponseMsg{AuthCtxId: authCtxId, Rand: randToken, AuthRand: authRand, Auth: crypto.IA2(authRand),
+		HxRes: crypto.ComputeHash(xres)}
+}
+
+// Confirm checks the response of the UE and returns its subscription as
+// limited by the roaming agreement. Each challenge is confirmed once.
+func (h *Home) Confirm(req ConfirmRequestMsg) ConfirmResponseMsg {
+	h.mu.Lock()
+	h.expire()
+	ctx, ok := h.pending[req.AuthCtxId]
+	delete(h.pending, req.AuthCtxId)
+	h.mu.Unlock()
+
+	if !ok || crypto.ComputeHash(req.Res) != crypto.ComputeHash(ctx.xres) {
+		h.Logger.Sugar().Warnf("SEPP authentication confirmation rejected")
+		return ConfirmResponseMsg{Cause: CauseAuthenticationRejected}
+	}
+
+	agreement := h.Agreements[ctx.serving]
+	sub := restrict(h.Udm.Subscription(ctx.supi), agreement.Dnns)
+	// The LCS client key stays home, roaming UEs do not locate other UEs
+	sub.LcsClientKey = nil
+	h.Logger.Sugar().Infof("SEPP authenticated %s roaming in PLMN %s", ctx.supi, ctx.serving)
+	return ConfirmResponseMsg{Supi: ctx.supi, Subscription: sub}
+}
+
+// expire drops challenges not confirmed in time, mu is held
+func (h *Home) expire() {
+	now := time.Now()
+	for id, ctx := range h.pending {
+		if now.After(ctx.expires) {
+			delete(h.pending, id)
+		}
+	}
+}
+
+// restrict removes the DNNs not in the agreement, and slices left without
+// a DNN
+func restrict(sub udm.Subscription, dnns []string) udm.Subscription {
+	if len(dnns) == 0 {
+		return sub
+	}
+
+	var nssai []udm.SubscribedSNssai
+	for _, slice := range sub.Nssai {
+		var allowed []string
+		for _, dnn := range slice.Dnns {
+			if contains(dnns, dnn) {
+				allowed = append(allowed, dnn)
+			}
+		}
+		if len(allowed) > 0 {
+			slice.Dnns = allowed
+			nssai = append(nssai, slice)
+		}
+	}
+	sub.Nssai = nssai
+	return sub
+}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
+
+// supiOf reports whether the IMSI based SUPI belongs to the PLMN
+func supiOf(supi string, plmn nas.PlmnIdType) bool {
+	prefix := "imsi-" + plmn.String()
+	return len(supi) > len(prefix) && supi[:len(prefix)] == prefix
+}
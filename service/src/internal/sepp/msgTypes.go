@@ -0,0 +1,61 @@
+package sepp
+
+import (
+	"phreaking/internal/udm"
+	"phreaking/pkg/nas"
+)
+
+type MsgType uint8
+
+// Inter-PLMN messages, each request is answered on its own connection
+const (
+	AuthenticateRequest MsgType = iota
+	AuthenticateResponse
+	ConfirmRequest
+	ConfirmResponse
+)
+
+type Header struct {
+	MessageType MsgType
+	Message     []byte
+}
+
+type CauseType uint8
+
+const (
+	CauseNone CauseType = iota
+	// No agreement of the home network with the serving network
+	CauseRoamingNotAllowed
+	CauseAuthenticationRejected
+	CauseUnknownSubscriber
+)
+
+type AuthenticateRequestMsg struct {
+	Supi string
+	// PLMN the UE registers in
+	ServingNetwork nas.PlmnIdType
+}
+
+// AuthenticateResponseMsg carries the challenge for the UE. The serving
+// network checks the response of the UE against HxRes before confirming it.
+type AuthenticateResponseMsg struct {
+	Cause     CauseType
+	AuthCtxId string
+	Rand      []byte
+	AuthRand  []byte
+	Auth      []byte
+	HxRes     string
+}
+
+type ConfirmRequestMsg struct {
+	AuthCtxId string
+	Res       []byte
+}
+
+// ConfirmResponseMsg returns the subscription the serving network applies,
+// limited by the roaming agreement
+type ConfirmResponseMsg struct {
+	Cause        CauseType
+	Supi         string
+	Subscription udm.Subscription
+}
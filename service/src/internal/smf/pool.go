@@ -0,0 +1,73 @@
+package smf
+
+import (
+	"errors"
+	"net/netip"
+)
+
+var errPoolExhausted = errors.New("address pool exhausted")
+
+// Pools larger than this are only used partially
+const maxPoolSize = 1 << 20
+
+// pool hands out addresses (allocBits 32) or prefixes (allocBits 64) of a
+// configured prefix
+type pool struct {
+	prefix    netip.Prefix
+	allocBits int
+	first     uint64
+	size      uint64
+	next      uint64
+	used      map[netip.Addr]bool
+}
+
+func newPool(prefix netip.Prefix, allocBits int) (*pool, error) {
+	prefix = prefix.Masked()
+	if !prefix.IsValid() || prefix.Bits() > allocBits || allocBits > prefix.Addr().BitLen() {
+		return nil, errors.New("invalid address pool " + prefix.String())
+	}
+
+	p := &pool{prefix: prefix, allocBits: allocBits, used: make(map[netip.Addr]bool)}
+	p.size = maxPoolSize
+	if allocBits-prefix.Bits() < 20 {
+		p.size = 1 << (allocBits - prefix.Bits())
+	}
+
+	// IPv4 network and gateway address and broadcast are not handed out
+	if prefix.Addr().Is4() {
+		if p.size < 4 {
+			return nil, errors.New("address pool too small " + prefix.String())
+		}
+		p.first = 2
+		p.size -= 3
+	}
+	return p, nil
+}
+
+// addrAt returns the n-th address, counted in units of allocBits
+func (p *pool) addrAt(n uint64) netip.Addr {
+	b := p.prefix.Addr().AsSlice()
+	for k := p.allocBits/8 - 1; k >= 0 && n > 0; k-- {
+		sum := uint64(b[k]) + n&0xff
+		b[k] = byte(sum)
+		n = n>>8 + sum>>8
+	}
+	addr, _ := netip.AddrFromSlice(b)
+	return addr
+}
+
+func (p *pool) alloc() (netip.Addr, error) {
+	for i := uint64(0); i < p.size; i++ {
+		addr := p.addrAt(p.first + (p.next+i)%p.size)
+		if !p.used[addr] {
+			p.used[addr] = true
+			p.next = (p.next + i + 1) % p.size
+			return addr, nil
+		}
+	}
+	return netip.Addr{}, errPoolExhausted
+}
+
+func (p *pool) release(addr netip.Addr) {
+	delete(p.used, addr)
+}
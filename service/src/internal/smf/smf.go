@@ -0,0 +1,302 @@
+package smf
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"net/netip"
+	"phreaking/pkg/nas"
+	"phreaking/pkg/ngap"
+	"sync"
+)
+
+var (
+	ErrUnknownDnn        = errors.New("unknown DNN")
+	ErrUnknownPduSesType = errors.New("unknown PDU session type")
+	ErrNoIpv4            = errors.New("DNN has no IPv4 address pool")
+	ErrNoIpv6            = errors.New("DNN has no IPv6 address pool")
+	ErrUnsupported5Qi    = errors.New("unsupported 5QI")
+	ErrPduSesIdInUse     = errors.New("PDU session ID in use by the UE")
+	errNoPool            = errors.New("DNN has no address pool")
+)
+
+// Dnn configures a data network the SMF can establish sessions to
+type Dnn struct {
+	Name string
+	// Either pool may be left invalid
+	Ipv4Pool netip.Prefix
+	Ipv6Pool netip.Prefix
+	FiveQi   uint8
+	// Allocation and retention priority, 1 is highest
+	ArpPriority uint8
+	Ambr        nas.AmbrType
+}
+
+type dnnPools struct {
+	Dnn
+	ipv4 *pool
+	ipv6 *pool
+}
+
+type SmStateType string
+
+// session state of an SM context
+const (
+	// Waiting for the gNB to set up resources
+	SmActivePending SmStateType = "ACTIVE-PENDING"
+	SmActive        SmStateType = "ACTIVE"
+	SmReleased      SmStateType = "RELEASED"
+)
+
+type SmContext struct {
+	Supi       string
+	PduSesId   uint8
+	PduSesType nas.PduSesType
+	SNssai     nas.SNssaiType
+	Dnn        string
+	SscMode    uint8
+	PduAddress nas.PduAddressType
+	QosRules   []nas.QosRuleType
+	QosFlows   []ngap.QosFlowType
+	Ambr       nas.AmbrType
+	ULTunnel   ngap.TunnelInfoType
+	DLTunnel   ngap.TunnelInfoType
+	State      SmStateType
+
+	// NAS security algorithms of the UE, user data is protected end to end
+	// between the UE and the UPF
+	EaAlg uint8
+	IaAlg uint8
+
+	// UE context owning the session, several may share a SUPI
+	AmfUeNgapId ngap.AmfUeNgapIdType
+}
+
+type smKey struct {
+	amfUeNgapId ngap.AmfUeNgapIdType
+	pduSesId    uint8
+}
+
+type Smf struct {
+	// N3 endpoint of the UPF for uplink tunnels
+	UpfAddr    string
+	DefaultDnn string
+
+	mu       sync.Mutex
+	dnns     map[string]*dnnPools
+	contexts map[smKey]*SmContext
+	teids    map[uint32]*SmContext
+}
+
+func New(upfAddr string, dnns []Dnn) (*Smf, error) {
+	if len(dnns) == 0 {
+		return nil, errors.New("no DNN configured")
+	}
+
+	s := &Smf{UpfAddr: upfAddr, DefaultDnn: dnns[0].Name, dnns: make(map[string]*dnnPools),
+		contexts: make(map[smKey]*SmContext), teids: make(map[uint32]*SmContext)}
+
+	for _, dnn := range dnns {
+		d := &dnnPools{Dnn: dnn}
+		var err error
+		if dnn.Ipv4Pool.IsValid() {
+			d.ipv4, err = newPool(dnn.Ipv4Pool, 32)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if dnn.Ipv6Pool.IsValid() {
+			d.ipv6, err = newPool(dnn.Ipv6Pool, 64)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if d.ipv4 == nil && d.ipv6 == nil {
+			return nil, errNoPool
+		}
+		s.dnns[dnn.Name] = d
+	}
+	return s, nil
+}
+
+// CreateSmContext allocates addresses and QoS for a new PDU session of the
+// UE context. The PDU session ID must not be in use by the UE context.
+// Ethernet and unstructured sessions get no IP address.
+func (s *Smf) CreateSmContext(amfUeNgapId ngap.AmfUeNgapIdType, supi string, pduSesId uint8, pduSesType nas.PduSesType,
+	snssai nas.SNssaiType, dnn string, sscMode uint8) (*SmContext, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if pduSesType > nas.PduSesUnstructured {
+		return nil, ErrUnknownPduSesType
+	}
+
+	if dnn == "" {
+		dnn = s.DefaultDnn
+	}
+	d, ok := s.dnns[dnn]
+	if !ok {
+		return nil, ErrUnknownDnn
+	}
+
+	needIpv4 := pduSesType == nas.PduSesIpv4 || pduSesType == nas.PduSesIpv4v6
+	needIpv6 := pduSesType == nas.PduSesIpv6 || pduSesType == nas.PduSesIpv4v6
+	if needIpv4 && d.ipv4 == nil {
+		return nil, ErrNoIpv4
+	}
+	if needIpv6 && d.ipv6 == nil {
+		return nil, ErrNoIpv6
+	}
+
+	key := smKey{amfUeNgapId, pduSesId}
+	if _, ok := s.contexts[key]; ok {
+		return nil, ErrPduSesIdInUse
+	}
+
+	ctx := &SmContext{Supi: supi, PduSesId: pduSesId, PduSesType: pduSesType, SNssai: snssai, Dnn: dnn, SscMode: sscMode,
+		Ambr: d.Ambr, State: SmActivePending, AmfUeNgapId: amfUeNgapId}
+
+	var err error
+	if needIpv4 {
+		ctx.PduAddress.Ipv4, err = d.ipv4.alloc()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if needIpv6 {
+		var prefix netip.Addr
+		prefix, err = d.ipv6.alloc()
+		if err != nil {
+			s.releaseAddress(d, ctx.PduAddress)
+			return nil, err
+		}
+		ctx.PduAddress.Ipv6 = netip.PrefixFrom(prefix, 64)
+	}
+
+	// One default flow, matching all traffic
+	ctx.QosFlows = []ngap.QosFlowType{{Qfi: 1, FiveQi: d.FiveQi, ArpPriority: d.ArpPriority}}
+	ctx.QosRules = []nas.QosRuleType{{Id: 1, Qfi: 1, Precedence: 255, Default: true}}
+
+	ctx.ULTunnel = ngap.TunnelInfoType{Addr: s.UpfAddr, Teid: s.newTeid()}
+	s.teids[ctx.ULTunnel.Teid] = ctx
+	s.contexts[key] = ctx
+	return ctx, nil
+}
+
+// Standardized non-GBR 5QIs, guaranteed bit rate flows are not supported
+var nonGbr5Qis = map[uint8]bool{5: true, 6: true, 7: true, 8: true, 9: true, 69: true, 70: true, 79: true, 80: true}
+
+// ModifySmContext changes the 5QI of the default QoS flow and the session
+// AMBR, zero values are left unchanged. The AMBR cannot exceed the one of the
+// DNN.
+func (s *Smf) ModifySmContext(ctx *SmContext, fiveQi uint8, ambr nas.AmbrType) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if ctx.State == SmReleased {
+		return errors.New("SM context already released")
+	}
+	if fiveQi != 0 && !nonGbr5Qis[fiveQi] {
+		return ErrUnsupported5Qi
+	}
+
+	if fiveQi != 0 {
+		// Copied, snapshots of the context share the slice
+		flows := make([]ngap.QosFlowType, len(ctx.QosFlows))
+		copy(flows, ctx.QosFlows)
+		flows[0].FiveQi = fiveQi
+		ctx.QosFlows = flows
+	}
+
+	limit := s.dnns[ctx.Dnn].Ambr
+	if ambr.Uplink > limit.Uplink {
+		ambr.Uplink = limit.Uplink
+	}
+	if ambr.Downlink > limit.Downlink {
+		ambr.Downlink = limit.Downlink
+	}
+	if ambr.Uplink != 0 {
+		ctx.Ambr.Uplink = ambr.Uplink
+	}
+	if ambr.Downlink != 0 {
+		ctx.Ambr.Downlink = ambr.Downlink
+	}
+	return nil
+}
+
+// ActivateSmContext records the downlink tunnel once the gNB set up resources
+// and the algorithms protecting the user data of the UE
+func (s *Smf) ActivateSmContext(ctx *SmContext, dl ngap.TunnelInfoType, eaAlg, iaAlg uint8) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if ctx.State == SmReleased {
+		return errors.New("SM context already released")
+	}
+	ctx.DLTunnel = dl
+	ctx.EaAlg = eaAlg
+	ctx.IaAlg = iaAlg
+	ctx.State = SmActive
+	return nil
+}
+
+// Active reports whether the user plane of the session is set up
+func (s *Smf) Active(ctx *SmContext) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return ctx.State == SmActive
+}
+
+// ReleaseSmContext returns the addresses of the session to the pools
+func (s *Smf) ReleaseSmContext(ctx *SmContext) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.release(ctx)
+}
+
+// SmContextByTeid returns a snapshot of the session of an uplink tunnel
+func (s *Smf) SmContextByTeid(teid uint32) (SmContext, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ctx, ok := s.teids[teid]
+	if !ok {
+		return SmContext{}, false
+	}
+	return *ctx, true
+}
+
+func (s *Smf) release(ctx *SmContext) {
+	if ctx.State == SmReleased {
+		return
+	}
+	ctx.State = SmReleased
+
+	key := smKey{ctx.AmfUeNgapId, ctx.PduSesId}
+	if s.contexts[key] == ctx {
+		delete(s.contexts, key)
+	}
+	delete(s.teids, ctx.ULTunnel.Teid)
+	if d, ok := s.dnns[ctx.Dnn]; ok {
+		s.releaseAddress(d, ctx.PduAddress)
+	}
+}
+
+func (s *Smf) releaseAddress(d *dnnPools, addr nas.PduAddressType) {
+	if d.ipv4 != nil && addr.Ipv4.IsValid() {
+		d.ipv4.release(addr.Ipv4)
+	}
+	if d.ipv6 != nil && addr.Ipv6.IsValid() {
+		d.ipv6.release(addr.Ipv6.Addr())
+	}
+}
+
+func (s *Smf) newTeid() uint32 {
+	buf := make([]byte, 4)
+	for {
+		rand.Read(buf)
+		teid := binary.BigEndian.Uint32(buf)
+		if _, ok := s.teids[teid]; !ok && teid != 0 {
+			return teid
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package smsf
+
+import (
+	"errors"
+	"phreaking/pkg/ngap"
+	"regexp"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	ErrInvalidRecipient = errors.New("invalid recipient")
+	ErrTooLong          = errors.New("message too long")
+	ErrInboxFull        = errors.New("inbox of recipient full")
+	ErrStoreFull        = errors.New("message store full")
+)
+
+// Characters of a single short message
+const MaxLength = 160
+
+var supiFormat = regexp.MustCompile(`^imsi-[0-9]{15}$`)
+
+// Message is a short message waiting in the inbox of the recipient
+type Message struct {
+	Id        uint32
+	From      string
+	To        string
+	Text      string
+	Submitted time.Time
+	// Of the originator, for the status report
+	Reference    uint8
+	StatusReport bool
+
+	// UE contexts, several may share a SUPI. Only the context a message was
+	// delivered to acknowledges it, zero until delivered.
+	FromUe      ngap.AmfUeNgapIdType
+	DeliveredTo ngap.AmfUeNgapIdType
+	// Only ever delivered to the context in DeliveredTo, the message is not
+	// queued for the SUPI
+	Pinned bool
+}
+
+// Smsf stores short messages until the recipient acknowledges them
+type Smsf struct {
+	// Per recipient
+	MaxInbox int
+	// Of all inboxes together
+	MaxMessages int
+	// Messages not acknowledged by then are dropped
+	Ttl time.Duration
+
+	mu      sync.Mutex
+	nextId  uint32
+	count   int
+	inboxes map[string][]Message
+}
+
+func New(maxInbox, maxMessages int, ttl time.Duration) *Smsf {
+	return &Smsf{MaxInbox: maxInbox, MaxMessages: maxMessages, Ttl: ttl, inboxes: make(map[string][]Message)}
+}
+
+// Submit stores a message for the recipient. With a recipient context toUe
+// the message is pinned to that context, with the zero context it is queued
+// for any context of the SUPI.
+func (s *Smsf) Submit(fromUe ngap.AmfUeNgapIdType, from, to string, toUe ngap.AmfUeNgapIdType, text string,
+	reference uint8, statusReport bool) (Message, error) {
+	if !supiFormat.MatchString(to) {
+		return Message{}, ErrInvalidRecipient
+	}
+	if !utf8.ValidString(text) || utf8.RuneCountInString(text) > MaxLength {
+		return Message{}, ErrTooLong
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.inboxes[to]) >= s.MaxInbox {
+		return Message{}, ErrInboxFull
+	}
+	if s.count >= s.MaxMessages {
+		return Message{}, ErrStoreFull
+	}
+	s.nextId++
+	s.count++
+	msg := Message{Id: s.nextId, From: from, To: to, Text: text, Submitted: time.Now(), Reference: reference,
+		StatusReport: statusReport, FromUe: fromUe, DeliveredTo: toUe, Pinned: toUe != ngap.AmfUeNgapIdType{}}
+	s.inboxes[to] = append(s.inboxes[to], msg)
+	return msg, nil
+}
+
+// Pending delivers to the UE context the messages not yet acknowledged,
+// oldest first. Messages delivered to another context are left to it while
+// live reports it exists.
+func (s *Smsf) Pending(supi string, ue ngap.AmfUeNgapIdType, live func(ngap.AmfUeNgapIdType) bool) []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var pending []Message
+	inbox := s.inboxes[supi]
+	for i := range inbox {
+		if inbox[i].deliverable(ue, live) {
+			inbox[i].DeliveredTo = ue
+			pending = append(pending, inbox[i])
+		}
+	}
+	return pending
+}
+
+// Deliver delivers a new message to the UE context, false if it is gone or
+// delivered to another live context
+func (s *Smsf) Deliver(msg Message, ue ngap.AmfUeNgapIdType, live func(ngap.AmfUeNgapIdType) bool) (Message, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	inbox := s.inboxes[msg.To]
+	for i := range inbox {
+		if inbox[i].Id != msg.Id {
+			continue
+		}
+		if !inbox[i].deliverable(ue, live) {
+			return Message{}, false
+		}
+		inbox[i].DeliveredTo = ue
+		return inbox[i], true
+	}
+	return Message{}, false
+}
+
+// deliverable reports whether no other live context holds the message
+func (m *Message) deliverable(ue ngap.AmfUeNgapIdType, live func(ngap.AmfUeNgapIdType) bool) bool {
+	if m.Pinned {
+		return m.DeliveredTo == ue
+	}
+	return m.DeliveredTo == (ngap.AmfUeNgapIdType{}) || m.DeliveredTo == ue || !live(m.DeliveredTo)
+}
+
+// Delivered removes a message acknowledged by the UE context it was
+// delivered to from the inbox
+func (s *Smsf) Delivered(supi string, ue ngap.AmfUeNgapIdType, id uint32) (Message, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	inbox := s.inboxes[supi]
+	for i, msg := range inbox {
+		if msg.Id == id && msg.DeliveredTo == ue {
+			s.inboxes[supi] = append(inbox[:i:i], inbox[i+1:]...)
+			if len(s.inboxes[supi]) == 0 {
+				delete(s.inboxes, supi)
+			}
+			s.count--
+			return msg, true
+		}
+	}
+	return Message{}, false
+}
+
+// Expire drops the messages older than the TTL and those pinned to a context
+// live reports gone, returning how many were dropped
+func (s *Smsf) Expire(live func(ngap.AmfUeNgapIdType) bool) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cutoff := time.Now().Add(-s.Ttl)
+	dropped := 0
+	for supi, inbox := range s.inboxes {
+		var kept []Message
+		for _, msg := range inbox {
+			if msg.Submitted.After(cutoff) && (!msg.Pinned || live(msg.DeliveredTo)) {
+				kept = append(kept, msg)
+			}
+		}
+		dropped += len(inbox) - len(kept)
+		if len(kept) == 0 {
+			delete(s.inboxes, supi)
+		} else {
+			s.inboxes[supi] = kept
+		}
+	}
+	s.count -= dropped
+	return dropped
+}
@@ -0,0 +1,112 @@
+// Package track encodes location histories for GIS tools
+package track
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"phreaking/pkg/nas"
+	"time"
+)
+
+var sourceNames = map[nas.PositionSourceType]string{
+	nas.PositionSourceUnknown: "unknown",
+	nas.PositionSourceGnss:    "gnss",
+	nas.PositionSourceCell:    "cell",
+	nas.PositionSourceWlan:    "wlan",
+	nas.PositionSourceManual:  "manual",
+}
+
+type featureCollection struct {
+	Type     string    `json:"type"`
+	Name     string    `json:"name,omitempty"`
+	Features []feature `json:"features"`
+}
+
+type feature struct {
+	Type string `json:"type"`
+	// Null for records without position
+	Geometry   *point     `json:"geometry"`
+	Properties properties `json:"properties"`
+}
+
+type point struct {
+	Type string `json:"type"`
+	// Longitude, latitude, altitude
+	Coordinates [3]float64 `json:"coordinates"`
+}
+
+type properties struct {
+	Location    string     `json:"location"`
+	Received    time.Time  `json:"received"`
+	Time        *time.Time `json:"time,omitempty"`
+	Uncertainty float64    `json:"uncertainty,omitempty"`
+	Source      string     `json:"source,omitempty"`
+	Plmn        uint32     `json:"plmn,omitempty"`
+	Tac         uint32     `json:"tac,omitempty"`
+	CellId      uint64     `json:"cellId,omitempty"`
+}
+
+// GeoJSON encodes the records as a FeatureCollection of points in record
+// order, records without position get a null geometry
+func GeoJSON(name string, records []nas.LocationRecordType) ([]byte, error) {
+	fc := featureCollection{Type: "FeatureCollection", Name: name, Features: []feature{}}
+	for _, r := range records {
+		f := feature{Type: "Feature", Properties: properties{Location: r.Location, Received: r.Time}}
+		if p := r.Position; !p.IsZero() {
+			f.Geometry = &point{Type: "Point", Coordinates: [3]float64{p.Longitude, p.Latitude, p.Altitude}}
+			f.Properties.Time = &p.Time
+			f.Properties.Uncertainty = p.Uncertainty
+			f.Properties.Source = sourceNames[p.Source]
+			f.Properties.Plmn = p.Tai.Plmn
+			f.Properties.Tac = p.Tai.Tac
+			f.Properties.CellId = p.CellId
+		}
+		fc.Features = append(fc.Features, f)
+	}
+	out, err := json.MarshalIndent(fc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(out, '\n'), nil
+}
+
+type gpx struct {
+	XMLName xml.Name `xml:"http://www.topografix.com/GPX/1/1 gpx"`
+	Version string   `xml:"version,attr"`
+	Creator string   `xml:"creator,attr"`
+	Track   gpxTrack `xml:"trk"`
+}
+
+type gpxTrack struct {
+	Name    string     `xml:"name,omitempty"`
+	Segment []gpxPoint `xml:"trkseg>trkpt"`
+}
+
+type gpxPoint struct {
+	Lat  float64   `xml:"lat,attr"`
+	Lon  float64   `xml:"lon,attr"`
+	Ele  float64   `xml:"ele"`
+	Time time.Time `xml:"time"`
+	Desc string    `xml:"desc,omitempty"`
+	Src  string    `xml:"src,omitempty"`
+}
+
+// GPX encodes the records with a position as one track segment, records
+// without position are left out
+func GPX(name string, records []nas.LocationRecordType) ([]byte, error) {
+	doc := gpx{Version: "1.1", Creator: "phreaking", Track: gpxTrack{Name: name}}
+	for _, r := range records {
+		p := r.Position
+		if p.IsZero() {
+			continue
+		}
+		doc.Track.Segment = append(doc.Track.Segment, gpxPoint{Lat: p.Latitude, Lon: p.Longitude, Ele: p.Altitude,
+			Time: p.Time.UTC(), Desc: r.Location, Src: sourceNames[p.Source]})
+	}
+
+	out, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]byte(xml.Header), out...), '\n'), nil
+}
@@ -0,0 +1,95 @@
+package udm
+
+import (
+	"phreaking/pkg/nas"
+)
+
+// Subscription holds the subscribed data of a UE
+type Subscription struct {
+	AllowedPduSesTypes []nas.PduSesType
+	Nssai              []SubscribedSNssai
+	// SUPIs the subscriber may locate as LCS client
+	LcsTargets []string
+	// Key the LCS client proves its identity with, no other UE can be
+	// located without one
+	LcsClientKey []byte
+}
+
+// SubscribedSNssai is a slice the UE may use with the DNNs reachable in it,
+// the first DNN is the default of the slice
+type SubscribedSNssai struct {
+	SNssai nas.SNssaiType
+	// Used when the UE requests no slices
+	Default bool
+	Dnns    []string
+}
+
+// Udm serves subscription data, SUPIs without an entry get the default
+type Udm struct {
+	Default     Subscription
+	Subscribers map[string]Subscription
+}
+
+func (u *Udm) Subscription(supi string) Subscription {
+	if sub, ok := u.Subscribers[supi]; ok {
+		return sub
+	}
+	return u.Default
+}
+
+// Subscribed reports whether the SUPI has an entry of its own
+func (u *Udm) Subscribed(supi string) bool {
+	_, ok := u.Subscribers[supi]
+	return ok
+}
+
+func (s Subscription) AllowsPduSesType(t nas.PduSesType) bool {
+	for _, allowed := range s.AllowedPduSesTypes {
+		if allowed == t {
+			return true
+		}
+	}
+	return false
+}
+
+// Slice returns the subscribed slice matching the S-NSSAI
+func (s Subscription) Slice(snssai nas.SNssaiType) (SubscribedSNssai, bool) {
+	for _, slice := range s.Nssai {
+		if slice.SNssai == snssai {
+			return slice, true
+		}
+	}
+	return SubscribedSNssai{}, false
+}
+
+// DefaultNssai lists the default subscribed slices
+func (s Subscription) DefaultNssai() []nas.SNssaiType {
+	var nssai []nas.SNssaiType
+	for _, slice := range s.Nssai {
+		if slice.Default {
+			nssai = append(nssai, slice.SNssai)
+		}
+	}
+	return nssai
+}
+
+// AllowsDnn reports whether the DNN is reachable in the slice
+func (s SubscribedSNssai) AllowsDnn(dnn string) bool {
+	for _, d := range s.Dnns {
+		if d == dnn {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsLcsTarget reports whether the subscriber may locate the SUPI, once
+// it proved to be the LCS client
+func (s Subscription) AllowsLcsTarget(supi string) bool {
+	for _, target := range s.LcsTargets {
+		if target == supi {
+			return true
+		}
+	}
+	return false
+}
@@ -3,15 +3,18 @@ package ue
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"net"
 	"phreaking/internal/crypto"
 	"phreaking/internal/io"
 	"phreaking/pkg/nas"
 	"phreaking/pkg/parser"
+	"time"
 )
 
 var (
-	errDecode = errors.New("cannot decode message")
+	errDecode             = errors.New("cannot decode message")
+	errHandoverNotAllowed = errors.New("handover target not allowed")
 )
 
 func (u *UE) HandlePDURes(c net.Conn, msgbuf []byte) error {
@@ -26,6 +29,39 @@ func (u *UE) HandlePDURes(c net.Conn, msgbuf []byte) error {
 	return nil
 }
 
+// SendUserData requests the URL over the user plane, bypassing the AMF. The
+// gNB only tunnels the data bearer, the request inside is protected for the
+// UPF.
+func (u *UE) SendUserData(c net.Conn, url string) error {
+	pdu, err := nas.ProtectUserData(u.EaAlg, u.IaAlg, []byte(url))
+	if err != nil {
+		return err
+	}
+	data := nas.UserDataMsg{PduSesId: u.ActivePduId, Data: pdu}
+	msg, err := parser.EncodeMsg(&data)
+	if err != nil {
+		return err
+	}
+	return io.SendGmm(c, nas.GmmHeader{Security: false, MessageType: nas.UserData, Message: msg})
+}
+
+func (u *UE) HandleUserData(c net.Conn, msgbuf []byte) error {
+	var msg nas.UserDataMsg
+
+	err := parser.DecodeMsg(msgbuf, &msg)
+	if err != nil {
+		return errDecode
+	}
+
+	response, err := nas.UnprotectUserData(u.EaAlg, u.IaAlg, msg.Data)
+	if err != nil {
+		return err
+	}
+
+	u.Logger.Sugar().Debugf("user plane response len: %d", len(response))
+	return nil
+}
+
 func (u *UE) HandlePDUSessionEstAccept(c net.Conn, msgbuf []byte) error {
 	var msg nas.PDUSessionEstAcceptMsg
 
@@ -34,9 +70,21 @@ func (u *UE) HandlePDUSessionEstAccept(c net.Conn, msgbuf []byte) error {
 		return errDecode
 	}
 
-	u.ActivePduId = msg.PduSesId
+	u.Logger.Sugar().Debugf("PDU session %d to DNN %s, address %s %s", msg.PduSesId, msg.Dnn,
+		msg.PduAddress.Ipv4, msg.PduAddress.Ipv6)
 
-	pduReq := nas.PDUReqMsg{PduSesId: u.ActivePduId, Request: []byte("gopher://gopher.website.org/")}
+	sess := PduSession{Id: msg.PduSesId, Type: msg.PduSesType, SNssai: msg.SNssai, Dnn: msg.Dnn, Address: msg.PduAddress,
+		Ambr: msg.Ambr}
+	u.ctx.mu.Lock()
+	defer u.ctx.mu.Unlock()
+	u.ctx.sessions[msg.PduSesId] = sess
+	u.ctx.finishProcedure(msg.PduSesId, nil)
+	return nil
+}
+
+// SendPDUReq requests the URL over the initial session through the AMF
+func (u *UE) SendPDUReq(c net.Conn, url string) error {
+	pduReq := nas.PDUReqMsg{PduSesId: u.ActivePduId, Request: []byte(url)}
 
 	pduReqMsg, mac, err := nas.BuildMessage(u.EaAlg, u.IaAlg, &pduReq)
 	if err != nil {
@@ -47,6 +95,21 @@ func (u *UE) HandlePDUSessionEstAccept(c net.Conn, msgbuf []byte) error {
 	return io.SendGmm(c, gmm)
 }
 
+func (u *UE) HandlePDUSessionEstReject(c net.Conn, msgbuf []byte) error {
+	var msg nas.PDUSessionEstRejectMsg
+
+	err := parser.DecodeMsg(msgbuf, &msg)
+	if err != nil {
+		return errDecode
+	}
+
+	err = fmt.Errorf("PDU session %d rejected with 5GSM cause %d", msg.PduSesId, msg.Cause)
+	u.ctx.mu.Lock()
+	defer u.ctx.mu.Unlock()
+	u.ctx.finishProcedure(msg.PduSesId, err)
+	return err
+}
+
 func (u *UE) HandleNASSecurityModeCommand(c net.Conn, msgbuf []byte) error {
 	var msg nas.NASSecurityModeCommandMsg
 	err := parser.DecodeMsg(msgbuf, &msg)
@@ -56,12 +119,14 @@ func (u *UE) HandleNASSecurityModeCommand(c net.Conn, msgbuf []byte) error {
 
 	u.EaAlg = msg.EaAlg
 	u.IaAlg = msg.IaAlg
+	u.peiRequested = msg.ImeisvRequest
 
 	location, err := u.GetLocation()
 	if err != nil {
 		return err
 	}
-	loc := nas.LocationUpdateMsg{Location: location}
+	// Not acknowledged, the network answers the PDU session request next
+	loc := nas.LocationUpdateMsg{Location: location, Position: u.ctx.Position()}
 	locMsg, mac, err := nas.BuildMessage(u.EaAlg, u.IaAlg, &loc)
 	if err != nil {
 		return err
@@ -73,7 +138,7 @@ func (u *UE) HandleNASSecurityModeCommand(c net.Conn, msgbuf []byte) error {
 		return err
 	}
 
-	pduEstReq := nas.PDUSessionEstRequestMsg{PduSesId: 0, PduSesType: 0}
+	pduEstReq := nas.PDUSessionEstRequestMsg{PduSesId: 0, PduSesType: nas.PduSesIpv4, Emergency: u.Emergency}
 	pduEstReqMsg, mac, err := nas.BuildMessage(u.EaAlg, u.IaAlg, &pduEstReq)
 	if err != nil {
 		return err
@@ -94,6 +159,10 @@ func (u *UE) HandleNASAuthRequest(c net.Conn, msgbuf []byte) error {
 		return errors.New("cannot authenticate core")
 	}
 
+	// Registering anew, sessions of an earlier registration are gone
+	u.ClearContext()
+
+	u.authRand = msg.Rand
 	res := crypto.IA2(msg.Rand)
 	authRes := nas.NASAuthResponseMsg{Res: res}
 	authResMsg, mac, err := nas.BuildMessagePlain(&authRes)
@@ -104,3 +173,227 @@ func (u *UE) HandleNASAuthRequest(c net.Conn, msgbuf []byte) error {
 	gmm := nas.GmmHeader{Security: false, Mac: mac, MessageType: nas.NASAuthResponse, Message: authResMsg}
 	return io.SendGmm(c, gmm)
 }
+
+// HandleRRCHandoverCommand attaches the UE to the target gNB and returns the
+// connection to it
+func (u *UE) HandleRRCHandoverCommand(msgbuf []byte) (net.Conn, error) {
+	var msg nas.RRCHandoverCommandMsg
+	err := parser.DecodeMsg(msgbuf, &msg)
+	if err != nil {
+		return nil, errDecode
+	}
+
+	if !u.handoverAllowed(msg.TargetAddr) {
+		return nil, fmt.Errorf("%w: %s", errHandoverNotAllowed, msg.TargetAddr)
+	}
+	c, err := net.DialTimeout("tcp", msg.TargetAddr, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	u.Tai = msg.Tai
+
+	complete := nas.RRCHandoverCompleteMsg{Crnti: msg.Crnti}
+	completeMsg, err := parser.EncodeMsg(&complete)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	gmm := nas.GmmHeader{Security: false, MessageType: nas.RRCHandoverComplete, Message: completeMsg}
+	err = io.SendGmm(c, gmm)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// handoverAllowed reports whether the UE may be handed over to the gNB, it
+// only dials configured addresses
+func (u *UE) handoverAllowed(addr string) bool {
+	for _, gnb := range u.HandoverGnbs {
+		if gnb == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// SendSecurityModeComplete completes the security mode procedure once the
+// initial PDU exchange is done, the AMF answers with Registration Accept
+func (u *UE) SendSecurityModeComplete(c net.Conn) error {
+	smc := nas.NASSecurityModeCompleteMsg{MobileId: u.MobileId}
+	if u.peiRequested {
+		smc.Pei = "imeisv-" + u.Imeisv
+	}
+	smcMsg, mac, err := nas.BuildMessage(u.EaAlg, u.IaAlg, &smc)
+	if err != nil {
+		return err
+	}
+
+	gmm := nas.GmmHeader{Security: true, Mac: mac, MessageType: nas.NASSecurityModeComplete, Message: smcMsg}
+	return io.SendGmm(c, gmm)
+}
+
+func (u *UE) HandleRegAccept(c net.Conn, msgbuf []byte) error {
+	var msg nas.NASRegAcceptMsg
+	err := parser.DecodeMsg(msgbuf, &msg)
+	if err != nil {
+		return errDecode
+	}
+
+	u.Guti = msg.Guti
+	u.TaiList = msg.TaiList
+	u.AllowedNssai = msg.AllowedNssai
+	for _, rejected := range msg.RejectedNssai {
+		u.Logger.Sugar().Debugf("Slice %d/%d rejected (cause %d)", rejected.SNssai.Sst, rejected.SNssai.Sd, rejected.Cause)
+	}
+	if msg.EmergencyRegistered {
+		u.Logger.Sugar().Warnf("Registered for emergency services only")
+	}
+	u.SaveContext()
+
+	regComplete := nas.NASRegCompleteMsg{Guti: u.Guti}
+	regCompleteMsg, mac, err := nas.BuildMessage(u.EaAlg, u.IaAlg, &regComplete)
+	if err != nil {
+		return err
+	}
+
+	gmm := nas.GmmHeader{Security: true, Mac: mac, MessageType: nas.RegisterComplete, Message: regCompleteMsg}
+	return io.SendGmm(c, gmm)
+}
+
+// SendRegistrationUpdate asks for a new TAI list after leaving the
+// registration area, the UE is identified by its GUTI
+func (u *UE) SendRegistrationUpdate(c net.Conn) error {
+	req := nas.NASRegRequestMsg{RegistrationType: nas.RegMobilityUpdating, MobileId: u.MobileId, SecCap: u.SecCap,
+		Guti: u.Guti, RequestedNssai: u.AllowedNssai}
+	reqMsg, mac, err := nas.BuildMessage(u.EaAlg, u.IaAlg, &req)
+	if err != nil {
+		return err
+	}
+
+	gmm := nas.GmmHeader{Security: true, Mac: mac, MessageType: nas.NASRegRequest, Message: reqMsg}
+	return io.SendGmm(c, gmm)
+}
+
+// HandleRegUpdateAccept stores the new TAI list, the GUTI is unchanged
+func (u *UE) HandleRegUpdateAccept(c net.Conn, msgbuf []byte) error {
+	var msg nas.NASRegAcceptMsg
+	err := parser.DecodeMsg(msgbuf, &msg)
+	if err != nil {
+		return errDecode
+	}
+
+	if msg.Guti != u.Guti {
+		return errors.New("registration update changed GUTI")
+	}
+	u.TaiList = msg.TaiList
+	u.AllowedNssai = msg.AllowedNssai
+	u.SaveContext()
+	u.Logger.Sugar().Debugf("Registration updated, %d TAIs", len(u.TaiList))
+	return nil
+}
+
+// HandleConfigurationUpdateCommand applies the fields the network sent and
+// keeps them with the stored registration
+func (u *UE) HandleConfigurationUpdateCommand(c net.Conn, msgbuf []byte) error {
+	var msg nas.ConfigurationUpdateCommandMsg
+	err := parser.DecodeMsg(msgbuf, &msg)
+	if err != nil {
+		return errDecode
+	}
+
+	if msg.Guti != (nas.GutiType{}) {
+		u.Guti = msg.Guti
+	}
+	if msg.TaiList != nil {
+		u.TaiList = msg.TaiList
+	}
+	if msg.AllowedNssai != nil {
+		u.AllowedNssai = msg.AllowedNssai
+		for _, rejected := range msg.RejectedNssai {
+			u.Logger.Sugar().Debugf("Slice %d/%d rejected (cause %d)", rejected.SNssai.Sst, rejected.SNssai.Sd, rejected.Cause)
+		}
+	}
+	u.SaveContext()
+	u.ctx.setNetworkInfo(msg.NetworkName, msg.Nitz)
+
+	if msg.NetworkName.Full != "" {
+		u.Logger.Sugar().Infof("Network name: %s", msg.NetworkName.Full)
+	}
+	if now, ok := u.ctx.NetworkTime(); ok && msg.Nitz != nil {
+		u.Logger.Sugar().Infof("Network time: %s", now.Format(time.RFC3339))
+	}
+
+	if !msg.AckRequested {
+		return nil
+	}
+	complete := nas.ConfigurationUpdateCompleteMsg{Guti: u.Guti}
+	completeMsg, mac, err := nas.BuildMessage(u.EaAlg, u.IaAlg, &complete)
+	if err != nil {
+		return err
+	}
+
+	gmm := nas.GmmHeader{Security: true, Mac: mac, MessageType: nas.ConfigurationUpdateComplete, Message: completeMsg}
+	return io.SendGmm(c, gmm)
+}
+
+// HandleRRCPaging answers paging for the stored 5G-S-TMSI with a Service
+// Request, it reports false if the UE is not the one paged
+func (u *UE) HandleRRCPaging(c net.Conn, msgbuf []byte) (bool, error) {
+	var msg nas.RRCPagingMsg
+	err := parser.DecodeMsg(msgbuf, &msg)
+	if err != nil {
+		return false, errDecode
+	}
+
+	if !u.RestoreContext(msg.UePagingId) {
+		return false, nil
+	}
+
+	// Integrity protected only, the AMF needs the 5G-S-TMSI to find the context
+	req := nas.ServiceRequestMsg{STmsi: u.Guti.STmsi(), PduSesIds: u.ctx.sessionIds()}
+	reqMsg, mac, err := nas.BuildMessage(0, u.IaAlg, &req)
+	if err != nil {
+		return false, err
+	}
+
+	gmm := nas.GmmHeader{Security: true, Mac: mac, MessageType: nas.ServiceRequest, Message: reqMsg}
+	return true, io.SendGmm(c, gmm)
+}
+
+func (u *UE) HandleServiceAccept(c net.Conn, msgbuf []byte) error {
+	var msg nas.ServiceAcceptMsg
+	err := parser.DecodeMsg(msgbuf, &msg)
+	if err != nil {
+		return errDecode
+	}
+
+	u.ctx.keepSessions(msg.PduSesIds)
+	u.Logger.Sugar().Debugf("Service accepted, %d PDU sessions active", len(msg.PduSesIds))
+	return nil
+}
+
+func (u *UE) HandleServiceReject(c net.Conn, msgbuf []byte) error {
+	var msg nas.ServiceRejectMsg
+	err := parser.DecodeMsg(msgbuf, &msg)
+	if err != nil {
+		return errDecode
+	}
+
+	u.ClearContext()
+	return fmt.Errorf("service rejected with cause %d", msg.Cause)
+}
+
+func (u *UE) HandleRegistrationReject(c net.Conn, msgbuf []byte) error {
+	var msg nas.NASRegRejectMsg
+	err := parser.DecodeMsg(msgbuf, &msg)
+	if err != nil {
+		return errDecode
+	}
+
+	u.ClearContext()
+	u.ToState(Deregistered)
+	return fmt.Errorf("registration rejected with cause %d", msg.Cause)
+}
@@ -0,0 +1,241 @@
+package ue
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"phreaking/internal/crypto"
+	"phreaking/internal/io"
+	"phreaking/pkg/nas"
+	"phreaking/pkg/parser"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var (
+	ErrLocationDenied  = errors.New("location report not authorised")
+	ErrUnknownTarget   = errors.New("unknown target UE")
+	ErrInvalidQuery    = errors.New("invalid location query")
+	ErrInvalidPosition = errors.New("invalid position")
+)
+
+// Location history kept by the UE
+const maxHistory = 64
+
+// AddLocation records a location from the OS. A position, if given, is sent
+// with the next Location Update.
+func (ctx *Context) AddLocation(location string, p nas.PositionType) error {
+	record := nas.LocationRecordType{Location: location, Time: time.Now()}
+	if !p.IsZero() {
+		err := p.Validate()
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidPosition, err)
+		}
+		if p.Time.IsZero() {
+			p.Time = record.Time
+		}
+		record.Position = p
+	}
+
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	if !p.IsZero() {
+		ctx.position = p
+	}
+	ctx.history = append(ctx.history, record)
+	if len(ctx.history) > maxHistory {
+		ctx.history = ctx.history[len(ctx.history)-maxHistory:]
+	}
+
+	select {
+	case ctx.locationAdded <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// ReportLocations sends a Location Update every interval while the UE is
+// connected, and right away whenever a location is added. A zero interval
+// only reports new locations.
+func (ctx *Context) ReportLocations(logger *zap.Logger, interval time.Duration) {
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-tick:
+		case <-ctx.locationAdded:
+		}
+
+		unacked, err := ctx.sendLocationUpdate()
+		if unacked != 0 {
+			logger.Sugar().Warnf("Location update %d was not acknowledged", unacked)
+		}
+		if err != nil && !errors.Is(err, ErrNotConnected) {
+			logger.Sugar().Warnf("Cannot send location update: %v", err)
+		}
+	}
+}
+
+// sendLocationUpdate sends the last location, it returns the sequence
+// number of the previous update if that was never acknowledged
+func (ctx *Context) sendLocationUpdate() (uint32, error) {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+
+	if ctx.conn == nil {
+		return 0, ErrNotConnected
+	}
+	if len(ctx.history) == 0 {
+		return 0, nil
+	}
+
+	ctx.locationSeq++
+	if ctx.locationSeq == 0 {
+		ctx.locationSeq++
+	}
+	loc := nas.LocationUpdateMsg{Location: ctx.history[len(ctx.history)-1].Location, Position: ctx.position,
+		Seq: ctx.locationSeq}
+	msg, mac, err := nas.BuildMessage(ctx.eaAlg, ctx.iaAlg, &loc)
+	if err != nil {
+		return 0, err
+	}
+	gmm := nas.GmmHeader{Security: true, Mac: mac, MessageType: nas.LocationUpdate, Message: msg}
+	err = io.SendGmm(ctx.conn, gmm)
+	if err != nil {
+		return 0, err
+	}
+
+	unacked := ctx.unackedLocation
+	ctx.unackedLocation = loc.Seq
+	return unacked, nil
+}
+
+func (u *UE) HandleLocationUpdateAck(c net.Conn, msgbuf []byte) error {
+	var msg nas.LocationUpdateAckMsg
+
+	err := parser.DecodeMsg(msgbuf, &msg)
+	if err != nil {
+		return errDecode
+	}
+
+	u.ctx.mu.Lock()
+	defer u.ctx.mu.Unlock()
+	if msg.Seq == u.ctx.unackedLocation {
+		u.ctx.unackedLocation = 0
+	}
+	u.Logger.Sugar().Debugf("Location update %d acknowledged", msg.Seq)
+	return nil
+}
+
+// LocationHistory returns the locations recorded since the UE started
+// matching the query, oldest first
+func (ctx *Context) LocationHistory(query nas.LocationQueryType) []nas.LocationRecordType {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+
+	var res []nas.LocationRecordType
+	for _, r := range ctx.history {
+		if query.Matches(r) {
+			res = append(res, r)
+		}
+	}
+	return res
+}
+
+func (ctx *Context) Position() nas.PositionType {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	return ctx.position
+}
+
+// RequestLocationReport asks the network for the location history of the UE
+// itself or, as LCS client, of another UE. An empty SUPI means the UE itself.
+func (ctx *Context) RequestLocationReport(supi string, query nas.LocationQueryType) (nas.LocationReportResponseMsg, error) {
+	err := query.Validate()
+	if err != nil {
+		return nas.LocationReportResponseMsg{}, fmt.Errorf("%w: %v", ErrInvalidQuery, err)
+	}
+
+	ctx.mu.Lock()
+	if ctx.conn == nil {
+		ctx.mu.Unlock()
+		return nas.LocationReportResponseMsg{}, ErrNotConnected
+	}
+	if ctx.locationReport != nil {
+		ctx.mu.Unlock()
+		return nas.LocationReportResponseMsg{}, ErrBusy
+	}
+
+	req := nas.LocationReportRequestMsg{TargetSupi: supi, Query: query}
+	if supi != "" && ctx.lcsClientKey != nil {
+		req.ClientMac = crypto.LcsClientMac(ctx.lcsClientKey, ctx.authRand, supi)
+	}
+	msg, mac, err := nas.BuildMessage(ctx.eaAlg, ctx.iaAlg, &req)
+	if err != nil {
+		ctx.mu.Unlock()
+		return nas.LocationReportResponseMsg{}, err
+	}
+	gmm := nas.GmmHeader{Security: true, Mac: mac, MessageType: nas.LocationReportRequest, Message: msg}
+	err = io.SendGmm(ctx.conn, gmm)
+	if err != nil {
+		ctx.mu.Unlock()
+		return nas.LocationReportResponseMsg{}, err
+	}
+	done := make(chan nas.LocationReportResponseMsg, 1)
+	ctx.locationReport = done
+	ctx.mu.Unlock()
+
+	timer := time.NewTimer(procedureTimeout)
+	defer timer.Stop()
+
+	var res nas.LocationReportResponseMsg
+	select {
+	case res = <-done:
+	case <-timer.C:
+		err = errors.New("no answer for location report")
+	}
+
+	ctx.mu.Lock()
+	if ctx.locationReport == done {
+		ctx.locationReport = nil
+	}
+	ctx.mu.Unlock()
+
+	if err != nil {
+		return res, err
+	}
+	switch res.Cause {
+	case nas.LcsCauseNone:
+		return res, nil
+	case nas.LcsCauseUnauthorized:
+		return res, ErrLocationDenied
+	case nas.LcsCauseUnknownTarget:
+		return res, fmt.Errorf("%w: %s", ErrUnknownTarget, res.TargetSupi)
+	case nas.LcsCauseInvalidQuery:
+		return res, ErrInvalidQuery
+	}
+	return res, fmt.Errorf("location report failed with cause %d", res.Cause)
+}
+
+func (u *UE) HandleLocationReportResponse(c net.Conn, msgbuf []byte) error {
+	var msg nas.LocationReportResponseMsg
+
+	err := parser.DecodeMsg(msgbuf, &msg)
+	if err != nil {
+		return errDecode
+	}
+
+	u.ctx.mu.Lock()
+	defer u.ctx.mu.Unlock()
+	if u.ctx.locationReport != nil {
+		u.ctx.locationReport <- msg
+		u.ctx.locationReport = nil
+	}
+	return nil
+}
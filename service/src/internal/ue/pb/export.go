@@ -0,0 +1,54 @@
+package pb
+
+import (
+	"phreaking/internal/track"
+	"phreaking/pkg/nas"
+
+	"golang.org/x/net/context"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// ExportLocations encodes the location history of the UE store or of the
+// network for GIS tools
+func (s *Server) ExportLocations(ctx context.Context, req *ExportRequest) (*ExportResponse, error) {
+	query := nas.LocationQueryType{From: fromUnixMilli(req.From), To: fromUnixMilli(req.To)}
+	if err := query.Validate(); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	var name string
+	var records []nas.LocationRecordType
+	switch req.Store {
+	case LocationStore_STORE_UE:
+		if req.Supi != "" {
+			return nil, status.Error(codes.InvalidArgument, "UE store only holds the UE itself")
+		}
+		records = s.Ctx.LocationHistory(query)
+	case LocationStore_STORE_NETWORK:
+		res, err := s.Ctx.RequestLocationReport(req.Supi, query)
+		if err != nil {
+			return nil, locationError(err)
+		}
+		name, records = res.TargetSupi, res.Locations
+	default:
+		return nil, status.Error(codes.InvalidArgument, "unknown location store")
+	}
+
+	var res ExportResponse
+	var err error
+	switch req.Format {
+	case ExportFormat_GEOJSON:
+		res.ContentType = "application/geo+json"
+		res.Data, err = track.GeoJSON(name, records)
+	case ExportFormat_GPX:
+		res.ContentType = "application/gpx+xml"
+		res.Data, err = track.GPX(name, records)
+	default:
+		return nil, status.Error(codes.InvalidArgument, "unknown export format")
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &res, nil
+}
@@ -1,3 +1,3 @@
 protoc --go_out=../pb --go_opt=paths=source_relative \
     --go-grpc_out=../pb --go-grpc_opt=paths=source_relative \
-    location.proto
+    location.proto session.proto sms.proto
@@ -1,7 +1,11 @@
 package pb
 
 import (
+	"errors"
 	"os"
+	"phreaking/internal/ue"
+	"phreaking/pkg/nas"
+	"time"
 
 	"go.uber.org/zap"
 	"golang.org/x/net/context"
@@ -13,6 +17,7 @@ import (
 
 type Server struct {
 	UnimplementedLocationServer
+	Ctx *ue.Context
 }
 
 func (s *Server) UpdateLocation(ctx context.Context, loc *Loc) (*Response, error) {
@@ -20,6 +25,17 @@ func (s *Server) UpdateLocation(ctx context.Context, loc *Loc) (*Response, error
 	defer logger.Sync()
 	log := logger.Sugar()
 	log.Infof("GPS location update: %s", loc.Position)
+	var p nas.PositionType
+	if loc.Geo != nil {
+		if loc.Geo.Source < PositionSource_SOURCE_UNKNOWN || loc.Geo.Source > PositionSource_SOURCE_MANUAL {
+			return nil, status.Error(codes.InvalidArgument, "unknown position source")
+		}
+		p = fromGeoPosition(loc.Geo)
+	}
+	err := s.Ctx.AddLocation(loc.Position, p)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
 	file, err := os.OpenFile("/service/data/location.data", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
 		log.Error(err)
@@ -32,18 +48,92 @@ func (s *Server) UpdateLocation(ctx context.Context, loc *Loc) (*Response, error
 	return &Response{}, nil
 }
 
+// GetLocationReport returns the location history the network stores for the
+// UE or, if authorised as LCS client, for another UE
+func (s *Server) GetLocationReport(ctx context.Context, req *LocationReportRequest) (*LocationReport, error) {
+	query := nas.LocationQueryType{From: fromUnixMilli(req.From), To: fromUnixMilli(req.To)}
+	if req.Area != nil {
+		query.Area = nas.BoundingBoxType{MinLatitude: req.Area.MinLatitude, MinLongitude: req.Area.MinLongitude,
+			MaxLatitude: req.Area.MaxLatitude, MaxLongitude: req.Area.MaxLongitude}
+	}
+
+	res, err := s.Ctx.RequestLocationReport(req.Supi, query)
+	if err != nil {
+		return nil, locationError(err)
+	}
+
+	report := &LocationReport{Supi: res.TargetSupi}
+	for _, l := range res.Locations {
+		record := &LocationRecord{Position: l.Location, Timestamp: l.Time.UnixMilli()}
+		if !l.Position.IsZero() {
+			record.Geo = toGeoPosition(l.Position)
+		}
+		report.Locations = append(report.Locations, record)
+	}
+	return report, nil
+}
+
+func fromGeoPosition(g *GeoPosition) nas.PositionType {
+	return nas.PositionType{Latitude: g.Latitude, Longitude: g.Longitude, Altitude: g.Altitude,
+		Uncertainty: g.Uncertainty, Source: nas.PositionSourceType(g.Source), Time: fromUnixMilli(g.Timestamp)}
+}
+
+func toGeoPosition(p nas.PositionType) *GeoPosition {
+	return &GeoPosition{Latitude: p.Latitude, Longitude: p.Longitude, Altitude: p.Altitude,
+		Uncertainty: p.Uncertainty, Source: PositionSource(p.Source), Timestamp: p.Time.UnixMilli(),
+		Plmn: p.Tai.Plmn, Tac: p.Tai.Tac, CellId: p.CellId}
+}
+
+// fromUnixMilli maps zero to the zero time
+func fromUnixMilli(ms int64) time.Time {
+	if ms == 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(ms)
+}
+
+func locationError(err error) error {
+	switch {
+	case errors.Is(err, ue.ErrLocationDenied):
+		return status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, ue.ErrUnknownTarget):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, ue.ErrInvalidQuery):
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	return sessionError(err)
+}
+
 // auth middleware for each rpc request
 func AuthInterceptor(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	err := authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return handler(ctx, req) // go to function.
+}
+
+// auth middleware for each streaming rpc
+func StreamAuthInterceptor(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	err := authenticate(ss.Context())
+	if err != nil {
+		return err
+	}
+
+	return handler(srv, ss)
+}
+
+func authenticate(ctx context.Context) error {
 	meta, ok := metadata.FromIncomingContext(ctx)
 	if !ok {
-		return nil, status.Error(codes.Unauthenticated, "INTERNAL_SERVER_ERROR")
+		return status.Error(codes.Unauthenticated, "INTERNAL_SERVER_ERROR")
 	}
 	if len(meta["auth"]) != 1 {
-		return nil, status.Error(codes.Unauthenticated, "INTERNAL_SERVER_ERROR")
+		return status.Error(codes.Unauthenticated, "INTERNAL_SERVER_ERROR")
 	}
 	if meta.Get("auth")[0] != string(os.Getenv("PHREAKING_GRPC_PASS")) {
-		return nil, status.Error(codes.Unauthenticated, "WRONG SECRET")
+		return status.Error(codes.Unauthenticated, "WRONG SECRET")
 	}
-
-	return handler(ctx, req) // go to function.
+	return nil
 }
@@ -19,7 +19,9 @@ import (
 const _ = grpc.SupportPackageIsVersion7
 
 const (
-	Location_UpdateLocation_FullMethodName = "/Location/UpdateLocation"
+	Location_UpdateLocation_FullMethodName    = "/Location/UpdateLocation"
+	Location_GetLocationReport_FullMethodName = "/Location/GetLocationReport"
+	Location_ExportLocations_FullMethodName   = "/Location/ExportLocations"
 )
 
 // LocationClient is the client API for Location service.
@@ -27,6 +29,8 @@ const (
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type LocationClient interface {
 	UpdateLocation(ctx context.Context, in *Loc, opts ...grpc.CallOption) (*Response, error)
+	GetLocationReport(ctx context.Context, in *LocationReportRequest, opts ...grpc.CallOption) (*LocationReport, error)
+	ExportLocations(ctx context.Context, in *ExportRequest, opts ...grpc.CallOption) (*ExportResponse, error)
 }
 
 type locationClient struct {
@@ -46,11 +50,31 @@ func (c *locationClient) UpdateLocation(ctx context.Context, in *Loc, opts ...gr
 	return out, nil
 }
 
+func (c *locationClient) GetLocationReport(ctx context.Context, in *LocationReportRequest, opts ...grpc.CallOption) (*LocationReport, error) {
+	out := new(LocationReport)
+	err := c.cc.Invoke(ctx, Location_GetLocationReport_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *locationClient) ExportLocations(ctx context.Context, in *ExportRequest, opts ...grpc.CallOption) (*ExportResponse, error) {
+	out := new(ExportResponse)
+	err := c.cc.Invoke(ctx, Location_ExportLocations_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // LocationServer is the server API for Location service.
 // All implementations must embed UnimplementedLocationServer
 // for forward compatibility
 type LocationServer interface {
 	UpdateLocation(context.Context, *Loc) (*Response, error)
+	GetLocationReport(context.Context, *LocationReportRequest) (*LocationReport, error)
+	ExportLocations(context.Context, *ExportRequest) (*ExportResponse, error)
 	mustEmbedUnimplementedLocationServer()
 }
 
@@ -61,6 +85,12 @@ type UnimplementedLocationServer struct {
 func (UnimplementedLocationServer) UpdateLocation(context.Context, *Loc) (*Response, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method UpdateLocation not implemented")
 }
+func (UnimplementedLocationServer) GetLocationReport(context.Context, *LocationReportRequest) (*LocationReport, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetLocationReport not implemented")
+}
+func (UnimplementedLocationServer) ExportLocations(context.Context, *ExportRequest) (*ExportResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ExportLocations not implemented")
+}
 func (UnimplementedLocationServer) mustEmbedUnimplementedLocationServer() {}
 
 // UnsafeLocationServer may be embedded to opt out of forward compatibility for this service.
@@ -92,6 +122,42 @@ func _Location_UpdateLocation_Handler(srv interface{}, ctx context.Context, dec
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Location_GetLocationReport_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LocationReportRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LocationServer).GetLocationReport(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Location_GetLocationReport_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LocationServer).GetLocationReport(ctx, req.(*LocationReportRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Location_ExportLocations_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ExportRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LocationServer).ExportLocations(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Location_ExportLocations_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LocationServer).ExportLocations(ctx, req.(*ExportRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // Location_ServiceDesc is the grpc.ServiceDesc for Location service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -103,6 +169,14 @@ var Location_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "UpdateLocation",
 			Handler:    _Location_UpdateLocation_Handler,
 		},
+		{
+			MethodName: "GetLocationReport",
+			Handler:    _Location_GetLocationReport_Handler,
+		},
+		{
+			MethodName: "ExportLocations",
+			Handler:    _Location_ExportLocations_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "location.proto",
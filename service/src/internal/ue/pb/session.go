@@ -0,0 +1,89 @@
+package pb
+
+import (
+	"errors"
+	"phreaking/internal/ue"
+	"phreaking/pkg/nas"
+
+	"golang.org/x/net/context"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// SessionService opens and closes PDU sessions of the registered UE
+type SessionService struct {
+	UnimplementedSessionServer
+	Ctx *ue.Context
+}
+
+func (s *SessionService) OpenSession(ctx context.Context, req *OpenSessionRequest) (*PduSession, error) {
+	if req.Type > PduSessionType_UNSTRUCTURED {
+		return nil, status.Error(codes.InvalidArgument, "unknown PDU session type")
+	}
+	if req.Sst > 255 || req.Sd > 0xffffff {
+		return nil, status.Error(codes.InvalidArgument, "invalid S-NSSAI")
+	}
+	snssai := nas.SNssaiType{Sst: uint8(req.Sst), Sd: req.Sd}
+	sess, err := s.Ctx.OpenSession(nas.PduSesType(req.Type), snssai, req.Dnn)
+	if err != nil {
+		return nil, sessionError(err)
+	}
+	return toPduSession(sess), nil
+}
+
+func (s *SessionService) ModifySession(ctx context.Context, req *ModifySessionRequest) (*PduSession, error) {
+	if req.Id > 255 || req.FiveQi > 255 {
+		return nil, status.Error(codes.InvalidArgument, "value out of range")
+	}
+	ambr := nas.AmbrType{Uplink: req.AmbrUplink, Downlink: req.AmbrDownlink}
+	sess, err := s.Ctx.ModifySession(uint8(req.Id), uint8(req.FiveQi), ambr)
+	if err != nil {
+		return nil, sessionError(err)
+	}
+	return toPduSession(sess), nil
+}
+
+func (s *SessionService) CloseSession(ctx context.Context, req *CloseSessionRequest) (*CloseSessionResponse, error) {
+	if req.Id > 255 {
+		return nil, status.Error(codes.InvalidArgument, "value out of range")
+	}
+	err := s.Ctx.CloseSession(uint8(req.Id))
+	if err != nil {
+		return nil, sessionError(err)
+	}
+	return &CloseSessionResponse{}, nil
+}
+
+func (s *SessionService) ListSessions(ctx context.Context, req *ListSessionsRequest) (*SessionList, error) {
+	list := &SessionList{}
+	for _, sess := range s.Ctx.Sessions() {
+		list.Sessions = append(list.Sessions, toPduSession(sess))
+	}
+	return list, nil
+}
+
+func toPduSession(sess ue.PduSession) *PduSession {
+	res := &PduSession{Id: uint32(sess.Id), Type: PduSessionType(sess.Type), Dnn: sess.Dnn, FiveQi: uint32(sess.FiveQi),
+		AmbrUplink: sess.Ambr.Uplink, AmbrDownlink: sess.Ambr.Downlink, Sst: uint32(sess.SNssai.Sst), Sd: sess.SNssai.Sd}
+	if sess.Address.Ipv4.IsValid() {
+		res.Ipv4 = sess.Address.Ipv4.String()
+	}
+	if sess.Address.Ipv6.IsValid() {
+		res.Ipv6 = sess.Address.Ipv6.String()
+	}
+	return res
+}
+
+func sessionError(err error) error {
+	switch {
+	case errors.Is(err, ue.ErrNotConnected):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, ue.ErrUnknownSession):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, ue.ErrNoSessionId):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case errors.Is(err, ue.ErrBusy):
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	return status.Error(codes.Aborted, err.Error())
+}
@@ -0,0 +1,50 @@
+package pb
+
+import (
+	"errors"
+	"phreaking/internal/ue"
+	"phreaking/pkg/sms"
+
+	"golang.org/x/net/context"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// SmsService sends and receives short messages over NAS
+type SmsService struct {
+	UnimplementedSmsServer
+	Ctx *ue.Context
+}
+
+func (s *SmsService) SendSMS(ctx context.Context, req *SendSmsRequest) (*SendSmsResponse, error) {
+	ref, err := s.Ctx.SendSMS(req.Recipient, req.Text, req.StatusReport)
+	if err != nil {
+		if errors.Is(err, ue.ErrSmsRefused) {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		return nil, sessionError(err)
+	}
+	return &SendSmsResponse{Reference: uint32(ref)}, nil
+}
+
+func (s *SmsService) StreamSMS(req *StreamSmsRequest, stream Sms_StreamSMSServer) error {
+	events, cancel := s.Ctx.SubscribeSMS()
+	defer cancel()
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case e := <-events:
+			res := &SmsEvent{MessageId: e.MessageId, Reference: uint32(e.Reference), Peer: e.Peer, Text: e.Text,
+				Timestamp: e.Time.UnixMilli()}
+			if e.Type == sms.StatusReport {
+				res.Type = SmsEventType_SMS_STATUS_REPORT
+			}
+			err := stream.Send(res)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,458 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.30.0
+// 	protoc        v3.21.12
+// source: sms.proto
+
+package pb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type SmsEventType int32
+
+const (
+	SmsEventType_SMS_DELIVER       SmsEventType = 0
+	SmsEventType_SMS_STATUS_REPORT SmsEventType = 1
+)
+
+// Enum value maps for SmsEventType.
+var (
+	SmsEventType_name = map[int32]string{
+		0: "SMS_DELIVER",
+		1: "SMS_STATUS_REPORT",
+	}
+	SmsEventType_value = map[string]int32{
+		"SMS_DELIVER":       0,
+		"SMS_STATUS_REPORT": 1,
+	}
+)
+
+func (x SmsEventType) Enum() *SmsEventType {
+	p := new(SmsEventType)
+	*p = x
+	return p
+}
+
+func (x SmsEventType) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (SmsEventType) Descriptor() protoreflect.EnumDescriptor {
+	return file_sms_proto_enumTypes[0].Descriptor()
+}
+
+func (SmsEventType) Type() protoreflect.EnumType {
+	return &file_sms_proto_enumTypes[0]
+}
+
+func (x SmsEventType) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use SmsEventType.Descriptor instead.
+func (SmsEventType) EnumDescriptor() ([]byte, []int) {
+	return file_sms_proto_rawDescGZIP(), []int{0}
+}
+
+type SendSmsRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// SUPI of the recipient
+	Recipient string `protobuf:"bytes,1,opt,name=recipient,proto3" json:"recipient,omitempty"`
+	// At most 160 characters
+	Text         string `protobuf:"bytes,2,opt,name=text,proto3" json:"text,omitempty"`
+	StatusReport bool   `protobuf:"varint,3,opt,name=status_report,json=statusReport,proto3" json:"status_report,omitempty"`
+}
+
+func (x *SendSmsRequest) Reset() {
+	*x = SendSmsRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_sms_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *SendSmsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SendSmsRequest) ProtoMessage() {}
+
+func (x *SendSmsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_sms_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SendSmsRequest.ProtoReflect.Descriptor instead.
+func (*SendSmsRequest) Descriptor() ([]byte, []int) {
+	return file_sms_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *SendSmsRequest) GetRecipient() string {
+	if x != nil {
+		return x.Recipient
+	}
+	return ""
+}
+
+func (x *SendSmsRequest) GetText() string {
+	if x != nil {
+		return x.Text
+	}
+	return ""
+}
+
+func (x *SendSmsRequest) GetStatusReport() bool {
+	if x != nil {
+		return x.StatusReport
+	}
+	return false
+}
+
+type SendSmsResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// Matches the status report
+	Reference uint32 `protobuf:"varint,1,opt,name=reference,proto3" json:"reference,omitempty"`
+}
+
+func (x *SendSmsResponse) Reset() {
+	*x = SendSmsResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_sms_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *SendSmsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SendSmsResponse) ProtoMessage() {}
+
+func (x *SendSmsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_sms_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SendSmsResponse.ProtoReflect.Descriptor instead.
+func (*SendSmsResponse) Descriptor() ([]byte, []int) {
+	return file_sms_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *SendSmsResponse) GetReference() uint32 {
+	if x != nil {
+		return x.Reference
+	}
+	return 0
+}
+
+type StreamSmsRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+}
+
+func (x *StreamSmsRequest) Reset() {
+	*x = StreamSmsRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_sms_proto_msgTypes[2]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *StreamSmsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StreamSmsRequest) ProtoMessage() {}
+
+func (x *StreamSmsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_sms_proto_msgTypes[2]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StreamSmsRequest.ProtoReflect.Descriptor instead.
+func (*StreamSmsRequest) Descriptor() ([]byte, []int) {
+	return file_sms_proto_rawDescGZIP(), []int{2}
+}
+
+type SmsEvent struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Type SmsEventType `protobuf:"varint,1,opt,name=type,proto3,enum=SmsEventType" json:"type,omitempty"`
+	// Received messages
+	MessageId uint32 `protobuf:"varint,2,opt,name=message_id,json=messageId,proto3" json:"message_id,omitempty"`
+	// Status reports
+	Reference uint32 `protobuf:"varint,3,opt,name=reference,proto3" json:"reference,omitempty"`
+	// Originator of a message, recipient of a status report
+	Peer string `protobuf:"bytes,4,opt,name=peer,proto3" json:"peer,omitempty"`
+	Text string `protobuf:"bytes,5,opt,name=text,proto3" json:"text,omitempty"`
+	// Unix time in milliseconds the message was sent or delivered
+	Timestamp int64 `protobuf:"varint,6,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+}
+
+func (x *SmsEvent) Reset() {
+	*x = SmsEvent{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_sms_proto_msgTypes[3]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *SmsEvent) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SmsEvent) ProtoMessage() {}
+
+func (x *SmsEvent) ProtoReflect() protoreflect.Message {
+	mi := &file_sms_proto_msgTypes[3]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SmsEvent.ProtoReflect.Descriptor instead.
+func (*SmsEvent) Descriptor() ([]byte, []int) {
+	return file_sms_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *SmsEvent) GetType() SmsEventType {
+	if x != nil {
+		return x.Type
+	}
+	return SmsEventType_SMS_DELIVER
+}
+
+func (x *SmsEvent) GetMessageId() uint32 {
+	if x != nil {
+		return x.MessageId
+	}
+	return 0
+}
+
+func (x *SmsEvent) GetReference() uint32 {
+	if x != nil {
+		return x.Reference
+	}
+	return 0
+}
+
+func (x *SmsEvent) GetPeer() string {
+	if x != nil {
+		return x.Peer
+	}
+	return ""
+}
+
+func (x *SmsEvent) GetText() string {
+	if x != nil {
+		return x.Text
+	}
+	return ""
+}
+
+func (x *SmsEvent) GetTimestamp() int64 {
+	if x != nil {
+		return x.Timestamp
+	}
+	return 0
+}
+
+var File_sms_proto protoreflect.FileDescriptor
+
+var file_sms_proto_rawDesc = []byte{
+	0x0a, 0x09, 0x73, 0x6d, 0x73, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x22, 0x67, 0x0a, 0x0e, 0x53,
+	0x65, 0x6e, 0x64, 0x53, 0x6d, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x1c, 0x0a,
+	0x09, 0x72, 0x65, 0x63, 0x69, 0x70, 0x69, 0x65, 0x6e, 0x74, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x09, 0x72, 0x65, 0x63, 0x69, 0x70, 0x69, 0x65, 0x6e, 0x74, 0x12, 0x12, 0x0a, 0x04, 0x74,
+	0x65, 0x78, 0x74, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x74, 0x65, 0x78, 0x74, 0x12,
+	0x23, 0x0a, 0x0d, 0x73, 0x74, 0x61, 0x74, 0x75, 0x73, 0x5f, 0x72, 0x65, 0x70, 0x6f, 0x72, 0x74,
+	0x18, 0x03, 0x20, 0x01, 0x28, 0x08, 0x52, 0x0c, 0x73, 0x74, 0x61, 0x74, 0x75, 0x73, 0x52, 0x65,
+	0x70, 0x6f, 0x72, 0x74, 0x22, 0x2f, 0x0a, 0x0f, 0x53, 0x65, 0x6e, 0x64, 0x53, 0x6d, 0x73, 0x52,
+	0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x1c, 0x0a, 0x09, 0x72, 0x65, 0x66, 0x65, 0x72,
+	0x65, 0x6e, 0x63, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x09, 0x72, 0x65, 0x66, 0x65,
+	0x72, 0x65, 0x6e, 0x63, 0x65, 0x22, 0x12, 0x0a, 0x10, 0x53, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x53,
+	0x6d, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x22, 0xb0, 0x01, 0x0a, 0x08, 0x53, 0x6d,
+	0x73, 0x45, 0x76, 0x65, 0x6e, 0x74, 0x12, 0x21, 0x0a, 0x04, 0x74, 0x79, 0x70, 0x65, 0x18, 0x01,
+	0x20, 0x01, 0x28, 0x0e, 0x32, 0x0d, 0x2e, 0x53, 0x6d, 0x73, 0x45, 0x76, 0x65, 0x6e, 0x74, 0x54,
+	0x79, 0x70, 0x65, 0x52, 0x04, 0x74, 0x79, 0x70, 0x65, 0x12, 0x1d, 0x0a, 0x0a, 0x6d, 0x65, 0x73,
+	0x73, 0x61, 0x67, 0x65, 0x5f, 0x69, 0x64, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x09, 0x6d,
+	0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x49, 0x64, 0x12, 0x1c, 0x0a, 0x09, 0x72, 0x65, 0x66, 0x65,
+	0x72, 0x65, 0x6e, 0x63, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x09, 0x72, 0x65, 0x66,
+	0x65, 0x72, 0x65, 0x6e, 0x63, 0x65, 0x12, 0x12, 0x0a, 0x04, 0x70, 0x65, 0x65, 0x72, 0x18, 0x04,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x70, 0x65, 0x65, 0x72, 0x12, 0x12, 0x0a, 0x04, 0x74, 0x65,
+	0x78, 0x74, 0x18, 0x05, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x74, 0x65, 0x78, 0x74, 0x12, 0x1c,
+	0x0a, 0x09, 0x74, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x18, 0x06, 0x20, 0x01, 0x28,
+	0x03, 0x52, 0x09, 0x74, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x2a, 0x36, 0x0a, 0x0c,
+	0x53, 0x6d, 0x73, 0x45, 0x76, 0x65, 0x6e, 0x74, 0x54, 0x79, 0x70, 0x65, 0x12, 0x0f, 0x0a, 0x0b,
+	0x53, 0x4d, 0x53, 0x5f, 0x44, 0x45, 0x4c, 0x49, 0x56, 0x45, 0x52, 0x10, 0x00, 0x12, 0x15, 0x0a,
+	0x11, 0x53, 0x4d, 0x53, 0x5f, 0x53, 0x54, 0x41, 0x54, 0x55, 0x53, 0x5f, 0x52, 0x45, 0x50, 0x4f,
+	0x52, 0x54, 0x10, 0x01, 0x32, 0x60, 0x0a, 0x03, 0x53, 0x6d, 0x73, 0x12, 0x2c, 0x0a, 0x07, 0x53,
+	0x65, 0x6e, 0x64, 0x53, 0x4d, 0x53, 0x12, 0x0f, 0x2e, 0x53, 0x65, 0x6e, 0x64, 0x53, 0x6d, 0x73,
+	0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x10, 0x2e, 0x53, 0x65, 0x6e, 0x64, 0x53, 0x6d,
+	0x73, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x2b, 0x0a, 0x09, 0x53, 0x74, 0x72,
+	0x65, 0x61, 0x6d, 0x53, 0x4d, 0x53, 0x12, 0x11, 0x2e, 0x53, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x53,
+	0x6d, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x09, 0x2e, 0x53, 0x6d, 0x73, 0x45,
+	0x76, 0x65, 0x6e, 0x74, 0x30, 0x01, 0x42, 0x10, 0x5a, 0x0e, 0x69, 0x6e, 0x74, 0x65, 0x72, 0x6e,
+	0x61, 0x6c, 0x2f, 0x75, 0x65, 0x2f, 0x70, 0x62, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_sms_proto_rawDescOnce sync.Once
+	file_sms_proto_rawDescData = file_sms_proto_rawDesc
+)
+
+func file_sms_proto_rawDescGZIP() []byte {
+	file_sms_proto_rawDescOnce.Do(func() {
+		file_sms_proto_rawDescData = protoimpl.X.CompressGZIP(file_sms_proto_rawDescData)
+	})
+	return file_sms_proto_rawDescData
+}
+
+var file_sms_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_sms_proto_msgTypes = make([]protoimpl.MessageInfo, 4)
+var file_sms_proto_goTypes = []interface{}{
+	(SmsEventType)(0),        // 0: SmsEventType
+	(*SendSmsRequest)(nil),   // 1: SendSmsRequest
+	(*SendSmsResponse)(nil),  // 2: SendSmsResponse
+	(*StreamSmsRequest)(nil), // 3: StreamSmsRequest
+	(*SmsEvent)(nil),         // 4: SmsEvent
+}
+var file_sms_proto_depIdxs = []int32{
+	0, // 0: SmsEvent.type:type_name -> SmsEventType
+	1, // 1: Sms.SendSMS:input_type -> SendSmsRequest
+	3, // 2: Sms.StreamSMS:input_type -> StreamSmsRequest
+	2, // 3: Sms.SendSMS:output_type -> SendSmsResponse
+	4, // 4: Sms.StreamSMS:output_type -> SmsEvent
+	3, // [3:5] is the sub-list for method output_type
+	1, // [1:3] is the sub-list for method input_type
+	1, // [1:1] is the sub-list for extension type_name
+	1, // [1:1] is the sub-list for extension extendee
+	0, // [0:1] is the sub-list for field type_name
+}
+
+func init() { file_sms_proto_init() }
+func file_sms_proto_init() {
+	if File_sms_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_sms_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*SendSmsRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_sms_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*SendSmsResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_sms_proto_msgTypes[2].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*StreamSmsRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_sms_proto_msgTypes[3].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*SmsEvent); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_sms_proto_rawDesc,
+			NumEnums:      1,
+			NumMessages:   4,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_sms_proto_goTypes,
+		DependencyIndexes: file_sms_proto_depIdxs,
+		EnumInfos:         file_sms_proto_enumTypes,
+		MessageInfos:      file_sms_proto_msgTypes,
+	}.Build()
+	File_sms_proto = out.File
+	file_sms_proto_rawDesc = nil
+	file_sms_proto_goTypes = nil
+	file_sms_proto_depIdxs = nil
+}
@@ -0,0 +1,176 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             v3.21.12
+// source: sms.proto
+
+package pb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	Sms_SendSMS_FullMethodName   = "/Sms/SendSMS"
+	Sms_StreamSMS_FullMethodName = "/Sms/StreamSMS"
+)
+
+// SmsClient is the client API for Sms service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type SmsClient interface {
+	SendSMS(ctx context.Context, in *SendSmsRequest, opts ...grpc.CallOption) (*SendSmsResponse, error)
+	// Messages and status reports received while the stream is open
+	StreamSMS(ctx context.Context, in *StreamSmsRequest, opts ...grpc.CallOption) (Sms_StreamSMSClient, error)
+}
+
+type smsClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewSmsClient(cc grpc.ClientConnInterface) SmsClient {
+	return &smsClient{cc}
+}
+
+func (c *smsClient) SendSMS(ctx context.Context, in *SendSmsRequest, opts ...grpc.CallOption) (*SendSmsResponse, error) {
+	out := new(SendSmsResponse)
+	err := c.cc.Invoke(ctx, Sms_SendSMS_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *smsClient) StreamSMS(ctx context.Context, in *StreamSmsRequest, opts ...grpc.CallOption) (Sms_StreamSMSClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Sms_ServiceDesc.Streams[0], Sms_StreamSMS_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &smsStreamSMSClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Sms_StreamSMSClient interface {
+	Recv() (*SmsEvent, error)
+	grpc.ClientStream
+}
+
+type smsStreamSMSClient struct {
+	grpc.ClientStream
+}
+
+func (x *smsStreamSMSClient) Recv() (*SmsEvent, error) {
+	m := new(SmsEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// SmsServer is the server API for Sms service.
+// All implementations must embed UnimplementedSmsServer
+// for forward compatibility
+type SmsServer interface {
+	SendSMS(context.Context, *SendSmsRequest) (*SendSmsResponse, error)
+	// Messages and status reports received while the stream is open
+	StreamSMS(*StreamSmsRequest, Sms_StreamSMSServer) error
+	mustEmbedUnimplementedSmsServer()
+}
+
+// UnimplementedSmsServer must be embedded to have forward compatible implementations.
+type UnimplementedSmsServer struct {
+}
+
+func (UnimplementedSmsServer) SendSMS(context.Context, *SendSmsRequest) (*SendSmsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SendSMS not implemented")
+}
+func (UnimplementedSmsServer) StreamSMS(*StreamSmsRequest, Sms_StreamSMSServer) error {
+	return status.Errorf(codes.Unimplemented, "method StreamSMS not implemented")
+}
+func (UnimplementedSmsServer) mustEmbedUnimplementedSmsServer() {}
+
+// UnsafeSmsServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to SmsServer will
+// result in compilation errors.
+type UnsafeSmsServer interface {
+	mustEmbedUnimplementedSmsServer()
+}
+
+func RegisterSmsServer(s grpc.ServiceRegistrar, srv SmsServer) {
+	s.RegisterService(&Sms_ServiceDesc, srv)
+}
+
+func _Sms_SendSMS_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SendSmsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SmsServer).SendSMS(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Sms_SendSMS_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SmsServer).SendSMS(ctx, req.(*SendSmsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Sms_StreamSMS_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(StreamSmsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(SmsServer).StreamSMS(m, &smsStreamSMSServer{stream})
+}
+
+type Sms_StreamSMSServer interface {
+	Send(*SmsEvent) error
+	grpc.ServerStream
+}
+
+type smsStreamSMSServer struct {
+	grpc.ServerStream
+}
+
+func (x *smsStreamSMSServer) Send(m *SmsEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// Sms_ServiceDesc is the grpc.ServiceDesc for Sms service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Sms_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "Sms",
+	HandlerType: (*SmsServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "SendSMS",
+			Handler:    _Sms_SendSMS_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamSMS",
+			Handler:       _Sms_StreamSMS_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "sms.proto",
+}
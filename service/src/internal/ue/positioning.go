@@ -0,0 +1,67 @@
+package ue
+
+import (
+	"math/rand"
+	"net"
+	"phreaking/pkg/lpp"
+	"phreaking/pkg/nas"
+	"phreaking/pkg/parser"
+)
+
+// Positioning methods the UE can measure for
+var supportedMethods = []lpp.PositioningMethodType{lpp.MethodEcid}
+
+// handleLpp answers the LMF, the serving cell is measured with a simulated
+// signal strength
+func (u *UE) handleLpp(c net.Conn, container []byte) error {
+	var header lpp.LppHeader
+	err := parser.DecodeMsg(container, &header)
+	if err != nil {
+		return errDecode
+	}
+
+	switch header.MessageType {
+	case lpp.RequestCapabilities:
+		res := lpp.ProvideCapabilitiesMsg{Methods: supportedMethods}
+		return sendLpp(u, c, header.TransactionId, lpp.ProvideCapabilities, &res)
+	case lpp.RequestLocationInformation:
+		var req lpp.RequestLocationInformationMsg
+		err = parser.DecodeMsg(header.Message, &req)
+		if err != nil {
+			return errDecode
+		}
+		if req.Method != lpp.MethodEcid {
+			abort := lpp.AbortMsg{Cause: lpp.AbortMethodNotSupported}
+			return sendLpp(u, c, header.TransactionId, lpp.Abort, &abort)
+		}
+
+		// -60 to -110 dBm
+		rssi := int16(-60 - rand.Intn(51))
+		u.Logger.Sugar().Debugf("Serving cell measured at %d dBm", rssi)
+		res := lpp.ProvideLocationInformationMsg{Method: req.Method, Measurement: lpp.CellMeasurementType{Rssi: rssi}}
+		return sendLpp(u, c, header.TransactionId, lpp.ProvideLocationInformation, &res)
+	case lpp.Abort:
+		var abort lpp.AbortMsg
+		err = parser.DecodeMsg(header.Message, &abort)
+		if err != nil {
+			return errDecode
+		}
+		u.Logger.Sugar().Infof("Positioning aborted by the network (cause %d)", abort.Cause)
+		return nil
+	}
+	return errDecode
+}
+
+func sendLpp[T any](u *UE, c net.Conn, tid uint8, msgType lpp.LppMsgType, msgPtr *T) error {
+	msg, err := parser.EncodeMsg(msgPtr)
+	if err != nil {
+		return err
+	}
+	header := lpp.LppHeader{TransactionId: tid, MessageType: msgType, Message: msg}
+	container, err := parser.EncodeMsg(&header)
+	if err != nil {
+		return err
+	}
+
+	return u.SendULNASTransport(c, nas.PayloadLpp, 0, container)
+}
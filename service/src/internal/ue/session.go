@@ -0,0 +1,297 @@
+package ue
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"phreaking/internal/io"
+	"phreaking/pkg/nas"
+	"phreaking/pkg/parser"
+	"sort"
+	"time"
+)
+
+var (
+	ErrNotConnected   = errors.New("UE is not connected")
+	ErrUnknownSession = errors.New("unknown PDU session")
+	ErrNoSessionId    = errors.New("no free PDU session ID")
+	ErrBusy           = errors.New("procedure for PDU session ongoing")
+)
+
+// Network answer to UE requested session procedures
+const procedureTimeout = 5 * time.Second
+
+// PDU session IDs handed out for sessions requested over the API, the initial
+// session uses ID 0
+const (
+	minPduSesId = 1
+	maxPduSesId = 15
+)
+
+// PduSession is an established PDU session of the UE
+type PduSession struct {
+	Id      uint8
+	Type    nas.PduSesType
+	SNssai  nas.SNssaiType
+	Dnn     string
+	Address nas.PduAddressType
+	FiveQi  uint8
+	Ambr    nas.AmbrType
+}
+
+// Attach makes the connection of the registered UE available for session
+// procedures
+func (ctx *Context) Attach(c net.Conn) {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	ctx.conn = c
+}
+
+// Detach is called when the connection is closed, the UE is in CM-IDLE
+func (ctx *Context) Detach(c net.Conn) {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	if ctx.conn == c {
+		ctx.conn = nil
+	}
+}
+
+// Sessions lists the established PDU sessions ordered by ID
+func (ctx *Context) Sessions() []PduSession {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+
+	sessions := make([]PduSession, 0, len(ctx.sessions))
+	for _, s := range ctx.sessions {
+		sessions = append(sessions, s)
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].Id < sessions[j].Id })
+	return sessions
+}
+
+// OpenSession requests a new PDU session and waits for the network to accept
+// it. Without S-NSSAI or DNN the network picks the defaults.
+func (ctx *Context) OpenSession(pduSesType nas.PduSesType, snssai nas.SNssaiType, dnn string) (PduSession, error) {
+	ctx.mu.Lock()
+	id, err := ctx.freeSessionId()
+	if err != nil {
+		ctx.mu.Unlock()
+		return PduSession{}, err
+	}
+	req := nas.PDUSessionEstRequestMsg{PduSesId: id, PduSesType: pduSesType, Dnn: dnn, SNssai: snssai}
+	done, err := startProcedure(ctx, id, nas.PDUSessionEstRequest, &req)
+	ctx.mu.Unlock()
+	if err != nil {
+		return PduSession{}, err
+	}
+	return ctx.wait(id, done)
+}
+
+// ModifySession requests another 5QI or session AMBR, zero values are left
+// unchanged
+func (ctx *Context) ModifySession(id uint8, fiveQi uint8, ambr nas.AmbrType) (PduSession, error) {
+	ctx.mu.Lock()
+	if _, ok := ctx.sessions[id]; !ok {
+		ctx.mu.Unlock()
+		return PduSession{}, ErrUnknownSession
+	}
+	req := nas.PDUSessionModificationRequestMsg{PduSesId: id, FiveQi: fiveQi, Ambr: ambr}
+	done, err := startProcedure(ctx, id, nas.PDUSessionModificationRequest, &req)
+	ctx.mu.Unlock()
+	if err != nil {
+		return PduSession{}, err
+	}
+	return ctx.wait(id, done)
+}
+
+// CloseSession requests the release of a PDU session
+func (ctx *Context) CloseSession(id uint8) error {
+	ctx.mu.Lock()
+	if _, ok := ctx.sessions[id]; !ok {
+		ctx.mu.Unlock()
+		return ErrUnknownSession
+	}
+	req := nas.PDUSessionReleaseRequestMsg{PduSesId: id, Cause: nas.GsmCauseRegularDeactivation}
+	done, err := startProcedure(ctx, id, nas.PDUSessionReleaseRequest, &req)
+	ctx.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	_, err = ctx.wait(id, done)
+	return err
+}
+
+// startProcedure sends the request on the attached connection, ctx.mu is held
+func startProcedure[T any](ctx *Context, id uint8, msgType nas.NasMsgType, msgPtr *T) (chan error, error) {
+	if ctx.conn == nil {
+		return nil, ErrNotConnected
+	}
+	if _, ok := ctx.procedures[id]; ok {
+		return nil, ErrBusy
+	}
+
+	msg, mac, err := nas.BuildMessage(ctx.eaAlg, ctx.iaAlg, msgPtr)
+	if err != nil {
+		return nil, err
+	}
+	gmm := nas.GmmHeader{Security: true, Mac: mac, MessageType: msgType, Message: msg}
+	err = io.SendGmm(ctx.conn, gmm)
+	if err != nil {
+		return nil, err
+	}
+
+	done := make(chan error, 1)
+	ctx.procedures[id] = done
+	return done, nil
+}
+
+func (ctx *Context) wait(id uint8, done chan error) (PduSession, error) {
+	timer := time.NewTimer(procedureTimeout)
+	defer timer.Stop()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-timer.C:
+		err = fmt.Errorf("no answer for PDU session %d", id)
+	}
+
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	if ctx.procedures[id] == done {
+		delete(ctx.procedures, id)
+	}
+	return ctx.sessions[id], err
+}
+
+// finishProcedure reports the network answer to a waiting API call, ctx.mu
+// is held
+func (ctx *Context) finishProcedure(id uint8, err error) {
+	if done, ok := ctx.procedures[id]; ok {
+		done <- err
+		delete(ctx.procedures, id)
+	}
+}
+
+func (ctx *Context) freeSessionId() (uint8, error) {
+	for id := uint8(minPduSesId); id <= maxPduSesId; id++ {
+		_, used := ctx.sessions[id]
+		_, pending := ctx.procedures[id]
+		if !used && !pending {
+			return id, nil
+		}
+	}
+	return 0, ErrNoSessionId
+}
+
+func (ctx *Context) sessionIds() []uint8 {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+
+	ids := make([]uint8, 0, len(ctx.sessions))
+	for id := range ctx.sessions {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// keepSessions drops the sessions the network no longer has
+func (ctx *Context) keepSessions(ids []uint8) {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+
+	keep := make(map[uint8]bool)
+	for _, id := range ids {
+		keep[id] = true
+	}
+	for id := range ctx.sessions {
+		if !keep[id] {
+			delete(ctx.sessions, id)
+		}
+	}
+}
+
+func (u *UE) HandlePDUSessionModificationCommand(c net.Conn, msgbuf []byte) error {
+	var msg nas.PDUSessionModificationCommandMsg
+
+	err := parser.DecodeMsg(msgbuf, &msg)
+	if err != nil {
+		return errDecode
+	}
+
+	u.ctx.mu.Lock()
+	sess, ok := u.ctx.sessions[msg.PduSesId]
+	if ok {
+		sess.FiveQi = msg.FiveQi
+		sess.Ambr = msg.Ambr
+		u.ctx.sessions[msg.PduSesId] = sess
+	}
+	u.ctx.finishProcedure(msg.PduSesId, nil)
+	u.ctx.mu.Unlock()
+
+	u.Logger.Sugar().Debugf("PDU session %d modified, 5QI %d", msg.PduSesId, msg.FiveQi)
+
+	complete := nas.PDUSessionModificationCompleteMsg{PduSesId: msg.PduSesId}
+	completeMsg, mac, err := nas.BuildMessage(u.EaAlg, u.IaAlg, &complete)
+	if err != nil {
+		return err
+	}
+
+	gmm := nas.GmmHeader{Security: true, Mac: mac, MessageType: nas.PDUSessionModificationComplete, Message: completeMsg}
+	return io.SendGmm(c, gmm)
+}
+
+func (u *UE) HandlePDUSessionModificationReject(c net.Conn, msgbuf []byte) error {
+	var msg nas.PDUSessionModificationRejectMsg
+
+	err := parser.DecodeMsg(msgbuf, &msg)
+	if err != nil {
+		return errDecode
+	}
+
+	u.ctx.mu.Lock()
+	defer u.ctx.mu.Unlock()
+	u.ctx.finishProcedure(msg.PduSesId, fmt.Errorf("PDU session %d modification rejected with 5GSM cause %d", msg.PduSesId, msg.Cause))
+	return nil
+}
+
+// HandlePDUSessionReleaseCommand handles releases requested by the UE as well
+// as by the network
+func (u *UE) HandlePDUSessionReleaseCommand(c net.Conn, msgbuf []byte) error {
+	var msg nas.PDUSessionResourceReleaseCommandMsg
+
+	err := parser.DecodeMsg(msgbuf, &msg)
+	if err != nil {
+		return errDecode
+	}
+
+	u.ctx.mu.Lock()
+	delete(u.ctx.sessions, msg.PduSesId)
+	u.ctx.finishProcedure(msg.PduSesId, nil)
+	u.ctx.mu.Unlock()
+
+	u.Logger.Sugar().Debugf("PDU session %d released (cause %d)", msg.PduSesId, msg.Cause)
+
+	complete := nas.PDUSessionReleaseCompleteMsg{PduSesId: msg.PduSesId}
+	completeMsg, mac, err := nas.BuildMessage(u.EaAlg, u.IaAlg, &complete)
+	if err != nil {
+		return err
+	}
+
+	gmm := nas.GmmHeader{Security: true, Mac: mac, MessageType: nas.PDUSessionReleaseComplete, Message: completeMsg}
+	return io.SendGmm(c, gmm)
+}
+
+func (u *UE) HandlePDUSessionReleaseReject(c net.Conn, msgbuf []byte) error {
+	var msg nas.PDUSessionReleaseRejectMsg
+
+	err := parser.DecodeMsg(msgbuf, &msg)
+	if err != nil {
+		return errDecode
+	}
+
+	u.ctx.mu.Lock()
+	defer u.ctx.mu.Unlock()
+	u.ctx.finishProcedure(msg.PduSesId, fmt.Errorf("PDU session %d release rejected with 5GSM cause %d", msg.PduSesId, msg.Cause))
+	return nil
+}
@@ -0,0 +1,201 @@
+package ue
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"phreaking/pkg/nas"
+	"phreaking/pkg/parser"
+	"phreaking/pkg/sms"
+	"time"
+)
+
+var (
+	ErrSmsRefused  = errors.New("SMS refused by the network")
+	ErrNoReference = errors.New("no free SMS reference")
+)
+
+// Message IDs remembered to drop repeated deliveries
+const smsSeen = 64
+
+// Events queued per stream, a slow stream misses events
+const smsStreamBuffer = 16
+
+// SmsEvent is a received short message or a status report of a sent one
+type SmsEvent struct {
+	// sms.Deliver or sms.StatusReport
+	Type      sms.SmsMsgType
+	MessageId uint32
+	Reference uint8
+	// Originator of a message, recipient of a status report
+	Peer string
+	Text string
+	Time time.Time
+}
+
+type smsState struct {
+	nextRef uint8
+	// Submits waiting for the report, by reference
+	pending   map[uint8]chan sms.SubmitReportMsg
+	listeners map[chan SmsEvent]struct{}
+	seen      []uint32
+}
+
+func newSmsState() smsState {
+	return smsState{pending: make(map[uint8]chan sms.SubmitReportMsg), listeners: make(map[chan SmsEvent]struct{})}
+}
+
+// SendSMS submits a short message to the SMSF and waits for it to be
+// accepted, it returns the reference used in the status report
+func (ctx *Context) SendSMS(to, text string, statusReport bool) (uint8, error) {
+	ctx.mu.Lock()
+	ref, ok := ctx.freeSmsReference()
+	if !ok {
+		ctx.mu.Unlock()
+		return 0, ErrNoReference
+	}
+	submit := sms.SubmitMsg{Reference: ref, Recipient: to, Text: text, StatusReport: statusReport}
+	container, err := encodeSms(sms.Submit, &submit)
+	if err == nil {
+		err = ctx.sendULNASTransport(nas.PayloadSms, container)
+	}
+	if err != nil {
+		ctx.mu.Unlock()
+		return 0, err
+	}
+	done := make(chan sms.SubmitReportMsg, 1)
+	ctx.sms.pending[ref] = done
+	ctx.mu.Unlock()
+
+	timer := time.NewTimer(procedureTimeout)
+	defer timer.Stop()
+
+	var report sms.SubmitReportMsg
+	select {
+	case report = <-done:
+	case <-timer.C:
+		err = errors.New("no submit report for SMS")
+	}
+
+	ctx.mu.Lock()
+	if ctx.sms.pending[ref] == done {
+		delete(ctx.sms.pending, ref)
+	}
+	ctx.mu.Unlock()
+
+	if err != nil {
+		return ref, err
+	}
+	if report.Cause != sms.SmsCauseNone {
+		return ref, fmt.Errorf("%w (RP cause %d)", ErrSmsRefused, report.Cause)
+	}
+	return ref, nil
+}
+
+// SubscribeSMS returns received messages and status reports until cancelled
+func (ctx *Context) SubscribeSMS() (<-chan SmsEvent, func()) {
+	ch := make(chan SmsEvent, smsStreamBuffer)
+	ctx.mu.Lock()
+	ctx.sms.listeners[ch] = struct{}{}
+	ctx.mu.Unlock()
+
+	return ch, func() {
+		ctx.mu.Lock()
+		defer ctx.mu.Unlock()
+		delete(ctx.sms.listeners, ch)
+	}
+}
+
+func (ctx *Context) freeSmsReference() (uint8, bool) {
+	for i := 0; i < 256; i++ {
+		ctx.sms.nextRef++
+		if _, used := ctx.sms.pending[ctx.sms.nextRef]; !used {
+			return ctx.sms.nextRef, true
+		}
+	}
+	return 0, false
+}
+
+func encodeSms[T any](msgType sms.SmsMsgType, msgPtr *T) ([]byte, error) {
+	msg, err := parser.EncodeMsg(msgPtr)
+	if err != nil {
+		return nil, err
+	}
+	header := sms.SmsHeader{MessageType: msgType, Message: msg}
+	return parser.EncodeMsg(&header)
+}
+
+// publish hands an event to every stream, ctx.mu is held
+func (ctx *Context) publish(e SmsEvent) {
+	for ch := range ctx.sms.listeners {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+func (u *UE) handleSms(c net.Conn, container []byte) error {
+	var header sms.SmsHeader
+	err := parser.DecodeMsg(container, &header)
+	if err != nil {
+		return errDecode
+	}
+
+	u.ctx.mu.Lock()
+	defer u.ctx.mu.Unlock()
+
+	switch header.MessageType {
+	case sms.SubmitReport:
+		var msg sms.SubmitReportMsg
+		err = parser.DecodeMsg(header.Message, &msg)
+		if err != nil {
+			return errDecode
+		}
+		if done, ok := u.ctx.sms.pending[msg.Reference]; ok {
+			done <- msg
+			delete(u.ctx.sms.pending, msg.Reference)
+		}
+		return nil
+	case sms.Deliver:
+		var msg sms.DeliverMsg
+		err = parser.DecodeMsg(header.Message, &msg)
+		if err != nil {
+			return errDecode
+		}
+
+		// Acknowledged on the connection it came in, also when not attached
+		report := sms.DeliverReportMsg{MessageId: msg.MessageId}
+		container, err := encodeSms(sms.DeliverReport, &report)
+		if err != nil {
+			return err
+		}
+		err = u.SendULNASTransport(c, nas.PayloadSms, 0, container)
+		if err != nil {
+			return err
+		}
+
+		for _, id := range u.ctx.sms.seen {
+			if id == msg.MessageId {
+				return nil
+			}
+		}
+		u.ctx.sms.seen = append(u.ctx.sms.seen, msg.MessageId)
+		if len(u.ctx.sms.seen) > smsSeen {
+			u.ctx.sms.seen = u.ctx.sms.seen[1:]
+		}
+		u.Logger.Sugar().Infof("SMS %d from %s", msg.MessageId, msg.Originator)
+		u.ctx.publish(SmsEvent{Type: sms.Deliver, MessageId: msg.MessageId, Peer: msg.Originator, Text: msg.Text,
+			Time: msg.Time})
+		return nil
+	case sms.StatusReport:
+		var msg sms.StatusReportMsg
+		err = parser.DecodeMsg(header.Message, &msg)
+		if err != nil {
+			return errDecode
+		}
+		u.ctx.publish(SmsEvent{Type: sms.StatusReport, Reference: msg.Reference, Peer: msg.Recipient, Time: msg.Delivered})
+		return nil
+	}
+	return errDecode
+}
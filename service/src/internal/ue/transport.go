@@ -0,0 +1,61 @@
+package ue
+
+import (
+	"net"
+	"phreaking/internal/io"
+	"phreaking/pkg/nas"
+	"phreaking/pkg/parser"
+)
+
+// SendULNASTransport sends a payload to the network service of the container
+// type, the PDU session ID is only used for N1 SM information and CIoT data
+func (u *UE) SendULNASTransport(c net.Conn, containerType nas.PayloadContainerType, pduSesId uint8, container []byte) error {
+	ul := nas.ULNASTransportMsg{PayloadContainerType: containerType, PayloadContainer: container, PduSesId: pduSesId}
+	msg, mac, err := nas.BuildMessage(u.EaAlg, u.IaAlg, &ul)
+	if err != nil {
+		return err
+	}
+	gmm := nas.GmmHeader{Security: true, Mac: mac, MessageType: nas.ULNASTransport, Message: msg}
+	return io.SendGmm(c, gmm)
+}
+
+// sendULNASTransport sends a payload on the attached connection, ctx.mu is
+// held
+func (ctx *Context) sendULNASTransport(containerType nas.PayloadContainerType, container []byte) error {
+	if ctx.conn == nil {
+		return ErrNotConnected
+	}
+	ul := nas.ULNASTransportMsg{PayloadContainerType: containerType, PayloadContainer: container}
+	msg, mac, err := nas.BuildMessage(ctx.eaAlg, ctx.iaAlg, &ul)
+	if err != nil {
+		return err
+	}
+	gmm := nas.GmmHeader{Security: true, Mac: mac, MessageType: nas.ULNASTransport, Message: msg}
+	return io.SendGmm(ctx.conn, gmm)
+}
+
+func (u *UE) HandleDLNASTransport(c net.Conn, msgbuf []byte) error {
+	var msg nas.DLNASTransportMsg
+
+	err := parser.DecodeMsg(msgbuf, &msg)
+	if err != nil {
+		return errDecode
+	}
+
+	if msg.Cause != 0 {
+		u.Logger.Sugar().Warnf("Payload type %d not forwarded by the network (5GMM cause %d)", msg.PayloadContainerType, msg.Cause)
+		return nil
+	}
+
+	switch msg.PayloadContainerType {
+	case nas.PayloadLpp:
+		return u.handleLpp(c, msg.PayloadContainer)
+	case nas.PayloadSms:
+		return u.handleSms(c, msg.PayloadContainer)
+	case nas.PayloadCIoTUserData:
+		u.Logger.Sugar().Infof("CIoT user data for PDU session %d: %d bytes", msg.PduSesId, len(msg.PayloadContainer))
+		return nil
+	}
+	u.Logger.Sugar().Warnf("DL NAS Transport with unsupported payload container type %d", msg.PayloadContainerType)
+	return nil
+}
@@ -2,24 +2,165 @@ package ue
 
 import (
 	"bufio"
+	"net"
 	"os"
 	"phreaking/pkg/nas"
+	"sync"
+	"time"
 
 	"go.uber.org/zap"
 )
 
 type UE struct {
-	Logger *zap.Logger
-	state  StateType
-	//MobileId ngap.MobileIdType
-	SecCap      nas.SecCapType
-	EaAlg       uint8
-	IaAlg       uint8
+	Logger   *zap.Logger
+	state    StateType
+	ctx      *Context
+	MobileId nas.MobileIdType
+	SecCap   nas.SecCapType
+	EaAlg    uint8
+	IaAlg    uint8
+	// Session of the UE's own traffic, the one established on registration
 	ActivePduId uint8
+	Guti        nas.GutiType
+	TaiList     []nas.TaiType
+	// Tracking area of the serving cell, zero if not known
+	Tai nas.TaiType
+	// Slices new PDU sessions can use
+	AllowedNssai []nas.SNssaiType
+	// Registering for emergency services, the first session is an emergency session
+	Emergency bool
+	// PLMN of the subscriber, the UE is roaming in any other
+	HomePlmn nas.PlmnIdType
+	// MSIN of the subscriber, the SUPI is the home PLMN followed by it
+	Msin uint
+	// 16 digit IMEISV of the equipment
+	Imeisv string
+	// Addresses of the gNBs the UE may be handed over to
+	HandoverGnbs []string
+	// The network asked for the PEI in Security Mode Command
+	peiRequested bool
+	// RAND of the last authentication
+	authRand []byte
 }
 
-func NewUE(logger *zap.Logger) *UE {
-	return &UE{Logger: logger, state: Deregistered}
+// Context is the registration state kept across gNB connections, used to
+// answer paging with a Service Request while in CM-IDLE
+type Context struct {
+	mu         sync.Mutex
+	registered bool
+	guti       nas.GutiType
+	eaAlg      uint8
+	iaAlg      uint8
+	taiList    []nas.TaiType
+	nssai      []nas.SNssaiType
+	sessions   map[uint8]PduSession
+	// Connection of the registered UE, nil in CM-IDLE
+	conn net.Conn
+	// UE requested session procedures waiting for the network
+	procedures map[uint8]chan error
+	// Location report request waiting for the network
+	locationReport chan nas.LocationReportResponseMsg
+	// Last position from the OS, zero if not known
+	position nas.PositionType
+	history  []nas.LocationRecordType
+	// Signals the location reporter
+	locationAdded chan struct{}
+	locationSeq   uint32
+	// Sequence number of the last Location Update until acknowledged
+	unackedLocation uint32
+	sms             smsState
+	// Set by Configuration Update Command
+	networkName nas.NetworkNameType
+	timeZone    *time.Location
+	// Network time less local time
+	clockOffset time.Duration
+	// LCS client key of the subscriber, nil if it is no LCS client
+	lcsClientKey []byte
+	authRand     []byte
+}
+
+func NewContext(lcsClientKey []byte) *Context {
+	return &Context{sessions: make(map[uint8]PduSession), procedures: make(map[uint8]chan error),
+		locationAdded: make(chan struct{}, 1), sms: newSmsState(), lcsClientKey: lcsClientKey}
+}
+
+func NewUE(logger *zap.Logger, ctx *Context) *UE {
+	return &UE{Logger: logger, state: Deregistered, ctx: ctx}
+}
+
+// SaveContext stores the current registration for later Service Requests
+func (u *UE) SaveContext() {
+	u.ctx.mu.Lock()
+	defer u.ctx.mu.Unlock()
+	u.ctx.registered = true
+	u.ctx.guti = u.Guti
+	u.ctx.eaAlg = u.EaAlg
+	u.ctx.iaAlg = u.IaAlg
+	u.ctx.taiList = u.TaiList
+	u.ctx.nssai = u.AllowedNssai
+	u.ctx.authRand = u.authRand
+}
+
+// RestoreContext loads the stored registration if it matches the paging identity
+func (u *UE) RestoreContext(stmsi uint64) bool {
+	u.ctx.mu.Lock()
+	defer u.ctx.mu.Unlock()
+	if !u.ctx.registered || u.ctx.guti.STmsi() != stmsi {
+		return false
+	}
+	u.Guti = u.ctx.guti
+	u.EaAlg = u.ctx.eaAlg
+	u.IaAlg = u.ctx.iaAlg
+	u.TaiList = u.ctx.taiList
+	u.AllowedNssai = u.ctx.nssai
+	u.authRand = u.ctx.authRand
+	return true
+}
+
+// setNetworkInfo stores the network name and time zone, and the offset of
+// the local clock from network time
+func (ctx *Context) setNetworkInfo(name nas.NetworkNameType, nitz *nas.NitzType) {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	if name != (nas.NetworkNameType{}) {
+		ctx.networkName = name
+	}
+	if nitz != nil {
+		ctx.timeZone = time.FixedZone("", int(nitz.TimeZone))
+		ctx.clockOffset = time.Until(nitz.UniversalTime)
+	}
+}
+
+// NetworkTime returns the local time of the network, false if the network
+// has not sent it
+func (ctx *Context) NetworkTime() (time.Time, bool) {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	if ctx.timeZone == nil {
+		return time.Time{}, false
+	}
+	return time.Now().Add(ctx.clockOffset).In(ctx.timeZone), true
+}
+
+func (u *UE) ClearContext() {
+	u.ctx.mu.Lock()
+	defer u.ctx.mu.Unlock()
+	u.ctx.registered = false
+	u.ctx.sessions = make(map[uint8]PduSession)
+	u.ctx.conn = nil
+}
+
+// LeftRegistrationArea reports whether the serving cell is outside the TAI list
+func (u *UE) LeftRegistrationArea() bool {
+	if u.Tai == (nas.TaiType{}) {
+		return false
+	}
+	for _, tai := range u.TaiList {
+		if tai == u.Tai {
+			return false
+		}
+	}
+	return true
 }
 
 func (u *UE) GetState(s StateType) StateType {
@@ -62,4 +203,5 @@ const (
 	SecurityMode          StateType = "SecurityMode"
 	ContextSetup          StateType = "ContextSetup"
 	Registered            StateType = "Registered"
+	ServiceRequested      StateType = "ServiceRequested"
 )
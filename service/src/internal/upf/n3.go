@@ -0,0 +1,76 @@
+package upf
+
+import (
+	"net"
+	"phreaking/internal/smf"
+	"phreaking/pkg/gtpu"
+	"phreaking/pkg/nas"
+)
+
+// ServeN3 terminates the GTP-U tunnels of the sessions managed by sm
+func (u *Upf) ServeN3(addr string, sm *smf.Smf) error {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return err
+	}
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	buf := make([]byte, 65535)
+	for {
+		n, peer, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return err
+		}
+
+		msgType, teid, payload, err := gtpu.Unmarshal(buf[:n])
+		if err != nil {
+			continue
+		}
+
+		switch msgType {
+		case gtpu.EchoRequest:
+			conn.WriteToUDP(gtpu.Marshal(gtpu.EchoResponse, 0, nil), peer)
+		case gtpu.GPDU:
+			ctx, ok := sm.SmContextByTeid(teid)
+			if !ok || ctx.State != smf.SmActive {
+				u.Logger.Sugar().Infof("GTP-U packet for unknown TEID %d from %s", teid, peer)
+				ind := gtpu.Marshal(gtpu.ErrorIndication, 0, gtpu.ErrorIndicationPayload(teid))
+				conn.WriteToUDP(ind, peer)
+				continue
+			}
+			pdu := make([]byte, len(payload))
+			copy(pdu, payload)
+			go u.forwardGPDU(conn, ctx, pdu)
+		}
+	}
+}
+
+// forwardGPDU sends the response of the data network down the session tunnel.
+// G-PDUs carry user data protected by the UE, anything failing the checks of
+// the UE's security context is dropped.
+func (u *Upf) forwardGPDU(conn *net.UDPConn, ctx smf.SmContext, pdu []byte) {
+	request, err := nas.UnprotectUserData(ctx.EaAlg, ctx.IaAlg, pdu)
+	if err != nil {
+		u.Logger.Sugar().Infof("G-PDU for TEID %d discarded: %v", ctx.ULTunnel.Teid, err)
+		return
+	}
+
+	response, err := u.Handle(ctx.PduSesType, request)
+	if err != nil {
+		response = []byte("error: " + err.Error())
+	}
+	pdu, err = nas.ProtectUserData(ctx.EaAlg, ctx.IaAlg, response)
+	if err != nil {
+		return
+	}
+
+	dl, err := net.ResolveUDPAddr("udp", ctx.DLTunnel.Addr)
+	if err != nil {
+		return
+	}
+	conn.WriteToUDP(gtpu.Marshal(gtpu.GPDU, ctx.DLTunnel.Teid, pdu), dl)
+}
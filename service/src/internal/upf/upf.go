@@ -0,0 +1,151 @@
+package upf
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"phreaking/pkg/nas"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var errNotAllowed = errors.New("destination not in data network")
+
+// Upf forwards PDU session traffic to the local data network. Only hosts
+// configured for the data network are reachable, nothing is resolved on
+// behalf of the UE.
+type Upf struct {
+	Logger *zap.Logger
+	// Reachable host:port mapped to the address dialled on N6
+	Hosts   map[string]string
+	Timeout time.Duration
+	// Longer responses are truncated
+	MaxResponseSize int64
+}
+
+var defaultPorts = map[string]string{"http": "80", "gopher": "70"}
+
+// Handle serves data of a PDU session according to the session type
+func (u *Upf) Handle(pduSesType nas.PduSesType, data []byte) ([]byte, error) {
+	switch pduSesType {
+	case nas.PduSesIpv4, nas.PduSesIpv6, nas.PduSesIpv4v6:
+		return u.Forward(data)
+	case nas.PduSesEthernet:
+		return reflectFrame(data)
+	case nas.PduSesUnstructured:
+		// Echo service for testing
+		return data, nil
+	}
+	return nil, errors.New("unknown PDU session type")
+}
+
+// reflectFrame sends an Ethernet frame back to its source, the data network
+// is a loopback segment
+func reflectFrame(frame []byte) ([]byte, error) {
+	if len(frame) < 14 {
+		return nil, errors.New("Ethernet frame too short")
+	}
+	reflected := make([]byte, len(frame))
+	copy(reflected, frame)
+	copy(reflected[0:6], frame[6:12])
+	copy(reflected[6:12], frame[0:6])
+	return reflected, nil
+}
+
+// Forward fetches an http or gopher URL from the data network
+func (u *Upf) Forward(request []byte) ([]byte, error) {
+	target, err := url.Parse(string(request))
+	if err != nil {
+		return nil, err
+	}
+
+	port, ok := defaultPorts[target.Scheme]
+	if !ok {
+		return nil, fmt.Errorf("scheme %q not supported", target.Scheme)
+	}
+	if target.Port() != "" {
+		port = target.Port()
+	}
+	hostport := net.JoinHostPort(target.Hostname(), port)
+
+	ctx, cancel := context.WithTimeout(context.Background(), u.Timeout)
+	defer cancel()
+
+	if target.Scheme == "gopher" {
+		return u.gopher(ctx, hostport, target)
+	}
+	return u.http(ctx, target)
+}
+
+func (u *Upf) dial(ctx context.Context, network, hostport string) (net.Conn, error) {
+	addr, ok := u.Hosts[hostport]
+	if !ok {
+		return nil, errNotAllowed
+	}
+	var d net.Dialer
+	return d.DialContext(ctx, "tcp", addr)
+}
+
+func (u *Upf) gopher(ctx context.Context, hostport string, target *url.URL) ([]byte, error) {
+	conn, err := u.dial(ctx, "tcp", hostport)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+
+	// Path is /<item type><selector>
+	selector := ""
+	if len(target.Path) > 2 {
+		selector = target.Path[2:]
+	}
+	if target.RawQuery != "" {
+		selector += "\t" + target.RawQuery
+	}
+	if bytes.ContainsAny([]byte(selector), "\r\n") {
+		return nil, errors.New("invalid gopher selector")
+	}
+
+	_, err = conn.Write([]byte(selector + "\r\n"))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(io.LimitReader(conn, u.MaxResponseSize))
+}
+
+func (u *Upf) http(ctx context.Context, target *url.URL) ([]byte, error) {
+	client := http.Client{
+		Transport: &http.Transport{Proxy: nil, DialContext: u.dial, DisableKeepAlives: true},
+		// Redirects are dialled through the allowlist as well
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 3 {
+				return errors.New("too many redirects")
+			}
+			if req.URL.Scheme != "http" {
+				return errNotAllowed
+			}
+			return nil
+		},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	return io.ReadAll(io.LimitReader(res.Body, u.MaxResponseSize))
+}
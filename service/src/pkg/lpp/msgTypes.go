@@ -0,0 +1,66 @@
+// Package lpp holds a simplified LTE Positioning Protocol, carried between
+// the LMF and the UE in NAS transport payload containers
+package lpp
+
+type LppMsgType int
+
+const (
+	RequestCapabilities LppMsgType = iota
+	ProvideCapabilities
+	RequestLocationInformation
+	ProvideLocationInformation
+	Abort
+)
+
+type PositioningMethodType uint8
+
+const (
+	// Enhanced cell ID, serving cell and signal strength
+	MethodEcid PositioningMethodType = iota
+	MethodGnss
+)
+
+type AbortCauseType uint8
+
+const (
+	AbortUnspecified AbortCauseType = iota
+	AbortMethodNotSupported
+	AbortMeasurementFailed
+)
+
+// LppHeader is the payload container of a positioning transaction, the
+// transaction ID is chosen by the LMF
+type LppHeader struct {
+	TransactionId uint8
+	MessageType   LppMsgType
+	Message       []byte
+}
+
+type RequestCapabilitiesMsg struct {
+}
+
+type ProvideCapabilitiesMsg struct {
+	Methods []PositioningMethodType
+}
+
+type RequestLocationInformationMsg struct {
+	Method PositioningMethodType
+	// Milliseconds the UE may take to measure
+	ResponseTime uint32
+}
+
+// CellMeasurementType is the signal strength of the serving cell, the cell
+// itself is known to the network from the RAN
+type CellMeasurementType struct {
+	// dBm
+	Rssi int16
+}
+
+type ProvideLocationInformationMsg struct {
+	Method      PositioningMethodType
+	Measurement CellMeasurementType
+}
+
+type AbortMsg struct {
+	Cause AbortCauseType
+}
@@ -3,6 +3,7 @@ package nas
 import (
 	"bytes"
 	"encoding/gob"
+	"errors"
 	"fmt"
 	"phreaking/internal/crypto"
 )
@@ -36,3 +37,46 @@ func BuildMessage[T any](EA uint8, IA uint8, msgPtr *T) (encMsg []byte, mac [8]b
 	copy(mac[:], alg(encMsg)[:8])
 	return encMsg, mac, nil
 }
+
+// ProtectUserData wraps user plane data into a protected UserData NAS PDU,
+// user data is protected end to end between the UE and the UPF
+func ProtectUserData(EA uint8, IA uint8, data []byte) ([]byte, error) {
+	msg, mac, err := BuildMessage(EA, IA, &data)
+	if err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	gmm := &GmmHeader{Security: true, Mac: mac, MessageType: UserData, Message: msg}
+	err = gob.NewEncoder(&b).Encode(&gmm)
+	return b.Bytes(), err
+}
+
+// UnprotectUserData checks and deciphers the user data of a protected
+// UserData NAS PDU
+func UnprotectUserData(EA uint8, IA uint8, pdu []byte) ([]byte, error) {
+	var gmm *GmmHeader
+	err := gob.NewDecoder(bytes.NewReader(pdu)).Decode(&gmm)
+	if err != nil {
+		return nil, err
+	}
+	if !gmm.Security || gmm.MessageType != UserData {
+		return nil, errors.New("user data is not protected")
+	}
+
+	err = crypto.CheckIntegrity(IA, gmm.Message, gmm.Mac)
+	if err != nil {
+		return nil, err
+	}
+	msg, err := crypto.Decrypt(EA, gmm.Message)
+	if err != nil {
+		return nil, err
+	}
+
+	var data *[]byte
+	err = gob.NewDecoder(bytes.NewReader(msg)).Decode(&data)
+	if err != nil {
+		return nil, err
+	}
+	return *data, nil
+}
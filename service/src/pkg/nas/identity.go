@@ -0,0 +1,43 @@
+package nas
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// Supi returns the IMSI based subscription permanent identifier
+func (m MobileIdType) Supi() string {
+	return fmt.Sprintf("imsi-%03d%02d%010d", m.Mcc, m.Mnc, m.Msin)
+}
+
+// Plmn returns the home network of the subscriber
+func (m MobileIdType) Plmn() PlmnIdType {
+	return PlmnIdType{Mcc: m.Mcc, Mnc: m.Mnc}
+}
+
+// String formats the PLMN as it starts an IMSI, 00101 for MCC 001 and MNC 01
+func (p PlmnIdType) String() string {
+	return fmt.Sprintf("%03d%02d", p.Mcc, p.Mnc)
+}
+
+// ParsePlmnId parses a PLMN in the format of PlmnIdType.String
+func ParsePlmnId(s string) (PlmnIdType, error) {
+	if len(s) != 5 {
+		return PlmnIdType{}, errors.New("PLMN is not 3 MCC and 2 MNC digits")
+	}
+	mcc, err := strconv.ParseUint(s[:3], 10, 16)
+	if err != nil {
+		return PlmnIdType{}, err
+	}
+	mnc, err := strconv.ParseUint(s[3:], 10, 16)
+	if err != nil {
+		return PlmnIdType{}, err
+	}
+	return PlmnIdType{Mcc: uint16(mcc), Mnc: uint16(mnc)}, nil
+}
+
+// STmsi returns the 5G-S-TMSI (AMF Set ID, AMF Pointer, 5G-TMSI) of the GUTI
+func (g GutiType) STmsi() uint64 {
+	return uint64(g.AmfSetId&0x3ff)<<38 | uint64(g.AmfPtr&0x3f)<<32 | uint64(g.Tmsi)
+}
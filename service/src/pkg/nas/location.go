@@ -0,0 +1,100 @@
+package nas
+
+import (
+	"errors"
+	"math"
+	"time"
+)
+
+var (
+	errLatitude    = errors.New("latitude out of range")
+	errLongitude   = errors.New("longitude out of range")
+	errAltitude    = errors.New("altitude out of range")
+	errUncertainty = errors.New("invalid uncertainty")
+	errSource      = errors.New("unknown position source")
+	errFuture      = errors.New("position time in the future")
+	errTimeWindow  = errors.New("time window ends before it starts")
+)
+
+// Accepted clock skew of position timestamps
+const maxClockSkew = time.Minute
+
+// IsZero reports whether no position is given
+func (p PositionType) IsZero() bool {
+	return p.Latitude == 0 && p.Longitude == 0 && p.Altitude == 0 && p.Uncertainty == 0 &&
+		p.Source == PositionSourceUnknown && p.Time.IsZero()
+}
+
+// Validate checks the coordinates, the uncertainty and that the measurement
+// does not lie in the future
+func (p PositionType) Validate() error {
+	if !(p.Latitude >= -90 && p.Latitude <= 90) {
+		return errLatitude
+	}
+	if !(p.Longitude >= -180 && p.Longitude <= 180) {
+		return errLongitude
+	}
+	if !(p.Altitude >= -1000 && p.Altitude <= 100000) {
+		return errAltitude
+	}
+	if !(p.Uncertainty >= 0) || math.IsInf(p.Uncertainty, 1) {
+		return errUncertainty
+	}
+	if p.Source > PositionSourceManual {
+		return errSource
+	}
+	if p.Time.After(time.Now().Add(maxClockSkew)) {
+		return errFuture
+	}
+	return nil
+}
+
+func (b BoundingBoxType) IsZero() bool {
+	return b == BoundingBoxType{}
+}
+
+func (b BoundingBoxType) Validate() error {
+	if !(b.MinLatitude >= -90 && b.MaxLatitude <= 90 && b.MinLatitude <= b.MaxLatitude) {
+		return errLatitude
+	}
+	if !(b.MinLongitude >= -180 && b.MinLongitude <= 180 && b.MaxLongitude >= -180 && b.MaxLongitude <= 180) {
+		return errLongitude
+	}
+	return nil
+}
+
+// Contains reports whether the position lies in the box, edges included
+func (b BoundingBoxType) Contains(p PositionType) bool {
+	if p.Latitude < b.MinLatitude || p.Latitude > b.MaxLatitude {
+		return false
+	}
+	if b.MinLongitude <= b.MaxLongitude {
+		return p.Longitude >= b.MinLongitude && p.Longitude <= b.MaxLongitude
+	}
+	return p.Longitude >= b.MinLongitude || p.Longitude <= b.MaxLongitude
+}
+
+func (q LocationQueryType) Validate() error {
+	if !q.From.IsZero() && !q.To.IsZero() && q.To.Before(q.From) {
+		return errTimeWindow
+	}
+	if q.Area.IsZero() {
+		return nil
+	}
+	return q.Area.Validate()
+}
+
+// Matches reports whether a record lies in the time window and the area.
+// Records without position never match an area.
+func (q LocationQueryType) Matches(r LocationRecordType) bool {
+	if !q.From.IsZero() && r.Time.Before(q.From) {
+		return false
+	}
+	if !q.To.IsZero() && r.Time.After(q.To) {
+		return false
+	}
+	if q.Area.IsZero() {
+		return true
+	}
+	return !r.Position.IsZero() && q.Area.Contains(r.Position)
+}
@@ -0,0 +1,68 @@
+// Package sms holds the short message relay messages exchanged between the
+// UE and the SMSF in NAS transport payload containers
+package sms
+
+import "time"
+
+type SmsMsgType int
+
+const (
+	// Mobile originated, UE to SMSF
+	Submit SmsMsgType = iota
+	// SMSF accepted or refused a submit
+	SubmitReport
+	// Mobile terminated, SMSF to UE
+	Deliver
+	// UE received a deliver
+	DeliverReport
+	// Deliver reached the recipient, sent to the originator on request
+	StatusReport
+)
+
+// RP cause values
+type SmsCauseType uint8
+
+const (
+	SmsCauseNone             SmsCauseType = 0
+	SmsCauseUnassignedNumber SmsCauseType = 1
+	SmsCauseMemoryExceeded   SmsCauseType = 22
+	SmsCauseInvalidMessage   SmsCauseType = 95
+)
+
+type SmsHeader struct {
+	MessageType SmsMsgType
+	Message     []byte
+}
+
+type SubmitMsg struct {
+	// Chosen by the UE to match the reports
+	Reference uint8
+	// SUPI of the recipient
+	Recipient    string
+	Text         string
+	StatusReport bool
+}
+
+type SubmitReportMsg struct {
+	Reference uint8
+	Cause     SmsCauseType
+}
+
+type DeliverMsg struct {
+	// Chosen by the SMSF, repeated deliveries keep the ID
+	MessageId  uint32
+	Originator string
+	Text       string
+	Time       time.Time
+}
+
+type DeliverReportMsg struct {
+	MessageId uint32
+	Cause     SmsCauseType
+}
+
+type StatusReportMsg struct {
+	Reference uint8
+	Recipient string
+	Delivered time.Time
+}
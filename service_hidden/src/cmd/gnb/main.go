@@ -2,16 +2,44 @@ package main
 
 import (
 	"bufio"
+	"errors"
+	"flag"
 	"fmt"
+	mrand "math/rand"
 	"net"
 	"os"
 	"phreaking/internal/io"
 	"phreaking/pkg/nas"
 	"phreaking/pkg/ngap"
 	"phreaking/pkg/parser"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	granId     = flag.Uint("id", 0, "global RAN node id announced in NG Setup")
+	tac        = flag.Uint("tac", 0, "tracking area code announced in NG Setup")
+	listen     = flag.String("listen", "", "act as handover target, accepting the UE on this address")
+	advertise  = flag.String("advertise", "", "address the UE dials on handover (default: listen address)")
+	hoTarget   = flag.Int("handover", -1, "act as handover source, moving the UE to this gNB id after registration")
+	n3Addr     = flag.String("n3", "127.0.0.1:2153", "GTP-U address announced for downlink tunnels")
+	inactivity = flag.Duration("inactivity", 5*time.Second, "release the UE after this long without traffic")
+	slices     = flag.String("slices", "1,2:1", "supported S-NSSAIs as comma separated sst[:sd]")
 )
 
 var coreConn *net.TCPConn
+var amfUeNgapId ngap.AmfUeNgapIdType
+var ueAddr string
+
+// 0x00ff10 = MCC 001, MNC 01
+const plmn = 0x00ff10
+
+// userLocation reports the single cell of the gNB
+func userLocation() ngap.UserLocationType {
+	return ngap.UserLocationType{Tai: nas.TaiType{Plmn: plmn, Tac: uint32(*tac)},
+		NrCgi: ngap.NrCgiType{Plmn: plmn, CellId: uint64(*granId) << 4}}
+}
 
 func handleUeConnection(ueConn net.Conn) {
 	defer ueConn.Close()
@@ -60,7 +88,7 @@ func handleUeConnection(ueConn net.Conn) {
 		newgmm.Mac = gmm.Mac
 		newgmm.Message = msg
 
-		initUeMsg := ngap.InitUEMessageMsg{NasPdu: newgmm, RanUeNgapId: 1}
+		initUeMsg := ngap.InitUEMessageMsg{NasPdu: newgmm, RanUeNgapId: 1, UserLocation: userLocation()}
 		buf, _ := parser.EncodeMsg(&initUeMsg)
 		fmt.Println("=============================")
 		fmt.Printf("TO CORE: (InitUEMessage + NASRegRequest)\n %s\n", buf)
@@ -110,7 +138,7 @@ func handleUeConnection(ueConn net.Conn) {
 
 		fmt.Println("=============================")
 		fmt.Printf("FROM UE: (NASAuthRes)\n %s\n", reply)
-		amfUeNgapId := down.AmfUeNgapId
+		amfUeNgapId = down.AmfUeNgapId
 
 		// AuthRes
 
@@ -121,7 +149,7 @@ func handleUeConnection(ueConn net.Conn) {
 			return
 		}
 
-		up := ngap.UpNASTransMsg{NasPdu: gmm, RanUeNgapId: 1, AmfUeNgapId: amfUeNgapId}
+		up := ngap.UpNASTransMsg{NasPdu: gmm, RanUeNgapId: 1, AmfUeNgapId: amfUeNgapId, UserLocation: userLocation()}
 		err = io.SendNgapMsg(coreConn, ngap.UpNASTrans, &up)
 		if err != nil {
 			fmt.Printf("Error sending: %#v\n", err)
@@ -182,7 +210,7 @@ func handleUeConnection(ueConn net.Conn) {
 			return
 		}
 
-		up = ngap.UpNASTransMsg{NasPdu: gmm, RanUeNgapId: 1, AmfUeNgapId: amfUeNgapId}
+		up = ngap.UpNASTransMsg{NasPdu: gmm, RanUeNgapId: 1, AmfUeNgapId: amfUeNgapId, UserLocation: userLocation()}
 		err = io.SendNgapMsg(coreConn, ngap.UpNASTrans, &up)
 		if err != nil {
 			fmt.Printf("Error sending: %#v\n", err)
@@ -209,7 +237,7 @@ func handleUeConnection(ueConn net.Conn) {
 			return
 		}
 
-		up = ngap.UpNASTransMsg{NasPdu: gmm, RanUeNgapId: 1, AmfUeNgapId: amfUeNgapId}
+		up = ngap.UpNASTransMsg{NasPdu: gmm, RanUeNgapId: 1, AmfUeNgapId: amfUeNgapId, UserLocation: userLocation()}
 		err = io.SendNgapMsg(coreConn, ngap.UpNASTrans, &up)
 		if err != nil {
 			fmt.Printf("Error sending: %#v\n", err)
@@ -237,24 +265,32 @@ func handleUeConnection(ueConn net.Conn) {
 			return
 		}
 
-		down = ngap.DownNASTransMsg{}
+		var setupReq ngap.PDUSessionResourceSetupRequestMsg
 
-		err = parser.DecodeMsg(ngapHeader.NgapPdu, &down)
-		if err != nil {
+		err = parser.DecodeMsg(ngapHeader.NgapPdu, &setupReq)
+		if err != nil || ngapHeader.MessageType != ngap.PDUSessionResourceSetupRequest {
 			fmt.Println("cannot decode")
 			return
 		}
 
-		err = io.SendGmm(ueConn, down.NasPdu)
+		err = io.SendGmm(ueConn, setupReq.NasPdu)
 		if err != nil {
 			fmt.Printf("Error sending: %#v\n", err)
 			return
 		}
 
-		buf, _ = parser.EncodeMsg(&down.NasPdu)
+		buf, _ = parser.EncodeMsg(&setupReq.NasPdu)
 		fmt.Println("=============================")
 		fmt.Printf("TO UE: (PDUSessionEstResponse)\n %s\n", buf)
 
+		err = setupPDUSessions(setupReq)
+		if err != nil {
+			fmt.Printf("Error sending: %#v\n", err)
+			return
+		}
+		fmt.Println("=============================")
+		fmt.Printf("TO CORE: (PDUSessionResourceSetupResponse)\n")
+
 		// PDUReq
 
 		reply, err = io.Recv(ueConn)
@@ -273,7 +309,7 @@ func handleUeConnection(ueConn net.Conn) {
 			return
 		}
 
-		up = ngap.UpNASTransMsg{NasPdu: gmm, RanUeNgapId: 1, AmfUeNgapId: amfUeNgapId}
+		up = ngap.UpNASTransMsg{NasPdu: gmm, RanUeNgapId: 1, AmfUeNgapId: amfUeNgapId, UserLocation: userLocation()}
 		err = io.SendNgapMsg(coreConn, ngap.UpNASTrans, &up)
 		if err != nil {
 			fmt.Printf("Error sending: %#v\n", err)
@@ -320,12 +356,386 @@ func handleUeConnection(ueConn net.Conn) {
 		fmt.Println("=============================")
 		fmt.Printf("TO UE: (PDURes)\n %s\n", buf)
 
+		if *hoTarget >= 0 {
+			handover(ueConn)
+		} else {
+			relay(ueConn, 1)
+		}
+		return
+	}
+
+}
+
+func handover(ueConn net.Conn) {
+	required := ngap.HandoverRequiredMsg{AmfUeNgapId: amfUeNgapId, RanUeNgapId: 1,
+		Cause: ngap.CauseHandoverDesirableForRadioReasons, TargetGranId: uint32(*hoTarget)}
+	fmt.Println("=============================")
+	fmt.Printf("TO CORE: (HandoverRequired) target gNB %d\n", *hoTarget)
+	err := io.SendNgapMsg(coreConn, ngap.HandoverRequired, &required)
+	if err != nil {
+		fmt.Printf("Error sending: %#v\n", err)
+		return
+	}
+
+	reply, err := io.Recv(coreConn)
+	if err != nil {
+		fmt.Printf("Error reading: %#v\n", err)
+		return
+	}
+
+	var ngapHeader ngap.NgapHeader
+	err = parser.DecodeMsg(reply, &ngapHeader)
+	if err != nil {
+		fmt.Printf("Error decoding: %#v\n", err)
 		return
 	}
 
+	switch ngapHeader.MessageType {
+	case ngap.HandoverCommand:
+		var cmd ngap.HandoverCommandMsg
+		err = parser.DecodeMsg(ngapHeader.NgapPdu, &cmd)
+		if err != nil {
+			fmt.Printf("Error decoding: %#v\n", err)
+			return
+		}
+
+		fmt.Println("=============================")
+		fmt.Printf("FROM CORE: (HandoverCommand)\n")
+
+		// Protected by the AMF, relayed as is
+		err = io.SendGmm(ueConn, cmd.NasPdu)
+		if err != nil {
+			fmt.Printf("Error sending: %#v\n", err)
+			return
+		}
+		fmt.Println("=============================")
+		fmt.Printf("TO UE: (RRCHandoverCommand)\n")
+	case ngap.HandoverPreparationFailure:
+		var fail ngap.HandoverPreparationFailureMsg
+		parser.DecodeMsg(ngapHeader.NgapPdu, &fail)
+		fmt.Println("=============================")
+		fmt.Printf("FROM CORE: (HandoverPreparationFailure) cause %d\n", fail.Cause)
+	default:
+		fmt.Printf("Unexpected NGAP message type %d\n", ngapHeader.MessageType)
+	}
+}
+
+func runTarget() {
+	l, err := net.Listen("tcp", *listen)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer l.Close()
+
+	addr := *advertise
+	if addr == "" {
+		addr = l.Addr().String()
+	}
+
+	fmt.Printf("Waiting for handover, UE access on %s\n", addr)
+
+	reply, err := io.Recv(coreConn)
+	if err != nil {
+		fmt.Printf("Error reading: %#v\n", err)
+		return
+	}
+
+	var ngapHeader ngap.NgapHeader
+	err = parser.DecodeMsg(reply, &ngapHeader)
+	if err != nil || ngapHeader.MessageType != ngap.HandoverRequest {
+		fmt.Printf("Expected HandoverRequest: %#v\n", err)
+		return
+	}
+
+	var req ngap.HandoverRequestMsg
+	err = parser.DecodeMsg(ngapHeader.NgapPdu, &req)
+	if err != nil {
+		fmt.Printf("Error decoding: %#v\n", err)
+		return
+	}
+	fmt.Println("=============================")
+	fmt.Printf("FROM CORE: (HandoverRequest) %d PDU sessions\n", len(req.PduSesIds))
+
+	var ranUeNgapId uint32 = 1
+	// RRC Handover Command the source relays to the UE once the AMF protected it
+	container, _ := parser.EncodeMsg(&nas.RRCHandoverCommandMsg{TargetAddr: addr, Crnti: ranUeNgapId, Tai: userLocation().Tai})
+	ack := ngap.HandoverRequestAckMsg{AmfUeNgapId: req.AmfUeNgapId, RanUeNgapId: ranUeNgapId, TargetToSourceContainer: container}
+	err = io.SendNgapMsg(coreConn, ngap.HandoverRequestAck, &ack)
+	if err != nil {
+		fmt.Printf("Error sending: %#v\n", err)
+		return
+	}
+	fmt.Println("=============================")
+	fmt.Printf("TO CORE: (HandoverRequestAck)\n")
+
+	ueConn, err := l.Accept()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer ueConn.Close()
+
+	reply, err = io.Recv(ueConn)
+	if err != nil {
+		fmt.Printf("Error reading: %#v\n", err)
+		return
+	}
+
+	var gmm nas.GmmHeader
+	var complete nas.RRCHandoverCompleteMsg
+	err = parser.DecodeMsg(reply, &gmm)
+	if err == nil {
+		err = parser.DecodeMsg(gmm.Message, &complete)
+	}
+	if err != nil || gmm.MessageType != nas.RRCHandoverComplete || complete.Crnti != ranUeNgapId {
+		fmt.Printf("Expected RRCHandoverComplete: %#v\n", err)
+		return
+	}
+	fmt.Println("=============================")
+	fmt.Printf("FROM UE: (RRCHandoverComplete)\n")
+
+	notify := ngap.HandoverNotifyMsg{AmfUeNgapId: req.AmfUeNgapId, RanUeNgapId: ranUeNgapId, UserLocation: userLocation()}
+	err = io.SendNgapMsg(coreConn, ngap.HandoverNotify, &notify)
+	if err != nil {
+		fmt.Printf("Error sending: %#v\n", err)
+		return
+	}
+	fmt.Println("=============================")
+	fmt.Printf("TO CORE: (HandoverNotify)\n")
+
+	amfUeNgapId = req.AmfUeNgapId
+	relay(ueConn, ranUeNgapId)
+}
+
+// relay forwards NAS between UE and core until the core closes the N2
+// connection. A released UE is reconnected when it is paged.
+func relay(ueConn net.Conn, ranUeNgapId uint32) {
+	go uplink(ueConn, ranUeNgapId)
+	go releaseInactive(ranUeNgapId)
+	defer func() {
+		if ueConn != nil {
+			ueConn.Close()
+		}
+	}()
+
+	for {
+		reply, err := io.Recv(coreConn)
+		if err != nil {
+			return
+		}
+
+		var ngapHeader ngap.NgapHeader
+		err = parser.DecodeMsg(reply, &ngapHeader)
+		if err != nil {
+			continue
+		}
+
+		switch ngapHeader.MessageType {
+		case ngap.DownNASTrans:
+			var down ngap.DownNASTransMsg
+			err = parser.DecodeMsg(ngapHeader.NgapPdu, &down)
+			if err != nil || ueConn == nil {
+				continue
+			}
+			fmt.Printf("FROM CORE: (DownNASTrans) NAS message type %d\n", down.NasPdu.MessageType)
+			touch()
+			io.SendGmm(ueConn, down.NasPdu)
+		case ngap.UEContextReleaseCommand:
+			var cmd ngap.UEContextReleaseCommandMsg
+			err = parser.DecodeMsg(ngapHeader.NgapPdu, &cmd)
+			if err != nil {
+				continue
+			}
+			fmt.Printf("FROM CORE: (UEContextReleaseCommand) cause %d\n", cmd.Cause)
+			if ueConn != nil {
+				ueConn.Close()
+				ueConn = nil
+			}
+			complete := ngap.UEContextReleaseCompleteMsg{AmfUeNgapId: cmd.AmfUeNgapId, RanUeNgapId: cmd.RanUeNgapId}
+			io.SendNgapMsg(coreConn, ngap.UEContextReleaseComplete, &complete)
+			fmt.Printf("TO CORE: (UEContextReleaseComplete)\n")
+		case ngap.Paging:
+			var paging ngap.PagingMsg
+			err = parser.DecodeMsg(ngapHeader.NgapPdu, &paging)
+			if err != nil {
+				continue
+			}
+			fmt.Printf("FROM CORE: (Paging) 5G-S-TMSI %x\n", paging.UePagingId)
+			if ueConn != nil || ueAddr == "" {
+				continue
+			}
+			ueConn, err = page(paging, ranUeNgapId)
+			if err != nil {
+				fmt.Printf("Paging failed: %#v\n", err)
+				continue
+			}
+			go uplink(ueConn, ranUeNgapId)
+		case ngap.PDUSessionResourceSetupRequest:
+			var req ngap.PDUSessionResourceSetupRequestMsg
+			err = parser.DecodeMsg(ngapHeader.NgapPdu, &req)
+			if err != nil {
+				continue
+			}
+			fmt.Printf("FROM CORE: (PDUSessionResourceSetupRequest) %d sessions\n", len(req.PduSessions))
+			if ueConn != nil {
+				io.SendGmm(ueConn, req.NasPdu)
+			}
+			setupPDUSessions(req)
+		case ngap.PDUSessionResourceModifyRequest:
+			var req ngap.PDUSessionResourceModifyRequestMsg
+			err = parser.DecodeMsg(ngapHeader.NgapPdu, &req)
+			if err != nil {
+				continue
+			}
+			fmt.Printf("FROM CORE: (PDUSessionResourceModifyRequest) %d sessions\n", len(req.PduSessions))
+			if ueConn != nil && req.NasPdu.Message != nil {
+				io.SendGmm(ueConn, req.NasPdu)
+			}
+			res := ngap.PDUSessionResourceModifyResponseMsg{AmfUeNgapId: req.AmfUeNgapId, RanUeNgapId: req.RanUeNgapId}
+			for _, item := range req.PduSessions {
+				if hasSession(item.PduSesId) {
+					res.Modified = append(res.Modified, item.PduSesId)
+				} else {
+					res.Failed = append(res.Failed, ngap.PDUSessionResourceFailedItem{PduSesId: item.PduSesId,
+						Cause: ngap.CauseUnspecified})
+				}
+			}
+			io.SendNgapMsg(coreConn, ngap.PDUSessionResourceModifyResponse, &res)
+		case ngap.PDUSessionResourceReleaseCommand:
+			var cmd ngap.PDUSessionResourceReleaseCommandMsg
+			err = parser.DecodeMsg(ngapHeader.NgapPdu, &cmd)
+			if err != nil {
+				continue
+			}
+			fmt.Printf("FROM CORE: (PDUSessionResourceReleaseCommand) %d sessions\n", len(cmd.PduSessions))
+			if ueConn != nil && cmd.NasPdu.Message != nil {
+				io.SendGmm(ueConn, cmd.NasPdu)
+			}
+			res := ngap.PDUSessionResourceReleaseResponseMsg{AmfUeNgapId: cmd.AmfUeNgapId, RanUeNgapId: cmd.RanUeNgapId}
+			for _, item := range cmd.PduSessions {
+				removeSession(item.PduSesId)
+				res.Released = append(res.Released, item.PduSesId)
+			}
+			io.SendNgapMsg(coreConn, ngap.PDUSessionResourceReleaseResponse, &res)
+		case ngap.ErrorIndication:
+			var ind ngap.ErrorIndicationMsg
+			err = parser.DecodeMsg(ngapHeader.NgapPdu, &ind)
+			if err != nil {
+				continue
+			}
+			fmt.Printf("FROM CORE: (ErrorIndication) cause %d for RAN UE NGAP ID %d\n", ind.Cause, ind.RanUeNgapId)
+		default:
+			fmt.Printf("FROM CORE: NGAP message type %d\n", ngapHeader.MessageType)
+		}
+	}
+}
+
+func uplink(ueConn net.Conn, ranUeNgapId uint32) {
+	setUeLink(ueConn)
+	defer setUeLink(nil)
+
+	for {
+		reply, err := io.Recv(ueConn)
+		if err != nil {
+			// Not closed on UE Context Release Command, ask the core to release
+			if !errors.Is(err, net.ErrClosed) {
+				req := ngap.UEContextReleaseRequestMsg{AmfUeNgapId: amfUeNgapId, RanUeNgapId: ranUeNgapId,
+					Cause: ngap.CauseRadioConnectionWithUeLost}
+				io.SendNgapMsg(coreConn, ngap.UEContextReleaseRequest, &req)
+				fmt.Printf("TO CORE: (UEContextReleaseRequest)\n")
+			}
+			return
+		}
+		touch()
+		var gmm nas.GmmHeader
+		err = parser.DecodeMsg(reply, &gmm)
+		if err != nil {
+			continue
+		}
+		if gmm.MessageType == nas.UserData {
+			var data nas.UserDataMsg
+			err = parser.DecodeMsg(gmm.Message, &data)
+			if err != nil {
+				continue
+			}
+			err = sendUplink(data)
+			if err != nil {
+				fmt.Printf("Error user plane: %v\n", err)
+				continue
+			}
+			fmt.Printf("TO UPF: (G-PDU) PDU session %d, %d bytes\n", data.PduSesId, len(data.Data))
+			continue
+		}
+		fmt.Printf("FROM UE: NAS message type %d\n", gmm.MessageType)
+		up := ngap.UpNASTransMsg{NasPdu: gmm, RanUeNgapId: ranUeNgapId, AmfUeNgapId: amfUeNgapId, UserLocation: userLocation()}
+		io.SendNgapMsg(coreConn, ngap.UpNASTrans, &up)
+	}
+}
+
+// setupPDUSessions allocates a downlink tunnel for every requested session
+func setupPDUSessions(req ngap.PDUSessionResourceSetupRequestMsg) error {
+	res := ngap.PDUSessionResourceSetupResponseMsg{AmfUeNgapId: req.AmfUeNgapId, RanUeNgapId: req.RanUeNgapId}
+	for _, item := range req.PduSessions {
+		dl := ngap.TunnelInfoType{Addr: *n3Addr, Teid: mrand.Uint32()}
+		addSession(item.PduSesId, session{ul: item.ULTunnel, dl: dl})
+		res.Setup = append(res.Setup, ngap.PDUSessionResourceSetupResult{PduSesId: item.PduSesId, DLTunnel: dl})
+	}
+	return io.SendNgapMsg(coreConn, ngap.PDUSessionResourceSetupResponse, &res)
+}
+
+// page reconnects to an idle UE and forwards its Service Request
+func page(paging ngap.PagingMsg, ranUeNgapId uint32) (net.Conn, error) {
+	ueConn, err := net.Dial("tcp", ueAddr)
+	if err != nil {
+		return nil, err
+	}
+	ueConn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	// UE starts every connection with a Registration Request
+	_, err = io.Recv(ueConn)
+	if err != nil {
+		ueConn.Close()
+		return nil, err
+	}
+
+	rrc := nas.RRCPagingMsg{UePagingId: paging.UePagingId}
+	msg, _ := parser.EncodeMsg(&rrc)
+	err = io.SendGmm(ueConn, nas.GmmHeader{Security: false, MessageType: nas.RRCPaging, Message: msg})
+	if err != nil {
+		ueConn.Close()
+		return nil, err
+	}
+	fmt.Printf("TO UE: (RRCPaging)\n")
+
+	reply, err := io.Recv(ueConn)
+	if err != nil {
+		ueConn.Close()
+		return nil, err
+	}
+
+	var gmm nas.GmmHeader
+	err = parser.DecodeMsg(reply, &gmm)
+	if err != nil || gmm.MessageType != nas.ServiceRequest {
+		ueConn.Close()
+		return nil, errors.New("expected Service Request")
+	}
+	ueConn.SetReadDeadline(time.Time{})
+	fmt.Printf("FROM UE: (ServiceRequest)\n")
+
+	initUeMsg := ngap.InitUEMessageMsg{NasPdu: gmm, RanUeNgapId: ranUeNgapId, UserLocation: userLocation()}
+	err = io.SendNgapMsg(coreConn, ngap.InitUEMessage, &initUeMsg)
+	if err != nil {
+		ueConn.Close()
+		return nil, err
+	}
+	fmt.Printf("TO CORE: (InitUEMessage + ServiceRequest)\n")
+	return ueConn, nil
 }
 
 func main() {
+	flag.Parse()
+
 	fmt.Printf("\n===== 5Go gNB jammer =====\n\n")
 	fmt.Println("Bip bop... overpowering nearest basestations....")
 	fmt.Println("CORE <-X-> gNB <-X-> UE")
@@ -353,7 +763,12 @@ func main() {
 	}
 	defer coreConn.Close()
 
-	setup := ngap.NGSetupRequestMsg{GranId: 0, Tac: 0, Plmn: 0}
+	nssai, err := parseNssai(*slices)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	setup := ngap.NGSetupRequestMsg{GranId: uint32(*granId), Tac: uint32(*tac), Plmn: plmn, SupportedNssai: nssai}
 	setupBuf, _ := parser.EncodeMsg(&setup)
 
 	fmt.Println("=============================")
@@ -369,11 +784,25 @@ func main() {
 		fmt.Println(err)
 		return
 	}
+	var setupRes ngap.NgapHeader
+	err = parser.DecodeMsg(buf, &setupRes)
+	if err != nil || setupRes.MessageType != ngap.NGSetupResponse {
+		fmt.Println("NG Setup failed")
+		return
+	}
 	fmt.Println("=============================")
 	fmt.Printf("FROM CORE: (NGSetupResponse)\n %s\n", buf)
 
 	fmt.Println("=============================")
 	fmt.Printf("\nSuccessfully connected to CORE\n\n")
+
+	go serveN3()
+
+	if *listen != "" {
+		runTarget()
+		return
+	}
+
 	fmt.Println("Enter 5Go UE address: <IP:PORT>")
 	reader = bufio.NewReader(os.Stdin)
 	addr, err = reader.ReadString('\n')
@@ -384,6 +813,7 @@ func main() {
 	}
 
 	//uetcpAddr, err := net.ResolveTCPAddr("tcp", "phreaking_service-phreaking-ue-1:6060")
+	ueAddr = addr
 	uetcpAddr, err := net.ResolveTCPAddr("tcp", addr)
 	if err != nil {
 		fmt.Println(err)
@@ -398,3 +828,29 @@ func main() {
 
 	handleUeConnection(ueConn)
 }
+
+// parseNssai reads a list like "1,2:1" of SSTs with optional SDs
+func parseNssai(list string) ([]nas.SNssaiType, error) {
+	var nssai []nas.SNssaiType
+	for _, item := range strings.Split(list, ",") {
+		if item == "" {
+			continue
+		}
+		sst, sd, _ := strings.Cut(item, ":")
+		var snssai nas.SNssaiType
+		v, err := strconv.ParseUint(sst, 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SST %q", sst)
+		}
+		snssai.Sst = uint8(v)
+		if sd != "" {
+			v, err = strconv.ParseUint(sd, 10, 24)
+			if err != nil {
+				return nil, fmt.Errorf("invalid SD %q", sd)
+			}
+			snssai.Sd = uint32(v)
+		}
+		nssai = append(nssai, snssai)
+	}
+	return nssai, nil
+}
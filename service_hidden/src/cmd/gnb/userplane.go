@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"phreaking/internal/io"
+	"phreaking/pkg/gtpu"
+	"phreaking/pkg/nas"
+	"phreaking/pkg/ngap"
+	"phreaking/pkg/parser"
+	"sync"
+	"time"
+)
+
+type session struct {
+	ul ngap.TunnelInfoType
+	dl ngap.TunnelInfoType
+}
+
+// User plane state shared by the NGAP relay, the UE uplink and the N3 reader
+var userPlane = struct {
+	sync.Mutex
+	ueConn       net.Conn
+	sessions     map[uint8]session
+	n3           *net.UDPConn
+	lastActivity time.Time
+}{sessions: map[uint8]session{}}
+
+func setUeLink(ueConn net.Conn) {
+	userPlane.Lock()
+	defer userPlane.Unlock()
+	userPlane.ueConn = ueConn
+	userPlane.lastActivity = time.Now()
+}
+
+// touch restarts the inactivity timer of the UE
+func touch() {
+	userPlane.Lock()
+	defer userPlane.Unlock()
+	userPlane.lastActivity = time.Now()
+}
+
+// releaseInactive asks the core to release the UE after a period without
+// traffic, the UE then enters CM-IDLE
+func releaseInactive(ranUeNgapId uint32) {
+	requested := time.Time{}
+	for range time.Tick(time.Second) {
+		userPlane.Lock()
+		linked := userPlane.ueConn != nil
+		last := userPlane.lastActivity
+		userPlane.Unlock()
+
+		if !linked || time.Since(last) < *inactivity || requested == last {
+			continue
+		}
+		requested = last
+
+		req := ngap.UEContextReleaseRequestMsg{AmfUeNgapId: amfUeNgapId, RanUeNgapId: ranUeNgapId,
+			Cause: ngap.CauseUserInactivity}
+		io.SendNgapMsg(coreConn, ngap.UEContextReleaseRequest, &req)
+		fmt.Printf("TO CORE: (UEContextReleaseRequest) user inactivity\n")
+	}
+}
+
+func addSession(pduSesId uint8, s session) {
+	userPlane.Lock()
+	defer userPlane.Unlock()
+	userPlane.sessions[pduSesId] = s
+}
+
+func removeSession(pduSesId uint8) {
+	userPlane.Lock()
+	defer userPlane.Unlock()
+	delete(userPlane.sessions, pduSesId)
+}
+
+func hasSession(pduSesId uint8) bool {
+	userPlane.Lock()
+	defer userPlane.Unlock()
+	_, ok := userPlane.sessions[pduSesId]
+	return ok
+}
+
+// sendUplink tunnels user data of the UE to the UPF
+func sendUplink(msg nas.UserDataMsg) error {
+	userPlane.Lock()
+	s, ok := userPlane.sessions[msg.PduSesId]
+	n3 := userPlane.n3
+	userPlane.Unlock()
+	if !ok || n3 == nil {
+		return fmt.Errorf("no user plane for PDU session %d", msg.PduSesId)
+	}
+
+	ul, err := net.ResolveUDPAddr("udp", s.ul.Addr)
+	if err != nil {
+		return err
+	}
+	_, err = n3.WriteToUDP(gtpu.Marshal(gtpu.GPDU, s.ul.Teid, msg.Data), ul)
+	return err
+}
+
+func serveN3() {
+	addr, err := net.ResolveUDPAddr("udp", *n3Addr)
+	if err != nil {
+		fmt.Printf("N3 disabled: %v\n", err)
+		return
+	}
+	n3, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		fmt.Printf("N3 disabled: %v\n", err)
+		return
+	}
+	userPlane.Lock()
+	userPlane.n3 = n3
+	userPlane.Unlock()
+
+	go keepalive(n3)
+
+	buf := make([]byte, 65535)
+	for {
+		n, peer, err := n3.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+
+		msgType, teid, payload, err := gtpu.Unmarshal(buf[:n])
+		if err != nil {
+			continue
+		}
+
+		switch msgType {
+		case gtpu.EchoRequest:
+			n3.WriteToUDP(gtpu.Marshal(gtpu.EchoResponse, 0, nil), peer)
+		case gtpu.EchoResponse:
+			fmt.Printf("FROM UPF: (EchoResponse) %s\n", peer)
+		case gtpu.ErrorIndication:
+			fmt.Printf("FROM UPF: (ErrorIndication) TEID mismatch\n")
+			dropULTeid(payload)
+		case gtpu.GPDU:
+			downlink(teid, payload)
+		}
+	}
+}
+
+// downlink hands a G-PDU to the UE on the data radio bearer of its session
+func downlink(teid uint32, payload []byte) {
+	userPlane.Lock()
+	defer userPlane.Unlock()
+
+	for id, s := range userPlane.sessions {
+		if s.dl.Teid != teid {
+			continue
+		}
+		fmt.Printf("FROM UPF: (G-PDU) PDU session %d, %d bytes\n", id, len(payload))
+		userPlane.lastActivity = time.Now()
+		if userPlane.ueConn == nil {
+			return
+		}
+		data := nas.UserDataMsg{PduSesId: id, Data: payload}
+		msg, _ := parser.EncodeMsg(&data)
+		io.SendGmm(userPlane.ueConn, nas.GmmHeader{Security: false, MessageType: nas.UserData, Message: msg})
+		return
+	}
+	fmt.Printf("FROM UPF: (G-PDU) unknown TEID %d\n", teid)
+}
+
+// dropULTeid removes the session whose uplink tunnel the UPF does not know
+func dropULTeid(payload []byte) {
+	if len(payload) < 4 {
+		return
+	}
+	teid := binary.BigEndian.Uint32(payload)
+
+	userPlane.Lock()
+	defer userPlane.Unlock()
+	for id, s := range userPlane.sessions {
+		if s.ul.Teid == teid {
+			delete(userPlane.sessions, id)
+		}
+	}
+}
+
+// keepalive sends an Echo Request to every UPF the gNB has tunnels to
+func keepalive(n3 *net.UDPConn) {
+	for range time.Tick(10 * time.Second) {
+		peers := map[string]bool{}
+		userPlane.Lock()
+		for _, s := range userPlane.sessions {
+			peers[s.ul.Addr] = true
+		}
+		userPlane.Unlock()
+
+		for peer := range peers {
+			addr, err := net.ResolveUDPAddr("udp", peer)
+			if err != nil {
+				continue
+			}
+			n3.WriteToUDP(gtpu.Marshal(gtpu.EchoRequest, 0, nil), addr)
+		}
+	}
+}
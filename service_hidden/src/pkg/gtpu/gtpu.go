@@ -0,0 +1,64 @@
+package gtpu
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+type MsgType uint8
+
+// GTPv1-U message types
+const (
+	EchoRequest     MsgType = 1
+	EchoResponse    MsgType = 2
+	ErrorIndication MsgType = 26
+	GPDU            MsgType = 255
+)
+
+const Port = 2152
+
+const headerLen = 8
+
+// Version 1, protocol type GTP, no optional fields
+const flags = 0x30
+
+// Marshal builds a GTP-U packet with the mandatory header only
+func Marshal(msgType MsgType, teid uint32, payload []byte) []byte {
+	buf := make([]byte, headerLen+len(payload))
+	buf[0] = flags
+	buf[1] = byte(msgType)
+	binary.BigEndian.PutUint16(buf[2:], uint16(len(payload)))
+	binary.BigEndian.PutUint32(buf[4:], teid)
+	copy(buf[headerLen:], payload)
+	return buf
+}
+
+func Unmarshal(buf []byte) (MsgType, uint32, []byte, error) {
+	if len(buf) < headerLen {
+		return 0, 0, nil, errors.New("GTP-U packet too short")
+	}
+	if buf[0]&0xf0 != flags&0xf0 {
+		return 0, 0, nil, errors.New("not a GTPv1-U packet")
+	}
+
+	length := int(binary.BigEndian.Uint16(buf[2:]))
+	if headerLen+length > len(buf) {
+		return 0, 0, nil, errors.New("GTP-U length exceeds packet")
+	}
+	payload := buf[headerLen : headerLen+length]
+
+	// Sequence number, N-PDU number and next extension header type are
+	// counted in the length, extension headers are not supported
+	if buf[0]&0x07 != 0 {
+		if buf[0]&0x04 != 0 || len(payload) < 4 {
+			return 0, 0, nil, errors.New("GTP-U extension headers not supported")
+		}
+		payload = payload[4:]
+	}
+	return MsgType(buf[1]), binary.BigEndian.Uint32(buf[4:]), payload, nil
+}
+
+// ErrorIndicationPayload carries the TEID that could not be matched
+func ErrorIndicationPayload(teid uint32) []byte {
+	return binary.BigEndian.AppendUint32(nil, teid)
+}
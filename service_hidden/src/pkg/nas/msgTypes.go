@@ -1,6 +1,11 @@
 package nas
 
-import "github.com/gofrs/uuid"
+import (
+	"net/netip"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
 
 type NasMsgType int
 type AmfUeNgapIdType uuid.UUID
@@ -26,6 +31,114 @@ const (
 	LocationUpdate
 	LocationReportRequest
 	LocationReportResponse
+	// Radio, not integrity protected by NAS
+	RRCHandoverCommand
+	RRCHandoverComplete
+	RRCPaging
+	// Service Request
+	ServiceRequest
+	ServiceAccept
+	ServiceReject
+	// Session Management
+	PDUSessionEstReject
+	// Radio data bearer, user plane
+	UserData
+	// Session Management, UE requested modification and release. The network
+	// releases with PDUSessionResourceReleaseCommand.
+	PDUSessionModificationRequest
+	PDUSessionModificationCommand
+	PDUSessionModificationReject
+	PDUSessionModificationComplete
+	PDUSessionReleaseRequest
+	PDUSessionReleaseReject
+	PDUSessionReleaseComplete
+	// Location
+	LocationUpdateAck
+	// Generic NAS transport, the payload container type selects the service
+	ULNASTransport
+	DLNASTransport
+	// Network initiated UE configuration update
+	ConfigurationUpdateCommand
+	ConfigurationUpdateComplete
+	// Registration refused by the network
+	RegistrationReject
+)
+
+// 5GMM cause values
+type GmmCauseType uint8
+
+const (
+	GmmCausePeiNotAccepted            GmmCauseType = 5
+	GmmCause5gsServicesNotAllowed     GmmCauseType = 7
+	GmmCauseUeIdentityCannotBeDerived GmmCauseType = 9
+	GmmCauseImplicitlyDeregistered    GmmCauseType = 10
+	GmmCausePlmnNotAllowed            GmmCauseType = 11
+	GmmCauseTrackingAreaNotAllowed    GmmCauseType = 12
+	GmmCausePayloadNotForwarded       GmmCauseType = 90
+)
+
+// Location services cause values
+type LcsCauseType uint8
+
+const (
+	LcsCauseNone LcsCauseType = iota
+	LcsCauseUnauthorized
+	LcsCauseUnknownTarget
+	LcsCauseInvalidQuery
+)
+
+// 5GSM cause values
+type GsmCauseType uint8
+
+const (
+	GsmCauseInsufficientResources      GsmCauseType = 26
+	GsmCauseUnknownDnn                 GsmCauseType = 27
+	GsmCauseUnknownPduSesType          GsmCauseType = 28
+	GsmCauseRequestRejected            GsmCauseType = 31
+	GsmCauseServiceOptionNotSubscribed GsmCauseType = 33
+	GsmCauseRegularDeactivation        GsmCauseType = 36
+	GsmCauseInvalidPduSesId            GsmCauseType = 43
+	GsmCausePduSesTypeIpv4OnlyAllowed  GsmCauseType = 50
+	GsmCausePduSesTypeIpv6OnlyAllowed  GsmCauseType = 51
+	GsmCauseUnsupported5Qi             GsmCauseType = 59
+	GsmCauseUnknownDnnInSlice          GsmCauseType = 70
+)
+
+// Standardized slice/service types
+const (
+	SstEmbb  uint8 = 1
+	SstUrllc uint8 = 2
+	SstMiot  uint8 = 3
+	SstV2x   uint8 = 4
+)
+
+// S-NSSAI, a zero Sst means none was given
+type SNssaiType struct {
+	Sst uint8
+	// Slice differentiator, 24 bit, zero if absent
+	Sd uint32
+}
+
+type NssaiRejectCauseType uint8
+
+const (
+	NssaiNotAvailableInPlmn NssaiRejectCauseType = iota
+	NssaiNotAvailableInRegArea
+)
+
+type RejectedSNssaiType struct {
+	SNssai SNssaiType
+	Cause  NssaiRejectCauseType
+}
+
+type PduSesType uint8
+
+const (
+	PduSesIpv4 PduSesType = iota
+	PduSesIpv6
+	PduSesIpv4v6
+	PduSesEthernet
+	PduSesUnstructured
 )
 
 type EaMask uint8
@@ -96,19 +209,58 @@ type GmmHeader struct {
 type MobileIdType struct {
 	// SupiFormat uint8
 	// IdType
-	Mcc uint8
-	Mnc uint8
+	Mcc uint16
+	Mnc uint16
 	// Routing indicator
 	HomeNetPki uint8
 	Msin       uint
 }
 
+// PLMN identity, MCC and a 2 digit MNC
+type PlmnIdType struct {
+	Mcc uint16
+	Mnc uint16
+}
+
+// Tracking area identity
+type TaiType struct {
+	Plmn uint32
+	Tac  uint32
+}
+
+type RegistrationType uint8
+
+// 5GS registration type
+const (
+	RegInitial RegistrationType = iota
+	RegMobilityUpdating
+	RegPeriodicUpdating
+	RegEmergency
+)
+
+type GutiType struct {
+	Plmn        uint32
+	AmfRegionId uint16
+	AmfSetId    uint32
+	AmfPtr      uint32
+	Tmsi        uint32
+}
+
 type NASRegRequestMsg struct {
 	// Extended protocol discriminator
-	// 5GS registration type
 	// ngKsi
-	MobileId MobileIdType
-	SecCap   SecCapType
+	RegistrationType RegistrationType
+	MobileId         MobileIdType
+	SecCap           SecCapType
+	// Keep the N1 signalling connection after registration
+	FollowOnReq bool
+	// Default slices of the subscription if empty
+	RequestedNssai []SNssaiType
+	// Identifies the UE when updating a registration
+	Guti           GutiType
+	LastVisitedTai TaiType
+	// PLMN selected among those broadcast, the home PLMN if zero
+	SelectedPlmn PlmnIdType
 }
 
 type NASAuthRequestMsg struct {
@@ -125,34 +277,280 @@ type NASSecurityModeCommandMsg struct {
 	EaAlg        uint8
 	IaAlg        uint8
 	ReplaySecCap SecCapType
+	// PEI is to be sent in Security Mode Complete
+	ImeisvRequest bool
+}
+
+type NASSecurityModeCompleteMsg struct {
+	MobileId MobileIdType
+	// PEI if requested, imeisv- followed by the 16 digit IMEISV
+	Pei string
+}
+
+type NASRegAcceptMsg struct {
+	Guti          GutiType
+	TaiList       []TaiType
+	AllowedNssai  []SNssaiType
+	RejectedNssai []RejectedSNssaiType
+	// Registered for emergency services only
+	EmergencyRegistered bool
+}
+
+type NASRegCompleteMsg struct {
+	Guti GutiType
+}
+
+type ServiceRequestMsg struct {
+	// 5G-S-TMSI, sent integrity protected but not ciphered
+	STmsi       uint64
+	ServiceType uint8
+	PduSesIds   []uint8
+}
+
+type ServiceAcceptMsg struct {
+	PduSesIds []uint8
+}
+
+type ServiceRejectMsg struct {
+	Cause GmmCauseType
+}
+
+type NASRegRejectMsg struct {
+	Cause GmmCauseType
+}
+
+// Network name for display, empty if not known
+type NetworkNameType struct {
+	Full  string
+	Short string
+}
+
+// Network identity and time zone (NITZ)
+type NitzType struct {
+	UniversalTime time.Time
+	// Offset of local time from UTC in seconds, daylight saving included
+	TimeZone int32
+	// Hours of daylight saving in TimeZone
+	DaylightSaving uint8
+}
+
+// Zero and nil fields leave the configuration of the UE unchanged
+type ConfigurationUpdateCommandMsg struct {
+	// The UE answers with Configuration Update Complete
+	AckRequested  bool
+	Guti          GutiType
+	TaiList       []TaiType
+	AllowedNssai  []SNssaiType
+	RejectedNssai []RejectedSNssaiType
+	NetworkName   NetworkNameType
+	Nitz          *NitzType
+}
+
+type ConfigurationUpdateCompleteMsg struct {
+	// GUTI in use by the UE
+	Guti GutiType
 }
 
 type PDUSessionEstRequestMsg struct {
 	PduSesId   uint8
-	PduSesType uint8
+	PduSesType PduSesType
+	// Default DNN of the slice if empty
+	Dnn     string
+	SscMode uint8
+	// Default slice of the allowed NSSAI if not set
+	SNssai SNssaiType
+	// Session to the emergency DNN, Dnn and SNssai are ignored
+	Emergency bool
+}
+
+type PduAddressType struct {
+	Ipv4 netip.Addr
+	Ipv6 netip.Prefix
+}
+
+type QosRuleType struct {
+	Id         uint8
+	Qfi        uint8
+	Precedence uint8
+	Default    bool
+	// Empty matches all packets
+	PacketFilters []string
+}
+
+// Session AMBR in bit/s
+type AmbrType struct {
+	Uplink   uint64
+	Downlink uint64
 }
 
 type PDUSessionEstAcceptMsg struct {
+	PduSesId   uint8
+	PduSesType PduSesType
+	PduAddress PduAddressType
+	SscMode    uint8
+	QosRules   []QosRuleType
+	Ambr       AmbrType
+	Dnn        string
+	SNssai     SNssaiType
+}
+
+type PDUSessionEstRejectMsg struct {
+	PduSesId uint8
+	Cause    GsmCauseType
+}
+
+type PDUSessionModificationRequestMsg struct {
+	PduSesId uint8
+	// 5QI of the default QoS flow, unchanged if zero
+	FiveQi uint8
+	// Capped to the session AMBR of the DNN, unchanged if zero
+	Ambr AmbrType
+}
+
+type PDUSessionModificationCommandMsg struct {
+	PduSesId uint8
+	FiveQi   uint8
+	QosRules []QosRuleType
+	Ambr     AmbrType
+}
+
+type PDUSessionModificationRejectMsg struct {
+	PduSesId uint8
+	Cause    GsmCauseType
+}
+
+type PDUSessionModificationCompleteMsg struct {
 	PduSesId uint8
-	// PduAddress []byte
-	// SSC
-	// QoS
-	// AMBR
+}
+
+type PDUSessionReleaseRequestMsg struct {
+	PduSesId uint8
+	Cause    GsmCauseType
+}
+
+type PDUSessionReleaseRejectMsg struct {
+	PduSesId uint8
+	Cause    GsmCauseType
+}
+
+type PDUSessionResourceReleaseCommandMsg struct {
+	PduSesId uint8
+	Cause    GsmCauseType
+}
+
+type PDUSessionReleaseCompleteMsg struct {
+	PduSesId uint8
+}
+
+// Origin of a position estimate
+type PositionSourceType uint8
+
+const (
+	PositionSourceUnknown PositionSourceType = iota
+	PositionSourceGnss
+	PositionSourceCell
+	PositionSourceWlan
+	PositionSourceManual
+)
+
+// PositionType is a WGS 84 position estimate, the zero value means no
+// position. Tai and CellId are the serving cell as recorded by the network.
+type PositionType struct {
+	// Degrees
+	Latitude  float64
+	Longitude float64
+	// Meters above the ellipsoid
+	Altitude float64
+	// Horizontal radius in meters, zero if not known
+	Uncertainty float64
+	Source      PositionSourceType
+	// Time of the measurement
+	Time   time.Time
+	Tai    TaiType
+	CellId uint64
 }
 
 type LocationUpdateMsg struct {
 	Location string
+	Position PositionType
+	// Acknowledged by the network unless zero
+	Seq uint32
+}
+
+type LocationUpdateAckMsg struct {
+	Seq uint32
+}
+
+// BoundingBoxType selects positions by coordinates, a box with MinLongitude
+// greater than MaxLongitude crosses the antimeridian
+type BoundingBoxType struct {
+	MinLatitude  float64
+	MinLongitude float64
+	MaxLatitude  float64
+	MaxLongitude float64
+}
+
+// LocationQueryType filters a location history, zero fields do not filter
+type LocationQueryType struct {
+	// Time the network received the update
+	From time.Time
+	To   time.Time
+	Area BoundingBoxType
 }
 
 type LocationReportRequestMsg struct {
-	AmfUeNgapId AmfUeNgapIdType
-	RanUeNgapId uint32
+	// The requesting UE if empty, other UEs only for authorised LCS clients
+	TargetSupi string
+	Query      LocationQueryType
+	// MAC of the LCS client key over the authentication RAND and the target
+	ClientMac []byte
+}
+
+type LocationRecordType struct {
+	Location string
+	Position PositionType
+	// Time the network received the update
+	Time time.Time
 }
 
 type LocationReportResponseMsg struct {
-	AmfUeNgapId AmfUeNgapIdType
-	RanUeNgapId uint32
-	Locations   []string
+	TargetSupi string
+	Cause      LcsCauseType
+	// Oldest first
+	Locations []LocationRecordType
+}
+
+// Payload container types of NAS transport
+type PayloadContainerType uint8
+
+const (
+	PayloadN1SmInfo     PayloadContainerType = 1
+	PayloadSms          PayloadContainerType = 2
+	PayloadLpp          PayloadContainerType = 3
+	PayloadUePolicy     PayloadContainerType = 5
+	PayloadCIoTUserData PayloadContainerType = 8
+)
+
+// N1SmContainerType is the 5GSM message in an N1 SM information payload,
+// protected by the NAS transport
+type N1SmContainerType struct {
+	MessageType NasMsgType
+	Message     []byte
+}
+
+type ULNASTransportMsg struct {
+	PayloadContainerType PayloadContainerType
+	PayloadContainer     []byte
+	// N1 SM information and CIoT user data
+	PduSesId uint8
+}
+
+type DLNASTransportMsg struct {
+	PayloadContainerType PayloadContainerType
+	PayloadContainer     []byte
+	PduSesId             uint8
+	// Set if an uplink payload was not forwarded, the container is empty
+	Cause GmmCauseType
 }
 
 type PDUReqMsg struct {
@@ -163,3 +561,23 @@ type PDUResMsg struct {
 	PduSesId uint8
 	Response []byte
 }
+
+type RRCHandoverCommandMsg struct {
+	TargetAddr string
+	Crnti      uint32
+	// Tracking area of the target cell
+	Tai TaiType
+}
+
+type RRCHandoverCompleteMsg struct {
+	Crnti uint32
+}
+
+type UserDataMsg struct {
+	PduSesId uint8
+	Data     []byte
+}
+
+type RRCPagingMsg struct {
+	UePagingId uint64
+}
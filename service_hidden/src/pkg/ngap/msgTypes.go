@@ -18,6 +18,64 @@ const (
 	InitUEMessage
 	DownNASTrans
 	UpNASTrans
+	// Interface Management Messages
+	NGReset
+	NGResetAck
+	// UE Mobility Management Messages
+	HandoverRequired
+	HandoverCommand
+	HandoverPreparationFailure
+	HandoverRequest
+	HandoverRequestAck
+	HandoverFailure
+	HandoverNotify
+	// UE Context Management Messages
+	UEContextReleaseCommand
+	UEContextReleaseComplete
+	// Paging Messages
+	Paging
+	// UE Context Management Messages
+	UEContextReleaseRequest
+	// Interface Management Messages
+	ErrorIndication
+	// PDU Session Management Messages
+	PDUSessionResourceSetupRequest
+	PDUSessionResourceSetupResponse
+	PDUSessionResourceModifyRequest
+	PDUSessionResourceModifyResponse
+	PDUSessionResourceReleaseCommand
+	PDUSessionResourceReleaseResponse
+)
+
+// IsUeAssociated reports whether messages of the type carry UE NGAP IDs
+func IsUeAssociated(t NgapMsgType) bool {
+	switch t {
+	case NGSetupRequest, NGSetupResponse, NGSetupFailure, NGReset, NGResetAck, Paging, ErrorIndication:
+		return false
+	}
+	return true
+}
+
+type CauseType uint8
+
+const (
+	CauseUnspecified CauseType = iota
+	CauseTransportResourceUnavailable
+	CauseOmIntervention
+	CauseHandoverDesirableForRadioReasons
+	CauseUnknownTargetId
+	CauseHandoverCancelled
+	CauseSuccessfulHandover
+	CauseNormalRelease
+	CauseUserInactivity
+	CauseRadioConnectionWithUeLost
+	CauseUnknownLocalUeNgapId
+	CauseInconsistentUeNgapIds
+	CauseAbstractSyntaxError
+	CauseMessageNotCompatible
+	CauseAuthenticationFailure
+	CauseSlicesNotSupported
+	CausePlmnNotServed
 )
 
 type NgapHeader struct {
@@ -29,6 +87,10 @@ type NGSetupRequestMsg struct {
 	GranId uint32
 	Tac    uint32
 	Plmn   uint32
+	// Slices supported in the TA, the default slice of the AMF if empty
+	SupportedNssai []nas.SNssaiType
+	// Further PLMNs broadcast by the gNB when the RAN is shared
+	BroadcastPlmns []uint32
 }
 
 type NGSetupResponseMsg struct {
@@ -39,12 +101,43 @@ type NGSetupResponseMsg struct {
 	AmfPtr      uint32
 	AmfCap      uint8
 	Plmn        uint32
+	// PLMN support list
+	Nssai []nas.SNssaiType
+	// PLMNs of the gNB served by the AMF, each with its own GUAMI
+	ServedPlmns []uint32
+}
+
+type NGSetupFailureMsg struct {
+	Cause CauseType
+}
+
+type NGResetMsg struct {
+	Cause CauseType
+	// Reset all UE associations if empty
+	RanUeNgapIds []uint32
+}
+
+type NGResetAckMsg struct {
+	RanUeNgapIds []uint32
+}
+
+// NR cell global identity
+type NrCgiType struct {
+	Plmn uint32
+	// 36 bit NR cell identity, gNB ID followed by the cell
+	CellId uint64
+}
+
+// User location information of an NR cell, a zero TAI means the TAI of the gNB
+type UserLocationType struct {
+	Tai   nas.TaiType
+	NrCgi NrCgiType
 }
 
 type InitUEMessageMsg struct {
-	RanUeNgapId uint32
-	NasPdu      nas.GmmHeader
-	// Location
+	RanUeNgapId  uint32
+	NasPdu       nas.GmmHeader
+	UserLocation UserLocationType
 }
 
 type DownNASTransMsg struct {
@@ -54,8 +147,174 @@ type DownNASTransMsg struct {
 }
 
 type UpNASTransMsg struct {
+	AmfUeNgapId  AmfUeNgapIdType
+	RanUeNgapId  uint32
+	NasPdu       nas.GmmHeader
+	UserLocation UserLocationType
+}
+
+type HandoverRequiredMsg struct {
+	AmfUeNgapId  AmfUeNgapIdType
+	RanUeNgapId  uint32
+	Cause        CauseType
+	TargetGranId uint32
+	TargetTac    uint32
+	// Opaque to the AMF
+	SourceToTargetContainer []byte
+}
+
+type HandoverCommandMsg struct {
+	AmfUeNgapId AmfUeNgapIdType
+	RanUeNgapId uint32
+	// RRC Handover Command of the target, protected for the UE
+	NasPdu nas.GmmHeader
+}
+
+type HandoverPreparationFailureMsg struct {
+	AmfUeNgapId AmfUeNgapIdType
+	RanUeNgapId uint32
+	Cause       CauseType
+}
+
+type HandoverRequestMsg struct {
+	AmfUeNgapId             AmfUeNgapIdType
+	Cause                   CauseType
+	SecCap                  nas.SecCapType
+	PduSesIds               []uint8
+	SourceToTargetContainer []byte
+}
+
+type HandoverRequestAckMsg struct {
+	AmfUeNgapId AmfUeNgapIdType
+	RanUeNgapId uint32
+	// RRC Handover Command for the UE, protected by the AMF
+	TargetToSourceContainer []byte
+}
+
+type HandoverFailureMsg struct {
+	AmfUeNgapId AmfUeNgapIdType
+	Cause       CauseType
+}
+
+type HandoverNotifyMsg struct {
+	AmfUeNgapId  AmfUeNgapIdType
+	RanUeNgapId  uint32
+	UserLocation UserLocationType
+}
+
+type UEContextReleaseCommandMsg struct {
+	AmfUeNgapId AmfUeNgapIdType
+	RanUeNgapId uint32
+	Cause       CauseType
+}
+
+type UEContextReleaseCompleteMsg struct {
+	AmfUeNgapId AmfUeNgapIdType
+	RanUeNgapId uint32
+}
+
+type UEContextReleaseRequestMsg struct {
+	AmfUeNgapId AmfUeNgapIdType
+	RanUeNgapId uint32
+	PduSesIds   []uint8
+	Cause       CauseType
+}
+
+// UE NGAP IDs are left empty if the error is not UE associated
+type ErrorIndicationMsg struct {
+	AmfUeNgapId AmfUeNgapIdType
+	RanUeNgapId uint32
+	Cause       CauseType
+}
+
+// UeNgapIdsMsg decodes the UE NGAP IDs of any UE associated message
+type UeNgapIdsMsg struct {
+	AmfUeNgapId AmfUeNgapIdType
+	RanUeNgapId uint32
+}
+
+type PagingMsg struct {
+	// 5G-S-TMSI
+	UePagingId uint64
+	TaiList    []nas.TaiType
+}
+
+type QosFlowType struct {
+	Qfi    uint8
+	FiveQi uint8
+	// Allocation and retention priority, 1 is highest
+	ArpPriority uint8
+}
+
+// GTP-U tunnel endpoint of the N3 interface
+type TunnelInfoType struct {
+	Addr string
+	Teid uint32
+}
+
+type PDUSessionResourceSetupItem struct {
+	PduSesId   uint8
+	PduSesType nas.PduSesType
+	SNssai     nas.SNssaiType
+	QosFlows   []QosFlowType
+	ULTunnel   TunnelInfoType
+}
+
+type PDUSessionResourceSetupResult struct {
+	PduSesId uint8
+	DLTunnel TunnelInfoType
+}
+
+type PDUSessionResourceFailedItem struct {
+	PduSesId uint8
+	Cause    CauseType
+}
+
+// NAS PDU is forwarded to the UE as with Downlink NAS Transport
+type PDUSessionResourceSetupRequestMsg struct {
 	AmfUeNgapId AmfUeNgapIdType
 	RanUeNgapId uint32
 	NasPdu      nas.GmmHeader
-	// Location
+	PduSessions []PDUSessionResourceSetupItem
+}
+
+type PDUSessionResourceSetupResponseMsg struct {
+	AmfUeNgapId AmfUeNgapIdType
+	RanUeNgapId uint32
+	Setup       []PDUSessionResourceSetupResult
+	Failed      []PDUSessionResourceFailedItem
+}
+
+type PDUSessionResourceModifyItem struct {
+	PduSesId uint8
+	QosFlows []QosFlowType
+}
+
+// NAS PDU is optional
+type PDUSessionResourceModifyRequestMsg struct {
+	AmfUeNgapId AmfUeNgapIdType
+	RanUeNgapId uint32
+	NasPdu      nas.GmmHeader
+	PduSessions []PDUSessionResourceModifyItem
+}
+
+type PDUSessionResourceModifyResponseMsg struct {
+	AmfUeNgapId AmfUeNgapIdType
+	RanUeNgapId uint32
+	Modified    []uint8
+	Failed      []PDUSessionResourceFailedItem
+}
+
+// NAS PDU is optional
+type PDUSessionResourceReleaseCommandMsg struct {
+	AmfUeNgapId AmfUeNgapIdType
+	RanUeNgapId uint32
+	NasPdu      nas.GmmHeader
+	PduSessions []PDUSessionResourceFailedItem
+}
+
+type PDUSessionResourceReleaseResponseMsg struct {
+	AmfUeNgapId AmfUeNgapIdType
+	RanUeNgapId uint32
+	Released    []uint8
 }